@@ -86,6 +86,10 @@ func Authenticator(next http.Handler) http.Handler {
 			// save it to cache
 			ca.Set(key, a, 30*time.Minute)
 
+			// the plan is used to resolve the account quotas
+			cacheAccountPlan(acct.ID, activePlan(acct))
+			cacheAccountPastDue(acct.ID, acct.PastDueSince)
+
 			ctx = context.WithValue(ctx, ContextAuth, a)
 		}
 
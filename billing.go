@@ -5,15 +5,13 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/jlb922/gosaas/data"
 	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/payment"
 	"github.com/jlb922/gosaas/queue"
 	stripe "github.com/stripe/stripe-go"
-	"github.com/stripe/stripe-go/card"
-	"github.com/stripe/stripe-go/customer"
-	"github.com/stripe/stripe-go/invoice"
-	"github.com/stripe/stripe-go/sub"
 )
 
 // SetStripeKey sets the Stripe Key.
@@ -21,9 +19,18 @@ func SetStripeKey(key string) {
 	stripe.Key = key
 }
 
-// Billing handles everything related to the billing requests
+// Billing handles everything related to the billing requests. The Provider
+// defaults to the one set via payment.SetProvider.
 type Billing struct {
-	DB *data.DB
+	DB       *data.DB
+	Provider payment.Provider
+}
+
+func (b Billing) provider() payment.Provider {
+	if b.Provider != nil {
+		return b.Provider
+	}
+	return payment.Current()
 }
 
 // BillingOverview represents if an account is a paid customer or not
@@ -38,7 +45,7 @@ type BillingOverview struct {
 	CurrentPlan    *data.BillingPlan `json:"currentPlan"`
 	Seats          int               `json:"seats"`
 	Logins         []model.User      `json:"logins"`
-	NextInvoice    *stripe.Invoice   `json:"nextInvoice"`
+	NextInvoice    *payment.Invoice  `json:"nextInvoice"`
 }
 
 // BillingCardData represents a Stripe credit card
@@ -53,12 +60,26 @@ type BillingCardData struct {
 	Expiration string `json:"expiration"`
 }
 
+func newBillingCardData(c payment.Card) BillingCardData {
+	return BillingCardData{
+		ID:         c.ID,
+		Name:       c.Name,
+		Number:     c.Last4,
+		Month:      fmt.Sprintf("%d", c.ExpMonth),
+		Year:       fmt.Sprintf("%d", c.ExpYear),
+		Expiration: fmt.Sprintf("%d / %d", c.ExpMonth, c.ExpYear),
+		Brand:      c.Brand,
+	}
+}
+
 func newBilling() *Route {
 	var b interface{} = Billing{}
 	return &Route{
 		AllowCrossOrigin: true,
 		Logger:           true,
+		WithDB:           true,
 		MinimumRole:      model.RoleFree,
+		Idempotent:       true,
 		Handler:          b.(http.Handler),
 	}
 }
@@ -67,7 +88,12 @@ func (b Billing) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var head string
 	head, r.URL.Path = ShiftPath(r.URL.Path)
 	if r.Method == http.MethodGet {
-		if head == "invoices" {
+		if head == "changeplan" {
+			head, r.URL.Path = ShiftPath(r.URL.Path)
+			if head == "preview" {
+				b.previewPlan(w, r)
+			}
+		} else if head == "invoices" {
 			head, r.URL.Path = ShiftPath(r.URL.Path)
 			if head == "" {
 				b.invoices(w, r)
@@ -79,8 +105,6 @@ func (b Billing) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else if r.Method == http.MethodPost {
 		if head == "changeplan" {
 			b.changePlan(w, r)
-		} else if head == "webhooks" {
-			b.stripe(w, r)
 		}
 	} else if r.Method == http.MethodDelete {
 		if head == "card" {
@@ -115,7 +139,7 @@ func (b Billing) Overview(accountID int64) (*BillingOverview, error) {
 
 		// if they are on trial, we set the current plan to
 		// that so the UI can based permissions on that plan.
-		if account.TrialInfo.IsTrial {
+		if account.TrialInfo.IsActive(time.Now()) {
 			if p, ok := data.GetPlan(account.TrialInfo.Plan); ok {
 				ov.CurrentPlan = &p
 			}
@@ -126,8 +150,8 @@ func (b Billing) Overview(accountID int64) (*BillingOverview, error) {
 		return ov, nil
 	}
 
-	// getting stripe customer
-	cus, err := customer.Get(account.StripeID, nil)
+	// getting the customer at the payment provider
+	cus, err := b.provider().GetCustomer(account.StripeID)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get stripe customer: %v", err)
 	}
@@ -140,23 +164,17 @@ func (b Billing) Overview(accountID int64) (*BillingOverview, error) {
 		ov.CurrentPlan = &p
 	}
 
-	cards := card.List(&stripe.CardListParams{Customer: &account.StripeID})
-	for cards.Next() {
-		c := cards.Card()
-		if !c.Deleted {
-			ov.Cards = append(ov.Cards, BillingCardData{
-				ID:         c.ID,
-				Name:       c.Name,
-				Number:     c.Last4,
-				Month:      fmt.Sprintf("%d", c.ExpMonth),
-				Year:       fmt.Sprintf("%d", c.ExpYear),
-				Expiration: fmt.Sprintf("%d / %d", c.ExpMonth, c.ExpYear),
-				Brand:      string(c.Brand),
-			})
-		}
+	cards, err := b.provider().Cards(account.StripeID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the cards: %v", err)
+	}
+
+	ov.Cards = make([]BillingCardData, 0, len(cards))
+	for _, c := range cards {
+		ov.Cards = append(ov.Cards, newBillingCardData(c))
 	}
 
-	i, err := invoice.GetNext(&stripe.InvoiceParams{Customer: &account.StripeID})
+	i, err := b.provider().NextInvoice(account.StripeID)
 	if err != nil {
 		return nil, fmt.Errorf("unable to fetch next invoice: %v", err)
 	}
@@ -167,9 +185,7 @@ func (b Billing) Overview(accountID int64) (*BillingOverview, error) {
 }
 
 func (b Billing) changeQuantity(stripeID, subID string, qty int) error {
-	q := int64(qty)
-	p := &stripe.SubscriptionParams{Customer: &stripeID, Quantity: &q}
-	_, err := sub.Update(subID, p)
+	_, err := b.provider().UpdateSubscription(subID, payment.SubscriptionParams{CustomerID: stripeID, Quantity: qty})
 	return err
 }
 
@@ -196,7 +212,7 @@ func (b Billing) userRoleChanged(db data.DB, accountID int64, oldRole, newRole m
 				}
 
 				// ensure that the charges will be immediate and not on next billing date
-				if err := queue.Enqueue(queue.TaskCreateInvoice, acct.StripeID); err != nil {
+				if _, err := queue.EnqueueIn(queue.TaskCreateInvoice, acct.StripeID, queue.InvoiceDelay); err != nil {
 					return paid, err
 				}
 
@@ -237,10 +253,7 @@ type BillingNewCustomer struct {
 
 // Start TODO - document this
 func (b Billing) Start(bc BillingNewCustomer) error {
-	p := &stripe.CustomerParams{Email: stripe.String(bc.Email)}
-	p.SetSource(bc.StripeToken)
-
-	c, err := customer.New(p)
+	c, err := b.provider().CreateCustomer(bc.Email, bc.StripeToken)
 	if err != nil {
 		return fmt.Errorf("unable to create the customer: %v", err)
 	}
@@ -279,22 +292,13 @@ func (b Billing) Start(bc BillingNewCustomer) error {
 		return fmt.Errorf("unable to find this plan %s in the 'current' pricing se", plan)
 	}
 
-	seatsptr := int64(seats)
-	subp := &stripe.SubscriptionParams{
-		Customer: &c.ID,
-		Plan:     stripe.String(bp.StripeID),
-		Quantity: &seatsptr,
-	}
-
-	if bc.TrialDays > 0 {
-		subp.TrialPeriodDays = stripe.Int64(int64(bc.TrialDays))
-	}
-
-	if len(bc.Coupon) > 0 {
-		subp.Coupon = &bc.Coupon
-	}
-
-	s, err := sub.New(subp)
+	s, err := b.provider().CreateSubscription(payment.SubscriptionParams{
+		CustomerID: c.ID,
+		Plan:       bp.StripeID,
+		Quantity:   seats,
+		TrialDays:  bc.TrialDays,
+		Coupon:     bc.Coupon,
+	})
 	if err != nil {
 		return fmt.Errorf("unable to create the subscription: %v", err)
 	}
@@ -304,6 +308,8 @@ func (b Billing) Start(bc BillingNewCustomer) error {
 		return fmt.Errorf("unable to convert the account to a paid account: %v", err)
 	}
 
+	cacheAccountPlan(acct.ID, bc.Plan)
+
 	//TODO: Trigger a new customer event
 
 	return nil
@@ -316,10 +322,7 @@ func (b Billing) Convert(bc BillingNewCustomer) error {
 		return fmt.Errorf("unable to get the account for this account ID: %d -> %v", bc.AccountID, err)
 	}
 
-	p := &stripe.CustomerParams{Email: stripe.String(bc.Email)}
-	p.SetSource(bc.StripeToken)
-
-	c, err := customer.New(p)
+	c, err := b.provider().CreateCustomer(bc.Email, bc.StripeToken)
 	if err != nil {
 		return fmt.Errorf("unable to create the customer: %v", err)
 	}
@@ -359,22 +362,13 @@ func (b Billing) Convert(bc BillingNewCustomer) error {
 		return fmt.Errorf("unable to find this plan %s in the 'current' pricing se", plan)
 	}
 
-	seatsptr := int64(seats)
-	subp := &stripe.SubscriptionParams{
-		Customer: &c.ID,
-		Plan:     stripe.String(bp.StripeID),
-		Quantity: &seatsptr,
-	}
-
-	if bc.TrialDays > 0 {
-		subp.TrialPeriodDays = stripe.Int64(int64(bc.TrialDays))
-	}
-
-	if len(bc.Coupon) > 0 {
-		subp.Coupon = &bc.Coupon
-	}
-
-	s, err := sub.New(subp)
+	s, err := b.provider().CreateSubscription(payment.SubscriptionParams{
+		CustomerID: c.ID,
+		Plan:       bp.StripeID,
+		Quantity:   seats,
+		TrialDays:  bc.TrialDays,
+		Coupon:     bc.Coupon,
+	})
 	if err != nil {
 		return fmt.Errorf("unable to create the subscription: %v", err)
 	}
@@ -384,6 +378,8 @@ func (b Billing) Convert(bc BillingNewCustomer) error {
 		return fmt.Errorf("unable to convert the account to a paid account: %v", err)
 	}
 
+	cacheAccountPlan(acct.ID, bc.Plan)
+
 	//TODO: Trigger a new customer event
 
 	return nil
@@ -433,7 +429,7 @@ func (b Billing) changePlan(w http.ResponseWriter, r *http.Request) {
 	// did they cancelled
 	if newLevel == 0 {
 		// we need to cancel their subscriptions
-		if _, err := sub.Cancel(account.SubscriptionID, nil); err != nil {
+		if err := b.provider().CancelSubscription(account.SubscriptionID); err != nil {
 			Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
@@ -442,6 +438,9 @@ func (b Billing) changePlan(w http.ResponseWriter, r *http.Request) {
 			Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+
+		cacheAccountPlan(account.ID, "")
+		cacheAccountPastDue(account.ID, time.Time{})
 	} else {
 		if data.IsYearly {
 			plan += "_yearly"
@@ -454,11 +453,10 @@ func (b Billing) changePlan(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		seatsptr := int64(seats)
-		subParams := &stripe.SubscriptionParams{
-			Customer: &account.StripeID,
-			Plan:     &plan,
-			Quantity: &seatsptr,
+		subParams := payment.SubscriptionParams{
+			CustomerID: account.StripeID,
+			Plan:       plan,
+			Quantity:   seats,
 		}
 
 		// if we upgrade we need to change billing cycle date
@@ -471,10 +469,12 @@ func (b Billing) changePlan(w http.ResponseWriter, r *http.Request) {
 
 		if upgraded {
 			// queue an invoice create for this upgrade
-			queue.Enqueue(queue.TaskCreateInvoice, account.StripeID)
+			if _, err := queue.EnqueueIn(queue.TaskCreateInvoice, account.StripeID, queue.InvoiceDelay); err != nil {
+				log.Println("unable to queue the invoice creation", account.StripeID, err)
+			}
 		}
 
-		if _, err := sub.Update(account.SubscriptionID, subParams); err != nil {
+		if _, err := b.provider().UpdateSubscription(account.SubscriptionID, subParams); err != nil {
 			Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
@@ -483,6 +483,8 @@ func (b Billing) changePlan(w http.ResponseWriter, r *http.Request) {
 			Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+
+		cacheAccountPlan(account.ID, plan)
 		Respond(w, r, http.StatusOK, true)
 	}
 }
@@ -504,24 +506,14 @@ func (b Billing) updateCard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if c, err := card.Update(data.ID, &stripe.CardParams{
-		Customer: &account.StripeID,
-		ExpMonth: &data.Month,
-		ExpYear:  &data.Month,
-		CVC:      &data.CVC,
+	if c, err := b.provider().UpdateCard(account.StripeID, data.ID, payment.CardParams{
+		Name:     data.Name,
+		ExpMonth: data.Month,
+		ExpYear:  data.Year,
 	}); err != nil {
 		Respond(w, r, http.StatusInternalServerError, err)
 	} else {
-		card := BillingCardData{
-			ID:         c.ID,
-			Name:       c.Name,
-			Number:     c.Last4,
-			Month:      fmt.Sprintf("%d", c.ExpMonth),
-			Year:       fmt.Sprintf("%d", c.ExpYear),
-			Expiration: fmt.Sprintf("%d / %d", c.ExpMonth, c.ExpYear),
-			Brand:      string(c.Brand),
-		}
-		Respond(w, r, http.StatusOK, card)
+		Respond(w, r, http.StatusOK, newBillingCardData(*c))
 	}
 }
 
@@ -542,22 +534,16 @@ func (b Billing) addCard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if c, err := card.New(&stripe.CardParams{
-		Customer: &account.StripeID,
-		Name:     &data.Name,
-		Number:   &data.Number,
-		ExpMonth: &data.Month,
-		ExpYear:  &data.Year,
-		CVC:      &data.CVC}); err != nil {
+	if c, err := b.provider().AddCard(account.StripeID, payment.CardParams{
+		Name:     data.Name,
+		Number:   data.Number,
+		ExpMonth: data.Month,
+		ExpYear:  data.Year,
+		CVC:      data.CVC,
+	}); err != nil {
 		Respond(w, r, http.StatusInternalServerError, err)
 	} else {
-		card := BillingCardData{
-			ID:         c.ID,
-			Number:     c.Last4,
-			Expiration: fmt.Sprintf("%d / %d", c.ExpMonth, c.ExpYear),
-			Brand:      string(c.Brand),
-		}
-		Respond(w, r, http.StatusOK, card)
+		Respond(w, r, http.StatusOK, newBillingCardData(*c))
 	}
 }
 
@@ -574,7 +560,7 @@ func (b Billing) deleteCard(w http.ResponseWriter, r *http.Request) {
 
 	cardID, _ := ShiftPath(r.URL.Path)
 
-	if _, err := card.Del(cardID, &stripe.CardParams{Customer: &account.StripeID}); err != nil {
+	if err := b.provider().DeleteCard(account.StripeID, cardID); err != nil {
 		Respond(w, r, http.StatusInternalServerError, err)
 	} else {
 		Respond(w, r, http.StatusOK, true)
@@ -592,11 +578,10 @@ func (b Billing) invoices(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var invoices []*stripe.Invoice
-
-	iter := invoice.List(&stripe.InvoiceListParams{Customer: &account.StripeID})
-	for iter.Next() {
-		invoices = append(invoices, iter.Invoice())
+	invoices, err := b.provider().Invoices(account.StripeID)
+	if err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
 	}
 
 	Respond(w, r, http.StatusOK, invoices)
@@ -613,7 +598,7 @@ func (b Billing) getNextInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	i, err := invoice.GetNext(&stripe.InvoiceParams{Customer: &account.StripeID})
+	i, err := b.provider().NextInvoice(account.StripeID)
 	if err != nil {
 		Respond(w, r, http.StatusInternalServerError, err)
 		return
@@ -621,73 +606,48 @@ func (b Billing) getNextInvoice(w http.ResponseWriter, r *http.Request) {
 	Respond(w, r, http.StatusOK, i)
 }
 
-// StripeWebhook is used to grab data sent by Stripe for a webhook
-type StripeWebhook struct {
-	Event stripe.Event `json:"event"`
-}
-
-// WebhookData used when stripe webhook is call
-type WebhookData struct {
-	ID   string            `json:"id"`
-	Type string            `json:"type"`
-	Data WebhookDataObject `json:"data"`
-}
-
-// WebhookDataObject is the container for the object received
-type WebhookDataObject struct {
-	Object WebhookDataObjectData `json:"object"`
-}
-
-// WebhookDataObjectData is the object being sent by stripe
-type WebhookDataObjectData struct {
-	ID           string `json:"id"`
-	Customer     string `json:"customer"`
-	Subscription string `json:"subscription"`
-	Closed       bool   `json:"closed"`
-}
-
-func (b Billing) stripe(w http.ResponseWriter, r *http.Request) {
+// previewPlan returns the next invoice with the prorations of a plan change,
+// i.e. GET /billing/changeplan/preview?plan=pro&isYearly=true
+func (b Billing) previewPlan(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	keys := ctx.Value(ContextAuth).(Auth)
 	db := ctx.Value(ContextDatabase).(*data.DB)
 
-	// no matter what happen, Stripe wants us to send a 200
-	defer w.Write([]byte("ok"))
-
-	var data WebhookData
-	if err := ParseBody(r.Body, &data); err != nil {
-		log.Println(err)
+	plan := r.URL.Query().Get("plan")
+	if len(plan) == 0 || plan == "free" {
+		Respond(w, r, http.StatusBadRequest, fmt.Errorf("the plan to preview is required"))
 		return
 	}
+	if r.URL.Query().Get("isYearly") == "true" {
+		plan += "_yearly"
+	}
 
-	if data.Type == "customer.subscription.deleted" {
-		subID := data.Data.Object.ID
-		if len(subID) == 0 {
-			log.Println(fmt.Errorf("no subscription found to this customer.subscription.deleted %s", data.ID))
-			return
-		}
-
-		stripeID := data.Data.Object.Customer
-		if len(stripeID) == 0 {
-			log.Println(fmt.Errorf("no customer found to this invoice.payment_succeeded %s", data.ID))
-			return
-		}
+	account, err := db.Users.GetDetail(keys.AccountID)
+	if err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
+	} else if !account.IsPaid() {
+		Respond(w, r, http.StatusBadRequest, fmt.Errorf("the account has no subscription to change"))
+		return
+	}
 
-		// check if it's a failed payment_succeeded
-		account, err := db.Users.GetByStripe(stripeID)
-		if err != nil {
-			log.Println(fmt.Errorf("no customer matches stripe id %s", stripeID))
-			return
+	seats := 0
+	for _, u := range account.Users {
+		if u.Role < model.RoleFree {
+			seats++
 		}
+	}
 
-		if len(account.SubscriptionID) > 0 {
-			//TODO: Send emails
-
-			if err := db.Users.Cancel(account.ID); err != nil {
-				log.Println(fmt.Errorf("unable to cancel this account %v", account.ID))
-				return
-			}
-		}
+	i, err := b.provider().PreviewProration(account.SubscriptionID, payment.SubscriptionParams{
+		CustomerID: account.StripeID,
+		Plan:       plan,
+		Quantity:   seats,
+	})
+	if err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
 	}
+	Respond(w, r, http.StatusOK, i)
 }
 
 func (b Billing) cancel(w http.ResponseWriter, r *http.Request) {
@@ -711,7 +671,7 @@ func (b Billing) cancel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := sub.Cancel(account.SubscriptionID, nil); err != nil {
+	if err := b.provider().CancelSubscription(account.SubscriptionID); err != nil {
 		Respond(w, r, http.StatusInternalServerError, err)
 		return
 	}
@@ -721,5 +681,8 @@ func (b Billing) cancel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cacheAccountPlan(account.ID, "")
+	cacheAccountPastDue(account.ID, time.Time{})
+
 	Respond(w, r, http.StatusOK, true)
 }
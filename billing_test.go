@@ -0,0 +1,180 @@
+package gosaas
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/payment"
+)
+
+// testAccounts keeps the accounts in memory for the billing tests.
+type testAccounts struct {
+	data.UserServices
+	accounts map[int64]*model.Account
+}
+
+func (ta *testAccounts) GetDetail(id int64) (*model.Account, error) {
+	acct, ok := ta.accounts[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	a := *acct
+	return &a, nil
+}
+
+func (ta *testAccounts) GetByStripe(stripeID string) (*model.Account, error) {
+	for id, a := range ta.accounts {
+		if a.StripeID == stripeID {
+			return ta.GetDetail(id)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (ta *testAccounts) SetSeats(id int64, seats int) error {
+	ta.accounts[id].Seats = seats
+	return nil
+}
+
+func (ta *testAccounts) ConvertToPaid(id int64, stripeID, subID, plan string, yearly bool, seats int) error {
+	a := ta.accounts[id]
+	a.StripeID, a.SubscriptionID, a.Plan, a.IsYearly, a.Seats = stripeID, subID, plan, yearly, seats
+	a.TrialInfo.IsTrial = false
+	return nil
+}
+
+func (ta *testAccounts) ChangePlan(id int64, plan string, yearly bool) error {
+	a := ta.accounts[id]
+	a.Plan, a.IsYearly = plan, yearly
+	return nil
+}
+
+func (ta *testAccounts) Cancel(id int64) error {
+	a := ta.accounts[id]
+	a.SubscriptionID, a.Plan, a.PastDueSince = "", "", time.Time{}
+	return nil
+}
+
+func (ta *testAccounts) SetPastDue(id int64, since time.Time) error {
+	ta.accounts[id].PastDueSince = since
+	return nil
+}
+
+func (ta *testAccounts) SetTrial(id int64, trial model.Trial) error {
+	ta.accounts[id].TrialInfo = trial
+	return nil
+}
+
+func (ta *testAccounts) Trials(endsBefore time.Time) ([]model.Account, error) {
+	var accounts []model.Account
+	for _, a := range ta.accounts {
+		if a.TrialInfo.IsTrial && a.TrialInfo.End.Before(endsBefore) {
+			accounts = append(accounts, *a)
+		}
+	}
+	return accounts, nil
+}
+
+func newBillingTest() (*payment.Fake, *testAccounts) {
+	data.AddPlan(data.BillingPlan{ID: "unit_starter", StripeID: "starter", Name: "starter", Version: "current"})
+	data.AddPlan(data.BillingPlan{ID: "unit_pro", StripeID: "pro", Name: "pro", Version: "current"})
+
+	f := payment.NewFake()
+	f.Prices["starter"] = 1000
+	f.Prices["pro"] = 3000
+
+	ta := &testAccounts{accounts: map[int64]*model.Account{
+		1: {ID: 1, Email: "owner@test.com", Users: []model.User{
+			{ID: 1, Email: "owner@test.com", Role: model.RoleAdmin},
+			{ID: 2, Email: "user@test.com", Role: model.RoleUser},
+			{ID: 3, Email: "free@test.com", Role: model.RoleFree},
+		}},
+	}}
+	return f, ta
+}
+
+func Test_Billing_Start(t *testing.T) {
+	f, ta := newBillingTest()
+	b := Billing{DB: &data.DB{Users: ta}, Provider: f}
+
+	err := b.Start(BillingNewCustomer{AccountID: 1, Email: "owner@test.com", Plan: "starter", StripeToken: "tok_visa", IsPerSeat: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acct := ta.accounts[1]
+	if !acct.IsPaid() || acct.Plan != "starter" || acct.Seats != 0 {
+		t.Fatalf("expected a paid account with 0 seats, got %+v", acct)
+	}
+
+	ov, err := b.Overview(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ov.IsNew || len(ov.Cards) != 1 || ov.Cards[0].Number != "4242" || ov.NextInvoice.Total != 0 {
+		t.Errorf("unexpected overview %+v", ov)
+	}
+
+	if err := b.Start(BillingNewCustomer{AccountID: 1, Plan: "unknown", StripeToken: "tok_visa"}); err == nil {
+		t.Error("expected an unknown plan to fail")
+	}
+}
+
+func Test_Billing_ChangePlan(t *testing.T) {
+	f, ta := newBillingTest()
+	db := &data.DB{Users: ta}
+	if err := (Billing{DB: db, Provider: f}).Start(BillingNewCustomer{AccountID: 1, Plan: "starter", StripeToken: "tok_visa", IsPerSeat: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	payment.SetProvider(f)
+	defer payment.SetProvider(nil)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ContextDatabase, db)
+		ctx = context.WithValue(ctx, ContextAuth, Auth{AccountID: 1, UserID: 1, Role: model.RoleAdmin})
+		ctx = context.WithValue(ctx, ContextContentIsJSON, true)
+		req := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		Billing{}.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request("GET", "/changeplan/preview?plan=pro", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	var preview payment.Invoice
+	if err := json.Unmarshal(rec.Body.Bytes(), &preview); err != nil {
+		t.Fatal(err)
+	}
+	if preview.Total != 0 || !preview.Lines[0].Proration {
+		t.Errorf("expected the prorations and 0 pro seats, got %+v", preview)
+	}
+
+	if rec := request("POST", "/changeplan", `{"plan":"pro"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ta.accounts[1].Plan != "pro" {
+		t.Errorf("expected the pro plan, got %s", ta.accounts[1].Plan)
+	}
+	if next, _ := f.NextInvoice(ta.accounts[1].StripeID); next.Total != preview.Total {
+		t.Errorf("expected the next invoice to match the preview %d, got %d", preview.Total, next.Total)
+	}
+
+	request("POST", "/changeplan", `{"plan":"free"}`)
+	if ta.accounts[1].IsPaid() {
+		t.Error("expected the subscription to be canceled")
+	}
+	if _, err := f.NextInvoice(ta.accounts[1].StripeID); err != payment.ErrNotFound {
+		t.Errorf("expected no next invoice once canceled, got %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package gosaas
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/queue"
+	"github.com/jlb922/gosaas/queue/email"
+	stripe "github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/webhook"
+)
+
+// The Stripe events handled by the billing webhook, they are forwarded to the
+// account's subscribers of the outbound webhooks under the same name.
+const (
+	StripeSubscriptionDeleted  = "customer.subscription.deleted"
+	StripeSubscriptionUpdated  = "customer.subscription.updated"
+	StripeTrialWillEnd         = "customer.subscription.trial_will_end"
+	StripeInvoicePaid          = "invoice.payment_succeeded"
+	StripeInvoicePaymentFailed = "invoice.payment_failed"
+	StripeChargeRefunded       = "charge.refunded"
+)
+
+// StripeWebhook is used to grab data sent by Stripe for a webhook
+type StripeWebhook struct {
+	Event stripe.Event `json:"event"`
+}
+
+// WebhookData used when stripe webhook is call
+type WebhookData struct {
+	ID   string            `json:"id"`
+	Type string            `json:"type"`
+	Data WebhookDataObject `json:"data"`
+}
+
+// WebhookDataObject is the container for the object received
+type WebhookDataObject struct {
+	Object WebhookDataObjectData `json:"object"`
+}
+
+// WebhookDataObjectData is the object being sent by stripe, a subscription,
+// an invoice or a charge depending on the event. The amounts are in cents.
+type WebhookDataObjectData struct {
+	ID                 string          `json:"id"`
+	Customer           string          `json:"customer"`
+	Subscription       string          `json:"subscription"`
+	Closed             bool            `json:"closed"`
+	Status             string          `json:"status"`
+	Plan               WebhookDataPlan `json:"plan"`
+	Quantity           int             `json:"quantity"`
+	TrialEnd           int64           `json:"trial_end"`
+	AmountDue          int64           `json:"amount_due"`
+	AmountPaid         int64           `json:"amount_paid"`
+	AmountRefunded     int64           `json:"amount_refunded"`
+	Currency           string          `json:"currency"`
+	HostedInvoiceURL   string          `json:"hosted_invoice_url"`
+	NextPaymentAttempt int64           `json:"next_payment_attempt"`
+}
+
+// WebhookDataPlan is the Stripe plan of a subscription.
+type WebhookDataPlan struct {
+	ID string `json:"id"`
+}
+
+// maxStripeEventSize limits the size of the Stripe events.
+const maxStripeEventSize = 1 << 20
+
+// newStripeWebhook handles the public POST /stripe/webhooks, Stripe cannot
+// authenticate on the billing route.
+func newStripeWebhook() *Route {
+	return &Route{
+		Logger:      true,
+		WithDB:      true,
+		MinimumRole: model.RolePublic,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			head, _ := ShiftPath(r.URL.Path)
+			if head != "webhooks" || r.Method != http.MethodPost {
+				notFound(w)
+				return
+			}
+			Billing{}.stripe(w, r)
+		}),
+	}
+}
+
+// stripe handles the events posted by Stripe. They are verified with the
+// endpoint's signing secret and processed once, the events older than 5
+// minutes or already recorded are ignored. Stripe resends the events until
+// they are processed without error.
+func (b Billing) stripe(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxStripeEventSize))
+	if err != nil {
+		Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	secret := config.Current.StripeWebhookSecret
+	if len(secret) == 0 {
+		Respond(w, r, http.StatusForbidden, errors.New("the stripe webhook secret is not configured"))
+		return
+	}
+	if err := webhook.ValidatePayload(payload, r.Header.Get("Stripe-Signature"), secret); err != nil {
+		Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var evt WebhookData
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		Respond(w, r, http.StatusBadRequest, err)
+		return
+	} else if len(evt.ID) == 0 {
+		Respond(w, r, http.StatusBadRequest, errors.New("the event has no id"))
+		return
+	}
+
+	db, ok := r.Context().Value(ContextDatabase).(*data.DB)
+	if !ok || db.Billing == nil {
+		Respond(w, r, http.StatusInternalServerError, errors.New("the billing events are not available"))
+		return
+	}
+
+	if recorded, err := db.Billing.RecordEvent(evt.ID, evt.Type); err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
+	} else if !recorded {
+		// a replayed or resent event
+		Respond(w, r, http.StatusOK, true)
+		return
+	}
+
+	if err := b.handleEvent(db, evt); err != nil {
+		if err := db.Billing.ForgetEvent(evt.ID); err != nil {
+			log.Println("unable to forget the stripe event", evt.ID, err)
+		}
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	Respond(w, r, http.StatusOK, true)
+}
+
+func (b Billing) handleEvent(db *data.DB, evt WebhookData) error {
+	obj := evt.Data.Object
+
+	switch evt.Type {
+	case StripeSubscriptionDeleted, StripeSubscriptionUpdated, StripeTrialWillEnd,
+		StripeInvoicePaid, StripeInvoicePaymentFailed, StripeChargeRefunded:
+	default:
+		return nil
+	}
+
+	if len(obj.Customer) == 0 {
+		log.Println(fmt.Errorf("no customer found to this %s %s", evt.Type, evt.ID))
+		return nil
+	}
+
+	account, err := db.Users.GetByStripe(obj.Customer)
+	if err == sql.ErrNoRows {
+		// not a customer of this app
+		log.Println(fmt.Errorf("no customer matches stripe id %s", obj.Customer))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	switch evt.Type {
+	case StripeSubscriptionDeleted:
+		if len(account.SubscriptionID) == 0 || account.SubscriptionID != obj.ID {
+			return nil
+		}
+
+		if err := db.Users.Cancel(account.ID); err != nil {
+			return fmt.Errorf("unable to cancel this account %v: %v", account.ID, err)
+		}
+		cacheAccountPlan(account.ID, "")
+		cacheAccountPastDue(account.ID, time.Time{})
+
+		sendBillingEmail(account, email.TemplateCanceled, model.EmailTransactional, map[string]interface{}{
+			"URL": config.Current.AppURL,
+		})
+	case StripeSubscriptionUpdated:
+		if account.SubscriptionID != obj.ID {
+			return nil
+		}
+		if err := syncSubscription(db, account, obj); err != nil {
+			return err
+		}
+		if obj.Status == "active" && account.IsPastDue() {
+			if err := stopDunning(db, account); err != nil {
+				return err
+			}
+		}
+	case StripeTrialWillEnd:
+		sendBillingEmail(account, email.TemplateTrialEnding, model.EmailBilling, map[string]interface{}{
+			"Date": formatBillingDate(obj.TrialEnd),
+			"URL":  config.Current.AppURL,
+		})
+	case StripeInvoicePaid:
+		if account.IsPastDue() {
+			if err := stopDunning(db, account); err != nil {
+				return err
+			}
+		}
+
+		// nothing was charged during the trial
+		if obj.AmountPaid > 0 {
+			sendBillingEmail(account, email.TemplateReceipt, model.EmailBilling, map[string]interface{}{
+				"Plan":   account.Plan,
+				"Amount": obj.AmountPaid,
+				"Date":   formatBillingDate(time.Now().Unix()),
+				"URL":    obj.HostedInvoiceURL,
+			})
+		}
+	case StripeInvoicePaymentFailed:
+		// the following failures are Stripe's retries, the reminders are
+		// scheduled from the first one
+		if !account.IsPastDue() {
+			if err := startDunning(db, account); err != nil {
+				return err
+			}
+		}
+
+		data := map[string]interface{}{
+			"Amount": obj.AmountDue,
+			"URL":    obj.HostedInvoiceURL,
+		}
+		if obj.NextPaymentAttempt > 0 {
+			data["NextAttempt"] = formatBillingDate(obj.NextPaymentAttempt)
+		}
+		sendBillingEmail(account, email.TemplatePaymentFailed, model.EmailTransactional, data)
+	case StripeChargeRefunded:
+		sendBillingEmail(account, email.TemplateRefund, model.EmailBilling, map[string]interface{}{
+			"Amount": obj.AmountRefunded,
+		})
+	}
+
+	if db.Webhooks != nil {
+		SendAccountWebhook(db.Webhooks, account.ID, evt.Type, obj)
+	}
+	return nil
+}
+
+// syncSubscription applies the plan and quantity changes made at Stripe, i.e.
+// from its dashboard, to the account.
+func syncSubscription(db *data.DB, account *model.Account, obj WebhookDataObjectData) error {
+	if p, ok := data.GetPlanByStripeID(obj.Plan.ID); ok {
+		yearly := strings.HasSuffix(p.Name, "_yearly")
+		if strings.TrimSuffix(p.Name, "_yearly") != strings.TrimSuffix(account.Plan, "_yearly") || yearly != account.IsYearly {
+			if err := db.Users.ChangePlan(account.ID, p.Name, yearly); err != nil {
+				return err
+			}
+			cacheAccountPlan(account.ID, p.Name)
+		}
+	}
+
+	if obj.Quantity > 0 && obj.Quantity != account.Seats {
+		if err := db.Users.SetSeats(account.ID, obj.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendBillingEmail(account *model.Account, template string, category model.EmailCategory, data map[string]interface{}) {
+	p := queue.SendEmailParameter{
+		To:       account.Email,
+		Template: template,
+		Category: category,
+		Data:     data,
+	}
+	if _, err := queue.Enqueue(queue.TaskEmail, p); err != nil {
+		log.Println("unable to queue the billing email", template, account.ID, err)
+	}
+}
+
+func formatBillingDate(t int64) string {
+	return time.Unix(t, 0).Format("January 2, 2006")
+}
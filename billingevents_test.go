@@ -0,0 +1,191 @@
+package gosaas
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jlb922/gosaas/cache"
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/queue"
+	"github.com/jlb922/gosaas/queue/email"
+	"github.com/stripe/stripe-go/webhook"
+)
+
+// testBillingEvents records the processed event IDs.
+type testBillingEvents struct {
+	ids map[string]bool
+}
+
+func (be *testBillingEvents) RecordEvent(id, kind string) (bool, error) {
+	if be.ids[id] {
+		return false, nil
+	}
+	be.ids[id] = true
+	return true, nil
+}
+
+func (be *testBillingEvents) ForgetEvent(id string) error {
+	delete(be.ids, id)
+	return nil
+}
+
+// testWebhooks records the events sent to the outbound webhooks.
+type testWebhooks struct {
+	data.WebhookServices
+	events []string
+}
+
+func (wh *testWebhooks) AllSubscriptions(event string) ([]model.Webhook, error) {
+	wh.events = append(wh.events, event)
+	return nil, nil
+}
+
+const testStripeSecret = "whsec_test"
+
+func stripeEvent(id, kind string, object map[string]interface{}) []byte {
+	b, _ := json.Marshal(map[string]interface{}{
+		"id":   id,
+		"type": kind,
+		"data": map[string]interface{}{"object": object},
+	})
+	return b
+}
+
+func stripeEventRequest(db *data.DB, payload []byte, signedAt time.Time, secret string) *httptest.ResponseRecorder {
+	sig := hex.EncodeToString(webhook.ComputeSignature(signedAt, payload, secret))
+
+	ctx := context.WithValue(context.Background(), ContextDatabase, db)
+	req := httptest.NewRequest("POST", "/webhooks", bytes.NewReader(payload)).WithContext(ctx)
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", signedAt.Unix(), sig))
+
+	rec := httptest.NewRecorder()
+	newStripeWebhook().Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// queuedEmails returns the templates of the emails queued to the address and
+// removes them from the queue.
+func queuedEmails(t *testing.T, to string) []string {
+	tasks, err := queue.Tasks(queue.StatusQueued, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var templates []string
+	for _, ti := range tasks {
+		var p queue.SendEmailParameter
+		if ti.ID != queue.TaskEmail || json.Unmarshal(ti.Data, &p) != nil || p.To != to {
+			continue
+		}
+		templates = append(templates, p.Template)
+		queue.Purge(ti.UUID)
+	}
+	return templates
+}
+
+func Test_Billing_StripeEvents(t *testing.T) {
+	cache.New(false, true, nil)
+
+	defer func(s string) { config.Current.StripeWebhookSecret = s }(config.Current.StripeWebhookSecret)
+	config.Current.StripeWebhookSecret = testStripeSecret
+
+	data.AddPlan(data.BillingPlan{ID: "unit_pro_yearly", StripeID: "pro_yearly", Name: "pro_yearly", Version: "current"})
+
+	const to = "stripe-events@test.com"
+	ta := &testAccounts{accounts: map[int64]*model.Account{
+		1: {ID: 1, Email: to, StripeID: "cus_1", SubscriptionID: "sub_1", Plan: "pro", Seats: 2},
+	}}
+	be := &testBillingEvents{ids: make(map[string]bool)}
+	wh := &testWebhooks{}
+	db := &data.DB{Users: ta, Billing: be, Webhooks: wh}
+	queuedEmails(t, to)
+
+	events := []struct {
+		kind     string
+		object   map[string]interface{}
+		template string
+	}{
+		{StripeInvoicePaid, map[string]interface{}{"customer": "cus_1", "amount_paid": 2000}, email.TemplateReceipt},
+		{StripeInvoicePaymentFailed, map[string]interface{}{"customer": "cus_1", "amount_due": 2000, "next_payment_attempt": 1600000000}, email.TemplatePaymentFailed},
+		{StripeTrialWillEnd, map[string]interface{}{"id": "sub_1", "customer": "cus_1", "trial_end": 1600000000}, email.TemplateTrialEnding},
+		{StripeChargeRefunded, map[string]interface{}{"customer": "cus_1", "amount_refunded": 500}, email.TemplateRefund},
+		{StripeSubscriptionUpdated, map[string]interface{}{"id": "sub_1", "customer": "cus_1", "plan": map[string]string{"id": "pro_yearly"}, "quantity": 3}, ""},
+		{StripeSubscriptionDeleted, map[string]interface{}{"id": "sub_1", "customer": "cus_1"}, email.TemplateCanceled},
+	}
+	for i, e := range events {
+		rec := stripeEventRequest(db, stripeEvent(fmt.Sprintf("evt_%d", i), e.kind, e.object), time.Now(), testStripeSecret)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200 got %d: %s", e.kind, rec.Code, rec.Body.String())
+		}
+
+		templates := queuedEmails(t, to)
+		if len(e.template) == 0 && len(templates) > 0 {
+			t.Errorf("%s: expected no email, got %v", e.kind, templates)
+		} else if len(e.template) > 0 && (len(templates) != 1 || templates[0] != e.template) {
+			t.Errorf("%s: expected the %s email, got %v", e.kind, e.template, templates)
+		}
+
+		if e.kind == StripeSubscriptionUpdated {
+			if a := ta.accounts[1]; a.Plan != "pro_yearly" || !a.IsYearly || a.Seats != 3 {
+				t.Errorf("expected the subscription changes to be applied, got %+v", a)
+			}
+		}
+	}
+
+	if ta.accounts[1].IsPaid() {
+		t.Error("expected the account to be canceled")
+	}
+	if len(wh.events) != len(events) {
+		t.Errorf("expected the events to be sent to the webhooks, got %v", wh.events)
+	}
+}
+
+func Test_Billing_StripeEventsVerification(t *testing.T) {
+	cache.New(false, true, nil)
+
+	defer func(s string) { config.Current.StripeWebhookSecret = s }(config.Current.StripeWebhookSecret)
+	config.Current.StripeWebhookSecret = testStripeSecret
+
+	const to = "stripe-verify@test.com"
+	ta := &testAccounts{accounts: map[int64]*model.Account{
+		1: {ID: 1, Email: to, StripeID: "cus_1", SubscriptionID: "sub_1", Plan: "pro"},
+	}}
+	db := &data.DB{Users: ta, Billing: &testBillingEvents{ids: make(map[string]bool)}}
+	queuedEmails(t, to)
+
+	payload := stripeEvent("evt_1", StripeInvoicePaid, map[string]interface{}{"customer": "cus_1", "amount_paid": 2000})
+
+	if rec := stripeEventRequest(db, payload, time.Now(), "whsec_wrong"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid signature to be refused, got %d", rec.Code)
+	}
+	if rec := stripeEventRequest(db, payload, time.Now().Add(-time.Hour), testStripeSecret); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an old event to be refused, got %d", rec.Code)
+	}
+	if templates := queuedEmails(t, to); len(templates) > 0 {
+		t.Errorf("expected the refused events to be ignored, got %v", templates)
+	}
+
+	// the replayed event is only processed once
+	for i := 0; i < 2; i++ {
+		if rec := stripeEventRequest(db, payload, time.Now(), testStripeSecret); rec.Code != http.StatusOK {
+			t.Errorf("expected status 200 got %d", rec.Code)
+		}
+	}
+	if templates := queuedEmails(t, to); len(templates) != 1 {
+		t.Errorf("expected one receipt, got %v", templates)
+	}
+
+	config.Current.StripeWebhookSecret = ""
+	if rec := stripeEventRequest(db, stripeEvent("evt_2", StripeInvoicePaid, nil), time.Now(), ""); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the events to be refused without secret, got %d", rec.Code)
+	}
+}
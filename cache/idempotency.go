@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// IdempotentResponse is the stored result of the first request made with
+// an Idempotency-Key.
+//
+// While the first request is still executing Completed is false, retries
+// hitting the key during that time are considered concurrent duplicates.
+type IdempotentResponse struct {
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+}
+
+// ReserveIdempotencyKey atomically claims an idempotency key for an account.
+//
+// If the key was free it is reserved with the request fingerprint and
+// reserved is true. Otherwise the previously stored response is returned,
+// which may still be in-flight (Completed is false).
+func ReserveIdempotencyKey(accountID int64, key, fingerprint string, expire time.Duration) (reserved bool, prev *IdempotentResponse, err error) {
+	k := idempotencyKey(accountID, key)
+
+	s, err := encodeIdempotentResponse(IdempotentResponse{Fingerprint: fingerprint})
+	if err != nil {
+		return false, nil, err
+	}
+
+	ok, err := rc.SetNX(k, s, expire).Result()
+	if err != nil {
+		return false, nil, err
+	} else if ok {
+		return true, nil, nil
+	}
+
+	v, err := rc.Get(k).Result()
+	if err != nil {
+		if err == redis.Nil {
+			// the key expired between SETNX and GET, we try once more
+			ok, err = rc.SetNX(k, s, expire).Result()
+			return ok, nil, err
+		}
+		return false, nil, err
+	}
+
+	prev = &IdempotentResponse{}
+	dec := gob.NewDecoder(strings.NewReader(v))
+	if err := dec.Decode(prev); err != nil {
+		return false, nil, err
+	}
+	return false, prev, nil
+}
+
+// SaveIdempotentResponse stores the completed response for an idempotency key
+// so it can be replayed for retries until it expires.
+func SaveIdempotentResponse(accountID int64, key string, resp IdempotentResponse, expire time.Duration) error {
+	resp.Completed = true
+
+	s, err := encodeIdempotentResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	return rc.Set(idempotencyKey(accountID, key), s, expire).Err()
+}
+
+// ReleaseIdempotencyKey removes a reservation, allowing the key to be used again.
+func ReleaseIdempotencyKey(accountID int64, key string) error {
+	return rc.Del(idempotencyKey(accountID, key)).Err()
+}
+
+func idempotencyKey(accountID int64, key string) string {
+	return fmt.Sprintf("%d_%s_idem", accountID, key)
+}
+
+func encodeIdempotentResponse(resp IdempotentResponse) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	enc := gob.NewEncoder(buf)
+	if err := enc.Encode(resp); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdempotency_ReserveAndReplay(t *testing.T) {
+	t.Parallel()
+
+	key := fmt.Sprintf("idem_unittest_%d", time.Now().UnixNano())
+
+	ok, prev, err := ReserveIdempotencyKey(1, key, "abc", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok || prev != nil {
+		t.Fatal("expected the key to be reserved on first call")
+	}
+
+	ok, prev, err = ReserveIdempotencyKey(1, key, "abc", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("expected the key to be already reserved")
+	} else if prev.Completed {
+		t.Error("expected the previous request to still be in-flight")
+	}
+
+	resp := IdempotentResponse{
+		Fingerprint: "abc",
+		StatusCode:  http.StatusCreated,
+		Header:      http.Header{"Content-Type": []string{"application/json"}},
+		Body:        []byte("true"),
+	}
+	if err := SaveIdempotentResponse(1, key, resp, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	_, prev, err = ReserveIdempotencyKey(1, key, "abc", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	} else if !prev.Completed {
+		t.Error("expected the previous request to be completed")
+	} else if prev.StatusCode != http.StatusCreated {
+		t.Errorf("expected status %d got %d", http.StatusCreated, prev.StatusCode)
+	} else if string(prev.Body) != "true" {
+		t.Errorf("expected body true got %s", string(prev.Body))
+	}
+}
+
+func TestIdempotency_KeysAreScopedPerAccount(t *testing.T) {
+	t.Parallel()
+
+	key := fmt.Sprintf("idem_scope_unittest_%d", time.Now().UnixNano())
+
+	if ok, _, err := ReserveIdempotencyKey(1, key, "abc", 5*time.Second); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected the key to be reserved for account 1")
+	}
+
+	if ok, _, err := ReserveIdempotencyKey(2, key, "abc", 5*time.Second); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Error("expected the key to be reserved for account 2")
+	}
+}
+
+func TestIdempotency_Release(t *testing.T) {
+	t.Parallel()
+
+	key := fmt.Sprintf("idem_release_unittest_%d", time.Now().UnixNano())
+
+	if _, _, err := ReserveIdempotencyKey(1, key, "abc", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReleaseIdempotencyKey(1, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _, err := ReserveIdempotencyKey(1, key, "abc", 5*time.Second); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Error("expected the key to be free after release")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/go-redis/redis"
+	"github.com/jlb922/gosaas/cron"
 	"github.com/jlb922/gosaas/queue"
 )
 
@@ -29,14 +30,18 @@ func init() {
 	rc = c
 }
 
-// New initializes the queue service via the queue.New function.
+// New initializes the queue service via the queue.New function, the tasks
+// are stored in Redis unless queue.SetBackend was called before.
 //
 // The queueProcessor flag indicates if this instance will act
-// as the Pub/Sub subscriber. There must be only one subscriber.
+// as a queue consumer. Multiple instances can be consumers, each
+// task is executed by one of them. The consumers also run the cron
+// jobs, see the cron package.
 //
 // The ex parameter map[queue.TaskID]queue.Executor allow you to supply
 // custom executors for your own custom task. A TaskExecutor must satisfy
-// this interface.
+// this interface. Prefer queue.Register which decodes the task payload
+// into its original type.
 //
 // 	type TaskExecutor interface {
 // 		Run(t QueueTask) error
@@ -46,5 +51,8 @@ func New(queueProcessor, isDev bool, ex map[queue.TaskID]queue.TaskExecutor) {
 
 	if queueProcessor {
 		go queue.SetAsSubscriber()
+
+		// the jobs registered via cron.Register run on the elected leader
+		cron.Start(rc)
 	}
 }
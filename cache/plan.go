@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// SetAccountPlan saves the current plan of an account so the rate limiting
+// middlewares can resolve its quotas without a database call.
+func SetAccountPlan(accountID int64, plan string) error {
+	return rc.Set(fmt.Sprintf("%d_plan", accountID), plan, 0).Err()
+}
+
+// GetAccountPlan returns the cached plan of an account, an empty string
+// if it is not cached.
+func GetAccountPlan(accountID int64) (string, error) {
+	plan, err := rc.Get(fmt.Sprintf("%d_plan", accountID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return plan, err
+}
+
+// SetAccountPastDue saves since when an account is past due so the pages can
+// show the billing banner without a database call, a zero time removes it.
+func SetAccountPastDue(accountID int64, since time.Time) error {
+	key := fmt.Sprintf("%d_past_due", accountID)
+	if since.IsZero() {
+		return rc.Del(key).Err()
+	}
+	return rc.Set(key, since.Unix(), 0).Err()
+}
+
+// GetAccountPastDue returns since when an account is past due, a zero time if
+// it is not.
+func GetAccountPastDue(accountID int64) (time.Time, error) {
+	since, err := rc.Get(fmt.Sprintf("%d_past_due", accountID)).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(since, 0), nil
+}
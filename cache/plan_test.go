@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlan_SetAndGet(t *testing.T) {
+	t.Parallel()
+
+	id := time.Now().UnixNano()
+
+	if plan, err := GetAccountPlan(id); err != nil {
+		t.Fatal(err)
+	} else if plan != "" {
+		t.Errorf("expected empty plan got %s", plan)
+	}
+
+	if err := SetAccountPlan(id, "pro"); err != nil {
+		t.Fatal(err)
+	}
+
+	if plan, err := GetAccountPlan(id); err != nil {
+		t.Fatal(err)
+	} else if plan != "pro" {
+		t.Errorf("expected plan pro got %s", plan)
+	}
+}
+
+func TestPlan_PastDue(t *testing.T) {
+	t.Parallel()
+
+	id := time.Now().UnixNano()
+	since := time.Now().Truncate(time.Second)
+
+	if err := SetAccountPastDue(id, since); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := GetAccountPastDue(id); err != nil {
+		t.Fatal(err)
+	} else if !got.Equal(since) {
+		t.Errorf("expected past due since %v got %v", since, got)
+	}
+
+	if err := SetAccountPastDue(id, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := GetAccountPastDue(id); err != nil {
+		t.Fatal(err)
+	} else if !got.IsZero() {
+		t.Errorf("expected no past due got %v", got)
+	}
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Algorithm is a rate limiting algorithm.
+type Algorithm int
+
+const (
+	// FixedWindow counts the calls per window, the counter resets when the window expires.
+	// It is the cheapest, but allows up to twice the limit around the window edges.
+	FixedWindow Algorithm = iota
+	// SlidingWindow keeps a log of the calls made during the last period.
+	SlidingWindow
+	// TokenBucket refills the bucket continuously, a full bucket holds limit tokens.
+	TokenBucket
+)
+
+// LimitResult is the outcome of a rate limited call.
+type LimitResult struct {
+	// Allowed indicates if the call is within the limit.
+	Allowed bool
+	// Limit is the maximum number of calls for the period.
+	Limit int64
+	// Remaining is the number of calls left.
+	Remaining int64
+	// Reset is the duration before the limit is fully restored.
+	Reset time.Duration
+	// RetryAfter is the duration before the next call is allowed when Allowed is false.
+	RetryAfter time.Duration
+}
+
+// All scripts return {allowed, remaining, reset ms, retry after ms}. The current time
+// is passed by the caller since scripts cannot write after calling TIME on older Redis.
+var (
+	fixedWindowScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+	slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+local retry = 0
+if allowed == 0 then
+	retry = reset
+end
+return {allowed, limit - count, reset, retry}
+`)
+
+	tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local rate = capacity / period
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], period)
+
+local retry = 0
+if allowed == 0 then
+	retry = math.ceil((1 - tokens) / rate)
+end
+return {allowed, math.floor(tokens), math.ceil((capacity - tokens) / rate), retry}
+`)
+)
+
+// Allow records a call for the key and returns if it is within limit calls per period
+// using the chosen algorithm. Each algorithm is a single Lua script so the check and
+// the update are atomic and keys always expire.
+func Allow(key string, alg Algorithm, limit int64, period time.Duration) (*LimitResult, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	ms := int64(period / time.Millisecond)
+	if ms <= 0 {
+		return nil, fmt.Errorf("the rate limit period must be at least 1ms")
+	}
+
+	res := &LimitResult{Limit: limit}
+
+	switch alg {
+	case SlidingWindow:
+		member := fmt.Sprintf("%d-%d", now, rand.Int63())
+		v, err := slidingWindowScript.Run(rc, []string{key + "_sw"}, now, ms, limit, member).Result()
+		if err != nil {
+			return nil, err
+		}
+		return res, fillLimitResult(res, v)
+	case TokenBucket:
+		v, err := tokenBucketScript.Run(rc, []string{key + "_tb"}, now, ms, limit).Result()
+		if err != nil {
+			return nil, err
+		}
+		return res, fillLimitResult(res, v)
+	default:
+		count, ttl, err := fixedWindow(key, period)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Allowed = count <= limit
+		res.Remaining = limit - count
+		if res.Remaining < 0 {
+			res.Remaining = 0
+		}
+		res.Reset = ttl
+		if !res.Allowed {
+			res.RetryAfter = ttl
+		}
+		return res, nil
+	}
+}
+
+// fixedWindow increments the key counter and sets its expiration atomically.
+func fixedWindow(key string, expire time.Duration) (count int64, ttl time.Duration, err error) {
+	v, err := fixedWindowScript.Run(rc, []string{key}, int64(expire/time.Millisecond)).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	vals, ok := v.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, 0, fmt.Errorf("unexpected fixed window result %v", v)
+	}
+
+	count, _ = vals[0].(int64)
+	ms, _ := vals[1].(int64)
+	return count, time.Duration(ms) * time.Millisecond, nil
+}
+
+func fillLimitResult(res *LimitResult, v interface{}) error {
+	vals, ok := v.([]interface{})
+	if !ok || len(vals) != 4 {
+		return fmt.Errorf("unexpected rate limit result %v", v)
+	}
+
+	n := make([]int64, 4)
+	for i := range vals {
+		n[i], _ = vals[i].(int64)
+	}
+
+	res.Allowed = n[0] == 1
+	res.Remaining = n[1]
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	res.Reset = time.Duration(n[2]) * time.Millisecond
+	res.RetryAfter = time.Duration(n[3]) * time.Millisecond
+	return nil
+}
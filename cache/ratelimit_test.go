@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimit_AlgorithmsEnforceLimit(t *testing.T) {
+	t.Parallel()
+
+	algs := map[string]Algorithm{"fixed": FixedWindow, "sliding": SlidingWindow, "bucket": TokenBucket}
+	for name, alg := range algs {
+		key := fmt.Sprintf("rl_unittest_%s_%d", name, time.Now().UnixNano())
+
+		for i := 0; i < 3; i++ {
+			res, err := Allow(key, alg, 3, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			} else if !res.Allowed {
+				t.Fatalf("%s: call %d should have been allowed", name, i+1)
+			} else if res.Remaining != int64(2-i) {
+				t.Errorf("%s: expected %d remaining got %d", name, 2-i, res.Remaining)
+			}
+		}
+
+		res, err := Allow(key, alg, 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		} else if res.Allowed {
+			t.Errorf("%s: the 4th call should have been denied", name)
+		} else if res.RetryAfter <= 0 || res.RetryAfter > time.Minute {
+			t.Errorf("%s: expected a retry after within a minute got %v", name, res.RetryAfter)
+		}
+	}
+}
+
+func TestRateLimit_SlidingWindowExpires(t *testing.T) {
+	t.Parallel()
+
+	key := fmt.Sprintf("rl_unittest_sliding_exp_%d", time.Now().UnixNano())
+	for i := 0; i < 2; i++ {
+		if _, err := Allow(key, SlidingWindow, 2, 500*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(600 * time.Millisecond)
+
+	res, err := Allow(key, SlidingWindow, 2, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	} else if !res.Allowed {
+		t.Error("the call should have been allowed once the window slid")
+	}
+}
+
+func TestRateLimit_TokenBucketRefills(t *testing.T) {
+	t.Parallel()
+
+	key := fmt.Sprintf("rl_unittest_bucket_refill_%d", time.Now().UnixNano())
+	for i := 0; i < 10; i++ {
+		if _, err := Allow(key, TokenBucket, 10, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// one token is refilled every 100ms
+	time.Sleep(150 * time.Millisecond)
+
+	res, err := Allow(key, TokenBucket, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	} else if !res.Allowed {
+		t.Error("the call should have been allowed once a token was refilled")
+	}
+}
+
+func TestRateLimit_FixedWindowAlwaysExpires(t *testing.T) {
+	t.Parallel()
+
+	key := fmt.Sprintf("rl_unittest_fixed_ttl_%d", time.Now().UnixNano())
+
+	// simulate a key left without expiration
+	if err := rc.Set(key, 5, 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Allow(key, FixedWindow, 10, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := rc.TTL(key).Result()
+	if err != nil {
+		t.Fatal(err)
+	} else if d <= 0 {
+		t.Errorf("expected the key to have an expiration got %v", d)
+	}
+}
@@ -0,0 +1,9 @@
+package cache
+
+import "time"
+
+// MarkSeen records the key for the expire duration and reports whether it was
+// not recorded yet. Webhooks use it to refuse the replays of a request.
+func MarkSeen(key string, expire time.Duration) (bool, error) {
+	return rc.SetNX("seen_"+key, "1", expire).Result()
+}
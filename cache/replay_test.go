@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReplay_MarkSeen(t *testing.T) {
+	t.Parallel()
+
+	key := fmt.Sprintf("unit_%d", time.Now().UnixNano())
+
+	if fresh, err := MarkSeen(key, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if !fresh {
+		t.Error("expected the key to be fresh")
+	}
+
+	if fresh, err := MarkSeen(key, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if fresh {
+		t.Error("expected the key to be already seen")
+	}
+
+	if ttl := rc.TTL("seen_" + key).Val(); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the key to expire, got ttl %v", ttl)
+	}
+}
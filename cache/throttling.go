@@ -17,27 +17,11 @@ func RateLimit(key string, expire time.Duration) (int64, error) {
 	return increaseThrottle(key, expire)
 }
 
+// increaseThrottle increments the fixed window counter, the increment and the
+// expiration are set atomically so a key can never be left without expiration.
 func increaseThrottle(key string, expire time.Duration) (int64, error) {
-	i, err := rc.Incr(key).Result()
-	if err != nil {
-		return 0, err
-	}
-
-	if i == 1 {
-		// the key was created, we set the expire
-		ok, err := rc.Expire(key, expire).Result()
-		if err != nil {
-			// try to remove the key
-			if _, e := rc.Del(key).Result(); err != nil {
-				return 0, fmt.Errorf("unable to remove key %s: %s and expire failed: %s", key, e.Error(), err.Error())
-			}
-			return 0, err
-		} else if !ok {
-			return 0, fmt.Errorf("unable to set expiration on key %s", key)
-		}
-	}
-
-	return i, nil
+	count, _, err := fixedWindow(key, expire)
+	return count, err
 }
 
 // GetThrottleExpiration returns the duration before a key expire for throttling.
@@ -21,4 +21,12 @@ const (
 	ContextLanguage
 	// ContextContentIsJSON indicates if the request Content-Type is application/json
 	ContextContentIsJSON
+	// ContextCorsPolicy holds the CORS policy of the requested route.
+	ContextCorsPolicy
+	// ContextCSRFToken holds the CSRF token to embed in HTML forms.
+	ContextCSRFToken
+	// ContextRateLimits holds the rate limits override of the requested route.
+	ContextRateLimits
+	// ContextStatic holds the Static serving the assets of the server.
+	ContextStatic
 )
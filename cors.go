@@ -1,11 +1,40 @@
 package gosaas
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+
+	"github.com/jlb922/gosaas/internal/config"
 )
 
+// CorsPolicy defines which origins, methods and headers are allowed for
+// cross-origin requests. The default policy is read from the "cors" key of
+// the gosaas.json config file and can be overridden per Route:
+//
+//	routes["api"] = &gosaas.Route{
+//		AllowCrossOrigin: true,
+//		CorsPolicy: &gosaas.CorsPolicy{
+//			AllowedOrigins:   []string{"https://*.example.com"},
+//			AllowedMethods:   []string{"GET", "POST"},
+//			AllowCredentials: true,
+//			MaxAge:           600,
+//		},
+//		Handler: api,
+//	}
+type CorsPolicy = config.CorsPolicy
+
+// default methods when a policy does not specify any, the CORS simple methods.
+var defaultCorsMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
 // Cors enables calls via remote origin to handle external JavaScript calls mainly.
+//
+// Only the origins allowed by the route's CorsPolicy (or the configured one) receive
+// the Access-Control-Allow-* headers. Requests from other origins are rejected with a
+// StatusForbidden error. Preflight requests are answered directly without reaching
+// the route handler.
 func Cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		headers := w.Header()
@@ -18,22 +47,129 @@ func Cors(next http.Handler) http.Handler {
 		headers.Add("Vary", "Access-Control-Request-Method")
 		headers.Add("Vary", "Access-Control-Request-Headers")
 
-		if origin == "" {
+		if origin == "" || isSameOrigin(r, origin) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		headers.Set("Access-Control-Allow-Origin", origin)
-		// Spec says: Since the list of methods can be unbounded, simply returning the method indicated
-		// by Access-Control-Request-Method (if supported) can be enough
-		headers.Set("Access-Control-Allow-Methods", strings.ToUpper(r.Header.Get("Access-Control-Request-Method")))
+		policy, ok := r.Context().Value(ContextCorsPolicy).(*CorsPolicy)
+		if !ok {
+			policy = &config.Current.Cors
+		}
 
-		headers.Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		if !corsOriginAllowed(policy, origin) {
+			Respond(w, r, http.StatusForbidden, fmt.Errorf("origin %s is not allowed", origin))
+			return
+		}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		reqMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && len(reqMethod) > 0 {
+			corsPreflight(w, r, policy, origin, reqMethod)
 			return
 		}
+
+		setCorsOrigin(w, policy, origin)
+		if len(policy.ExposedHeaders) > 0 {
+			headers.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+func corsPreflight(w http.ResponseWriter, r *http.Request, policy *CorsPolicy, origin, reqMethod string) {
+	headers := w.Header()
+
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCorsMethods
+	}
+
+	reqMethod = strings.ToUpper(reqMethod)
+	if !containsFold(methods, reqMethod) {
+		Respond(w, r, http.StatusForbidden, fmt.Errorf("method %s is not allowed", reqMethod))
+		return
+	}
+
+	var reqHeaders []string
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		h = strings.TrimSpace(h)
+		if len(h) == 0 {
+			continue
+		}
+
+		if !containsFold(policy.AllowedHeaders, "*") && !containsFold(policy.AllowedHeaders, h) {
+			Respond(w, r, http.StatusForbidden, fmt.Errorf("header %s is not allowed", h))
+			return
+		}
+		reqHeaders = append(reqHeaders, h)
+	}
+
+	setCorsOrigin(w, policy, origin)
+	headers.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(reqHeaders) > 0 {
+		headers.Set("Access-Control-Allow-Headers", strings.Join(reqHeaders, ", "))
+	}
+	if policy.MaxAge > 0 {
+		headers.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setCorsOrigin(w http.ResponseWriter, policy *CorsPolicy, origin string) {
+	// the wildcard cannot be used for credentialed requests, the origin
+	// must be returned instead.
+	if containsFold(policy.AllowedOrigins, "*") && !policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// corsOriginAllowed returns if the origin matches one of the policy's allowed
+// origins, either exactly or via a wildcard subdomain like https://*.example.com.
+func corsOriginAllowed(policy *CorsPolicy, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range policy.AllowedOrigins {
+		o = strings.ToLower(o)
+		if o == "*" || o == origin {
+			return true
+		}
+
+		i := strings.Index(o, "*.")
+		if i < 0 {
+			continue
+		}
+
+		prefix, suffix := o[:i], o[i+1:]
+		if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			len(origin) > len(prefix)+len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSameOrigin returns if the Origin header points to the requested host,
+// browsers send it for same-origin POST requests as well.
+func isSameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package gosaas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func corsRequest(policy *CorsPolicy, method, origin string) *httptest.ResponseRecorder {
+	h := Cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(method, "http://api.domain.com/test", nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	}
+
+	ctx := context.WithValue(req.Context(), ContextCorsPolicy, policy)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req.WithContext(ctx))
+	return rec
+}
+
+func Test_Cors_OriginMatching(t *testing.T) {
+	policy := &CorsPolicy{AllowedOrigins: []string{"https://app.domain.com", "https://*.example.com"}}
+
+	origins := map[string]bool{
+		"https://app.domain.com":   true,
+		"https://APP.domain.com":   true,
+		"https://a.example.com":    true,
+		"https://a.b.example.com":  true,
+		"https://example.com":      false,
+		"https://evilexample.com":  false,
+		"http://a.example.com":     false,
+		"https://evil.com":         false,
+		"https://app.domain.com.x": false,
+	}
+
+	for origin, expected := range origins {
+		if ok := corsOriginAllowed(policy, origin); ok != expected {
+			t.Errorf("origin %s allowed was %t expected %t", origin, ok, expected)
+		}
+	}
+}
+
+func Test_Cors_RejectsDisallowedOrigin(t *testing.T) {
+	policy := &CorsPolicy{AllowedOrigins: []string{"https://app.domain.com"}}
+
+	rec := corsRequest(policy, http.MethodGet, "https://evil.com")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d got %d", http.StatusForbidden, rec.Code)
+	} else if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected no Access-Control-Allow-Origin header")
+	}
+}
+
+func Test_Cors_Preflight(t *testing.T) {
+	policy := &CorsPolicy{
+		AllowedOrigins:   []string{"https://app.domain.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+
+	rec := corsRequest(policy, http.MethodOptions, "https://app.domain.com")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d got %d", http.StatusNoContent, rec.Code)
+	}
+
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.domain.com",
+		"Access-Control-Allow-Methods":     "GET, POST",
+		"Access-Control-Allow-Headers":     "Content-Type",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+	}
+	for k, v := range expected {
+		if rec.Header().Get(k) != v {
+			t.Errorf("expected %s to be %s got %s", k, v, rec.Header().Get(k))
+		}
+	}
+}
+
+func Test_Cors_PreflightMethodNotAllowed(t *testing.T) {
+	policy := &CorsPolicy{
+		AllowedOrigins: []string{"https://app.domain.com"},
+		AllowedMethods: []string{"GET"},
+	}
+
+	rec := corsRequest(policy, http.MethodOptions, "https://app.domain.com")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func Test_Cors_SameOriginPassThrough(t *testing.T) {
+	policy := &CorsPolicy{}
+
+	rec := corsRequest(policy, http.MethodPost, "http://api.domain.com")
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d got %d", http.StatusOK, rec.Code)
+	}
+}
@@ -0,0 +1,277 @@
+// Package cron runs recurring jobs registered in Go.
+//
+// Jobs are registered with a standard cron expression or a descriptor like
+// "@daily" or "@every 1h":
+//
+//	cron.Register("0 3 * * *", "purge-logs", func(ctx context.Context) error {
+//		return purgeLogs()
+//	})
+//
+//	cron.RegisterTask("@hourly", "sync-usage", "app.sync-usage", SyncUsage{})
+//
+// Every instance can call Start, the instances elect a leader via Redis and only
+// the leader runs the jobs so each job runs once per schedule.
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jlb922/gosaas/queue"
+	robfig "github.com/robfig/cron"
+)
+
+// Func is the function executed by a job.
+type Func func(ctx context.Context) error
+
+// JobInfo holds the schedule and the last execution of a job.
+type JobInfo struct {
+	Name         string     `json:"name"`
+	Spec         string     `json:"spec"`
+	NextRun      time.Time  `json:"nextRun"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastDuration string     `json:"lastDuration,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	// Instance is the instance that executed the last run.
+	Instance string `json:"instance,omitempty"`
+}
+
+// lastRun is stored in Redis so every instance sees the executions of the leader.
+type lastRun struct {
+	At       time.Time     `json:"at"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	Instance string        `json:"instance"`
+}
+
+type job struct {
+	name     string
+	spec     string
+	schedule robfig.Schedule
+	fn       Func
+}
+
+const (
+	keyRuns     = "cron:runs"
+	keyRunLock  = "cron:run:"
+	runLockTime = 1 * time.Hour
+)
+
+var (
+	mu        sync.Mutex
+	jobs      = make(map[string]*job)
+	scheduler *robfig.Cron
+	client    *redis.Client
+	leader    *elector
+
+	// runs of the jobs when there's no Redis
+	localRuns = make(map[string]lastRun)
+)
+
+// Register adds a job executing fn on the spec schedule. The spec is a standard
+// 5 fields cron expression or a descriptor. An error is returned if the spec is
+// invalid or the name is already registered.
+func Register(spec, name string, fn Func) error {
+	if fn == nil {
+		return fmt.Errorf("the job %s has no function", name)
+	}
+
+	schedule, err := robfig.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid cron expression %q for job %s: %v", spec, name, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := jobs[name]; ok {
+		return fmt.Errorf("the job %s is already registered", name)
+	}
+
+	j := &job{name: name, spec: spec, schedule: schedule, fn: fn}
+	jobs[name] = j
+
+	if scheduler != nil {
+		scheduler.Schedule(j.schedule, j)
+	}
+	return nil
+}
+
+// RegisterTask adds a job queuing the task id with the payload on the spec schedule.
+func RegisterTask[T any](spec, name string, id queue.TaskID, payload T) error {
+	return Register(spec, name, func(ctx context.Context) error {
+		_, err := queue.Enqueue(id, payload)
+		return err
+	})
+}
+
+// Start starts the scheduler and the leader election. When rc is nil, this instance
+// is always the leader.
+func Start(rc *redis.Client) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if scheduler != nil {
+		return
+	}
+
+	client = rc
+	leader = newElector(rc)
+	go leader.run()
+
+	scheduler = robfig.New()
+	for _, j := range jobs {
+		scheduler.Schedule(j.schedule, j)
+	}
+	scheduler.Start()
+}
+
+// Stop stops the scheduler and gives up the leadership. Jobs already running
+// are not interrupted.
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if scheduler == nil {
+		return
+	}
+
+	scheduler.Stop()
+	scheduler = nil
+
+	leader.stop()
+	leader = nil
+}
+
+// IsLeader returns if this instance runs the jobs.
+func IsLeader() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return leader != nil && leader.isLeader()
+}
+
+// Jobs returns the registered jobs with their next and last runs.
+func Jobs() ([]JobInfo, error) {
+	mu.Lock()
+	list := make([]*job, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, j)
+	}
+	rc := client
+	mu.Unlock()
+
+	sort.Slice(list, func(i, k int) bool { return list[i].name < list[k].name })
+
+	now := time.Now()
+	infos := make([]JobInfo, 0, len(list))
+	for _, j := range list {
+		info := JobInfo{Name: j.name, Spec: j.spec, NextRun: j.schedule.Next(now)}
+
+		lr, ok, err := getLastRun(rc, j.name)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			at := lr.At
+			info.LastRun = &at
+			info.LastDuration = lr.Duration.String()
+			info.LastError = lr.Error
+			info.Instance = lr.Instance
+		}
+
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Run is called by the scheduler, the job is executed only on the leader.
+func (j *job) Run() {
+	mu.Lock()
+	e, rc := leader, client
+	mu.Unlock()
+
+	if e == nil || !e.isLeader() {
+		return
+	}
+
+	now := time.Now().Truncate(time.Second)
+	if rc != nil {
+		// guards against two leaders around a leadership change
+		key := fmt.Sprintf("%s%s_%d", keyRunLock, j.name, now.Unix())
+		ok, err := rc.SetNX(key, e.id, runLockTime).Result()
+		if err != nil {
+			log.Println("unable to lock the cron job run", j.name, err)
+			return
+		} else if !ok {
+			return
+		}
+	}
+
+	j.execute(rc, e.id)
+}
+
+func (j *job) execute(rc *redis.Client, instance string) {
+	start := time.Now()
+	err := safeRun(j.fn)
+
+	lr := lastRun{At: start, Duration: time.Since(start), Instance: instance}
+	if err != nil {
+		lr.Error = err.Error()
+		log.Println("cron job failed", j.name, err)
+	}
+
+	if err := setLastRun(rc, j.name, lr); err != nil {
+		log.Println("unable to save the cron job last run", j.name, err)
+	}
+}
+
+func safeRun(fn Func) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return fn(context.Background())
+}
+
+func setLastRun(rc *redis.Client, name string, lr lastRun) error {
+	if rc == nil {
+		mu.Lock()
+		localRuns[name] = lr
+		mu.Unlock()
+		return nil
+	}
+
+	b, err := json.Marshal(lr)
+	if err != nil {
+		return err
+	}
+	return rc.HSet(keyRuns, name, string(b)).Err()
+}
+
+func getLastRun(rc *redis.Client, name string) (lastRun, bool, error) {
+	if rc == nil {
+		mu.Lock()
+		defer mu.Unlock()
+		lr, ok := localRuns[name]
+		return lr, ok, nil
+	}
+
+	s, err := rc.HGet(keyRuns, name).Result()
+	if err == redis.Nil {
+		return lastRun{}, false, nil
+	} else if err != nil {
+		return lastRun{}, false, err
+	}
+
+	var lr lastRun
+	if err := json.Unmarshal([]byte(s), &lr); err != nil {
+		return lastRun{}, false, err
+	}
+	return lr, true, nil
+}
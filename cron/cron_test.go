@@ -0,0 +1,169 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func newTestClient(t *testing.T) *redis.Client {
+	host := os.Getenv("REDIS_ADDR")
+	if len(host) == 0 {
+		host = "127.0.0.1:6379"
+	}
+
+	c := redis.NewClient(&redis.Options{Addr: host, Password: os.Getenv("REDIS_KEY")})
+	if err := c.Ping().Err(); err != nil {
+		t.Skip("redis is not available", err)
+	}
+
+	c.Del(keyLeader, keyRuns)
+	t.Cleanup(func() {
+		c.Del(keyLeader, keyRuns)
+		if keys := c.Keys(keyRunLock + "*").Val(); len(keys) > 0 {
+			c.Del(keys...)
+		}
+
+		mu.Lock()
+		jobs = make(map[string]*job)
+		localRuns = make(map[string]lastRun)
+		mu.Unlock()
+
+		c.Close()
+	})
+	return c
+}
+
+func TestCron_RegisterValidation(t *testing.T) {
+	newTestClient(t)
+
+	fn := func(ctx context.Context) error { return nil }
+
+	if err := Register("not a spec", "bad", fn); err == nil {
+		t.Error("expected an error for an invalid expression")
+	}
+	if err := Register("0 3 * * *", "purge-logs", fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register("@daily", "purge-logs", fn); err == nil {
+		t.Error("expected an error registering the same name twice")
+	}
+	if err := Register("@daily", "nil", nil); err == nil {
+		t.Error("expected an error for a nil function")
+	}
+}
+
+func TestCron_LeaderElection(t *testing.T) {
+	c := newTestClient(t)
+
+	a, b := newElector(c), newElector(c)
+	a.campaign()
+	b.campaign()
+
+	if !a.isLeader() || b.isLeader() {
+		t.Fatalf("expected a to be the only leader, a %v b %v", a.isLeader(), b.isLeader())
+	}
+
+	// renewing keeps the leadership
+	a.campaign()
+	if !a.isLeader() {
+		t.Error("a should still be the leader")
+	}
+
+	a.stop()
+	b.campaign()
+	if a.isLeader() || !b.isLeader() {
+		t.Errorf("expected b to take over, a %v b %v", a.isLeader(), b.isLeader())
+	}
+	b.stop()
+
+	if n := c.Exists(keyLeader).Val(); n != 0 {
+		t.Error("the leader key should be released")
+	}
+}
+
+func TestCron_RunOnlyOnLeaderOnce(t *testing.T) {
+	c := newTestClient(t)
+
+	calls := 0
+	j := &job{name: "count", spec: "@every 1s", fn: func(ctx context.Context) error {
+		calls++
+		return fmt.Errorf("failed %d", calls)
+	}}
+
+	follower := newElector(c)
+	mu.Lock()
+	client, leader = c, follower
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		client, leader = nil, nil
+		mu.Unlock()
+	}()
+
+	j.Run()
+	if calls != 0 {
+		t.Fatal("the job should not run on a follower")
+	}
+
+	follower.campaign()
+	j.Run()
+	j.Run()
+	if calls != 1 {
+		t.Errorf("the job ran %d times for the same second, expected 1", calls)
+	}
+
+	lr, ok, err := getLastRun(c, "count")
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected the last run to be saved")
+	} else if lr.Error != "failed 1" || lr.Instance != follower.id {
+		t.Errorf("unexpected last run %+v", lr)
+	}
+}
+
+func TestCron_Jobs(t *testing.T) {
+	c := newTestClient(t)
+
+	if err := Register("@every 1h", "hourly", func(ctx context.Context) error { panic("oops") }); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register("0 3 * * *", "nightly", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	client = c
+	j := jobs["hourly"]
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		client = nil
+		mu.Unlock()
+	}()
+
+	j.execute(c, "test")
+
+	infos, err := Jobs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(infos) != 2 {
+		t.Fatalf("expected 2 jobs got %d", len(infos))
+	}
+
+	hourly, nightly := infos[0], infos[1]
+	if hourly.Name != "hourly" || hourly.LastRun == nil || hourly.LastError != "job panicked: oops" {
+		t.Errorf("unexpected hourly job %+v", hourly)
+	}
+	if d := time.Until(hourly.NextRun); d <= 0 || d > time.Hour {
+		t.Errorf("hourly next run in %v", d)
+	}
+	if nightly.LastRun != nil || nightly.NextRun.Hour() != 3 {
+		t.Errorf("unexpected nightly job %+v", nightly)
+	}
+}
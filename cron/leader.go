@@ -0,0 +1,118 @@
+package cron
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	keyLeader      = "cron:leader"
+	leaseTime      = 15 * time.Second
+	renewInterval  = 5 * time.Second
+	leaseTimeMilli = int64(leaseTime / time.Millisecond)
+)
+
+var (
+	// campaignScript renews the lease of the current leader or acquires it when free.
+	campaignScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+	resignScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+)
+
+// elector holds a lease on the leader key, the lease is renewed while the
+// instance is alive and expires if it stops.
+type elector struct {
+	client *redis.Client
+	id     string
+	leader int32
+	done   chan struct{}
+}
+
+func newElector(rc *redis.Client) *elector {
+	host, _ := os.Hostname()
+	return &elector{
+		client: rc,
+		id:     fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.NewV4().String()[:8]),
+		done:   make(chan struct{}),
+	}
+}
+
+func (e *elector) isLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// campaign tries to become or stay the leader.
+func (e *elector) campaign() {
+	select {
+	case <-e.done:
+		return
+	default:
+	}
+
+	if e.client == nil {
+		atomic.StoreInt32(&e.leader, 1)
+		return
+	}
+
+	n, err := campaignScript.Run(e.client, []string{keyLeader}, e.id, leaseTimeMilli).Int64()
+	if err != nil {
+		// without Redis the lease cannot be renewed, someone else may take over
+		log.Println("unable to campaign for the cron leadership", err)
+		n = 0
+	}
+
+	was := atomic.SwapInt32(&e.leader, int32(n))
+	if was != int32(n) && n == 1 {
+		log.Println("this instance is now the cron leader", e.id)
+	}
+}
+
+func (e *elector) run() {
+	e.campaign()
+
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			e.campaign()
+		}
+	}
+}
+
+// stop stops campaigning and releases the leadership so another instance
+// takes over without waiting for the lease to expire.
+func (e *elector) stop() {
+	close(e.done)
+	atomic.StoreInt32(&e.leader, 0)
+
+	if e.client == nil {
+		return
+	}
+	if err := resignScript.Run(e.client, []string{keyLeader}, e.id).Err(); err != nil {
+		log.Println("unable to resign the cron leadership", err)
+	}
+}
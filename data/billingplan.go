@@ -1,9 +1,16 @@
 package data
 
+import (
+	"encoding/json"
+	"strconv"
+)
+
 // BillingFlags is used to set which integrations a plan is authorize to use
 type BillingFlags int
 
-// BillingPlan defines what one plan to have access to and set limitations
+// BillingPlan defines what one plan to have access to and set limitations.
+// TrialDays is the length of the trial of the plan, it cannot be tried
+// without.
 type BillingPlan struct {
 	ID          string                 `json:"id"`
 	StripeID    string                 `json:"stripeId"`
@@ -11,6 +18,7 @@ type BillingPlan struct {
 	Version     string                 `json:"version"`
 	Price       float32                `json:"price"`
 	YearlyPrice float32                `json:"yearly"`
+	TrialDays   int                    `json:"trialDays"`
 	Params      map[string]interface{} `json:"params"`
 }
 
@@ -30,6 +38,54 @@ func GetPlan(id string) (BillingPlan, bool) {
 	return v, ok
 }
 
+// FindPlan returns a plan by its ID or its name, i.e. "pro_yearly".
+func FindPlan(plan string) (BillingPlan, bool) {
+	if p, ok := plans[plan]; ok {
+		return p, true
+	}
+
+	for _, p := range plans {
+		if p.Name == plan {
+			return p, true
+		}
+	}
+	return BillingPlan{}, false
+}
+
+// GetPlanByStripeID returns the plan billed under the Stripe plan ID.
+func GetPlanByStripeID(stripeID string) (BillingPlan, bool) {
+	for _, p := range plans {
+		if len(p.StripeID) > 0 && p.StripeID == stripeID {
+			return p, true
+		}
+	}
+	return BillingPlan{}, false
+}
+
+// IntParam returns a numeric parameter of the plan, i.e. "dailyCalls".
+func (p BillingPlan) IntParam(name string) (int64, bool) {
+	v, ok := p.Params[name]
+	if !ok {
+		return 0, false
+	}
+
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
 // GetPlans returns a slice of the desired version plans
 func GetPlans(v string) []BillingPlan {
 	var list []BillingPlan
@@ -0,0 +1,40 @@
+package data
+
+import (
+	"testing"
+)
+
+func Test_BillingPlan_IntParam(t *testing.T) {
+	p := BillingPlan{Params: map[string]interface{}{
+		"dailyCalls": float64(50000),
+		"perMinute":  "120",
+		"name":       true,
+	}}
+
+	if v, ok := p.IntParam("dailyCalls"); !ok || v != 50000 {
+		t.Errorf("expected dailyCalls to be 50000 got %d", v)
+	}
+	if v, ok := p.IntParam("perMinute"); !ok || v != 120 {
+		t.Errorf("expected perMinute to be 120 got %d", v)
+	}
+	if _, ok := p.IntParam("name"); ok {
+		t.Error("expected a non-numeric param to be ignored")
+	}
+	if _, ok := p.IntParam("missing"); ok {
+		t.Error("expected a missing param to be ignored")
+	}
+}
+
+func Test_BillingPlan_FindPlan(t *testing.T) {
+	AddPlan(BillingPlan{ID: "unit_pro_yearly_id", Name: "unit_pro_yearly"})
+
+	if p, ok := FindPlan("unit_pro_yearly_id"); !ok || p.Name != "unit_pro_yearly" {
+		t.Error("expected to find the plan by ID")
+	}
+	if p, ok := FindPlan("unit_pro_yearly"); !ok || p.ID != "unit_pro_yearly_id" {
+		t.Error("expected to find the plan by name")
+	}
+	if _, ok := FindPlan("unit_missing"); ok {
+		t.Error("expected to not find a missing plan")
+	}
+}
@@ -20,6 +20,8 @@ func (db *DB) Open(driverName, dataSource string) error {
 
 	db.Users = &postgres.Users{DB: conn}
 	db.Webhooks = &postgres.Webhooks{DB: conn}
+	db.Emails = &postgres.Emails{DB: conn}
+	db.Billing = &postgres.Billing{DB: conn}
 
 	db.Connection = conn
 
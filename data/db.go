@@ -23,6 +23,10 @@ type DB struct {
 	Users UserServices
 	// Webhooks contains the data access functions related to managing Webhooks.
 	Webhooks WebhookServices
+	// Emails contains the data access functions related to the email outbox.
+	Emails EmailServices
+	// Billing contains the data access functions related to the payment provider's events.
+	Billing BillingServices
 }
 
 // UserServices is an interface that contians all functions related to account, user and billing.
@@ -41,6 +45,12 @@ type UserServices interface {
 	ConvertToPaid(id int64, stripeID, subID, plan string, yearly bool, seats int) error
 	ChangePlan(id int64, plan string, yearly bool) error
 	Cancel(id int64) error
+	SetPastDue(id int64, since time.Time) error
+	SetTrial(id int64, trial model.Trial) error
+	Trials(endsBefore time.Time) ([]model.Account, error)
+	EmailPreferences(userID int64) (map[model.EmailCategory]bool, error)
+	SetEmailPreference(userID int64, category model.EmailCategory, subscribed bool) error
+	AcceptsEmail(email string, category model.EmailCategory) (bool, error)
 }
 
 // AdminServices TODO: investigate this...
@@ -56,6 +66,23 @@ type WebhookServices interface {
 	AllSubscriptions(event string) ([]model.Webhook, error)
 }
 
+// EmailServices is an interface that contains all functions related to the
+// email outbox and the suppressed addresses.
+type EmailServices interface {
+	Record(e *model.Email) error
+	UpdateStatus(provider, messageID string, status model.EmailStatus, reason string) error
+	Suppress(email, reason string) error
+	IsSuppressed(email string) (bool, error)
+}
+
+// BillingServices is an interface that contains all functions related to the
+// webhook events of the payment provider. RecordEvent returns false for the
+// events already recorded, they must not be processed twice.
+type BillingServices interface {
+	RecordEvent(id, kind string) (bool, error)
+	ForgetEvent(id string) error
+}
+
 // NewID returns a per second unique string based on account and user ids.
 func NewID(accountID, userID int64) string {
 	n := time.Now()
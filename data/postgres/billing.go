@@ -0,0 +1,32 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Billing stores the payment provider's webhook events already processed.
+type Billing struct {
+	DB *sql.DB
+}
+
+// RecordEvent returns false when the event was already recorded.
+func (b *Billing) RecordEvent(id, kind string) (bool, error) {
+	res, err := b.DB.Exec(`
+		INSERT INTO gosaas_billing_events(id, type, received)
+		VALUES($1, $2, $3)
+		ON CONFLICT (id) DO NOTHING
+	`, id, kind, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ForgetEvent removes the event so it is processed again when it is resent.
+func (b *Billing) ForgetEvent(id string) error {
+	_, err := b.DB.Exec("DELETE FROM gosaas_billing_events WHERE id = $1", id)
+	return err
+}
@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/jlb922/gosaas/model"
+)
+
+func TestBillingEvents(t *testing.T) {
+	t.Parallel()
+
+	billing := &Billing{DB: db}
+	id := "evt_" + model.NewToken(1)
+
+	if ok, err := billing.RecordEvent(id, "invoice.payment_succeeded"); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected the first event to be recorded")
+	}
+
+	if ok, err := billing.RecordEvent(id, "invoice.payment_succeeded"); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Error("expected the replayed event to be refused")
+	}
+
+	if err := billing.ForgetEvent(id); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := billing.RecordEvent(id, "invoice.payment_succeeded"); !ok {
+		t.Error("expected a forgotten event to be recorded again")
+	}
+}
@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jlb922/gosaas/model"
+)
+
+// Emails stores the email outbox and the suppressed addresses.
+type Emails struct {
+	DB *sql.DB
+}
+
+// Record adds the email to the outbox, or updates it when its task was already
+// recorded, i.e. a retried task.
+func (es *Emails) Record(e *model.Email) error {
+	now := time.Now()
+	if e.Created.IsZero() {
+		e.Created = now
+	}
+	e.Updated = now
+
+	return es.DB.QueryRow(`
+		INSERT INTO gosaas_emails(task_id, recipient, template, subject, provider, message_id, status, reason, created, updated)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (task_id) DO UPDATE
+		SET provider = EXCLUDED.provider,
+			message_id = EXCLUDED.message_id,
+			status = EXCLUDED.status,
+			reason = EXCLUDED.reason,
+			updated = EXCLUDED.updated
+		RETURNING id, created
+	`, e.TaskID, strings.ToLower(e.To), e.Template, e.Subject, e.Provider, e.MessageID,
+		e.Status, e.Reason, e.Created, e.Updated).Scan(&e.ID, &e.Created)
+}
+
+// UpdateStatus sets the status of the email the provider identifies by
+// messageID. A complaint is never overwritten and a bounce only by a
+// complaint, the events may arrive in any order.
+func (es *Emails) UpdateStatus(provider, messageID string, status model.EmailStatus, reason string) error {
+	_, err := es.DB.Exec(`
+		UPDATE gosaas_emails
+		SET status = $3, reason = $4, updated = $5
+		WHERE provider = $1 AND message_id = $2 AND
+			status <> 'complained' AND
+			(status <> 'bounced' OR $3 = 'complained')
+	`, provider, messageID, status, reason, time.Now())
+	return err
+}
+
+// Suppress prevents sending emails to the address.
+func (es *Emails) Suppress(email, reason string) error {
+	_, err := es.DB.Exec(`
+		INSERT INTO gosaas_email_suppressions(email, reason, created)
+		VALUES($1, $2, $3)
+		ON CONFLICT (email) DO NOTHING
+	`, strings.ToLower(email), reason, time.Now())
+	return err
+}
+
+// IsSuppressed returns whether the address was suppressed.
+func (es *Emails) IsSuppressed(email string) (bool, error) {
+	var n int
+	err := es.DB.QueryRow(`
+		SELECT count(*) FROM gosaas_email_suppressions WHERE email = $1
+	`, strings.ToLower(email)).Scan(&n)
+	return n > 0, err
+}
+
+// Get returns the email recorded for the task.
+func (es *Emails) Get(taskID string) (*model.Email, error) {
+	e := &model.Email{}
+	err := es.DB.QueryRow(`
+		SELECT id, task_id, recipient, template, subject, provider, message_id, status, reason, created, updated
+		FROM gosaas_emails
+		WHERE task_id = $1
+	`, taskID).Scan(&e.ID, &e.TaskID, &e.To, &e.Template, &e.Subject, &e.Provider,
+		&e.MessageID, &e.Status, &e.Reason, &e.Created, &e.Updated)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/jlb922/gosaas/model"
+)
+
+func TestEmailsOutbox(t *testing.T) {
+	t.Parallel()
+
+	emails := &Emails{DB: db}
+	e := &model.Email{
+		TaskID:   model.NewToken(1),
+		To:       "Outbox@UnitTest.com",
+		Template: "welcome",
+		Subject:  "Welcome",
+		Provider: "postmark",
+		Status:   model.EmailFailed,
+	}
+	if err := emails.Record(e); err != nil {
+		t.Fatal(err)
+	}
+
+	// the retried task updates the same email
+	e.MessageID, e.Status = e.TaskID+"-msg", model.EmailSent
+	if err := emails.Record(e); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []model.EmailStatus{model.EmailBounced, model.EmailDelivered} {
+		if err := emails.UpdateStatus("postmark", e.MessageID, s, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := emails.Get(e.TaskID)
+	if err != nil {
+		t.Fatal(err)
+	} else if got.ID != e.ID || got.To != "outbox@unittest.com" || got.Status != model.EmailBounced {
+		t.Errorf("unexpected email %+v", got)
+	}
+}
+
+func TestEmailsSuppress(t *testing.T) {
+	t.Parallel()
+
+	emails := &Emails{DB: db}
+	if ok, err := emails.IsSuppressed("suppressed@unittest.com"); err != nil || ok {
+		t.Fatalf("expected the address to be allowed, got %v %v", ok, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := emails.Suppress("Suppressed@UnitTest.com", string(model.EmailBounced)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ok, err := emails.IsSuppressed("suppressed@unittest.com"); err != nil || !ok {
+		t.Errorf("expected the address to be suppressed, got %v %v", ok, err)
+	}
+}
@@ -25,7 +25,7 @@ func TestMain(m *testing.M) {
 	}
 
 	// we make sure to clean everything before starting the tests
-	_, err = conn.Exec("DELETE FROM gosaas_accounts;")
+	_, err = conn.Exec("DELETE FROM gosaas_accounts; DELETE FROM gosaas_emails; DELETE FROM gosaas_email_suppressions; DELETE FROM gosaas_billing_events;")
 	if err != nil {
 		log.Fatal(err)
 	}
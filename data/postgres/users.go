@@ -91,18 +91,12 @@ func (u *Users) Auth(accountID int64, token string, pat bool) (*model.Account, *
 
 func (u *Users) GetDetail(id int64) (*model.Account, error) {
 	account := &model.Account{}
-	row := u.DB.QueryRow("SELECT * FROM gosaas_accounts WHERE id = $1", id)
-	err := row.Scan(&account.ID,
-		&account.Email,
-		&account.StripeID,
-		&account.SubscriptionID,
-		&account.Plan,
-		&account.IsYearly,
-		&account.SubscribedOn,
-		&account.Seats,
-		&account.IsActive,
-	)
-	if err != nil {
+	row := u.DB.QueryRow(`
+		SELECT `+accountColumns+`
+		FROM gosaas_accounts
+		WHERE id = $1
+	`, id)
+	if err := u.scanAccount(row, account); err != nil {
 		fmt.Println("error while scanning account")
 		return nil, err
 	}
@@ -180,7 +174,8 @@ func (u *Users) ConvertToPaid(id int64, stripeID, subID, plan string, yearly boo
 			subscribed_on = $4,
 			plan = $5,
 			seats = $6,
-			is_yearly = $7
+			is_yearly = $7,
+			is_trial = false
 		WHERE id = $1
 	`, id, stripeID, subID, time.Now(), plan, seats, yearly)
 	return err
@@ -201,16 +196,178 @@ func (u *Users) Cancel(id int64) error {
 		UPDATE gosaas_accounts SET
 			subscription_id = '',
 			plan = '',
-			is_yearly = false
+			is_yearly = false,
+			past_due_since = NULL
 		WHERE id = $1
 	`, id)
 	return err
 }
 
+// SetPastDue marks the account past due since the failed payment, a zero time
+// restores it.
+func (u *Users) SetPastDue(id int64, since time.Time) error {
+	_, err := u.DB.Exec(`
+		UPDATE gosaas_accounts SET
+			past_due_since = $2
+		WHERE id = $1
+	`, id, nullTime(since))
+	return err
+}
+
+// SetTrial saves the trial of the account.
+func (u *Users) SetTrial(id int64, trial model.Trial) error {
+	_, err := u.DB.Exec(`
+		UPDATE gosaas_accounts SET
+			is_trial = $2,
+			trial_plan = $3,
+			trial_start = $4,
+			trial_end = $5,
+			trial_extended = $6,
+			trial_reminded = $7
+		WHERE id = $1
+	`, id, trial.IsTrial, trial.Plan, nullTime(trial.Start), nullTime(trial.End), trial.Extended, trial.Reminded)
+	return err
+}
+
+// Trials returns the accounts on trial whose trial ends before t, without
+// their users.
+func (u *Users) Trials(endsBefore time.Time) ([]model.Account, error) {
+	rows, err := u.DB.Query(`
+		SELECT `+accountColumns+`
+		FROM gosaas_accounts
+		WHERE is_trial AND trial_end < $1
+		ORDER BY trial_end
+	`, endsBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []model.Account
+	for rows.Next() {
+		var account model.Account
+		if err := u.scanAccount(rows, &account); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// EmailPreferences returns whether the user is subscribed to each category of
+// emails, the users are subscribed to all categories by default.
+func (u *Users) EmailPreferences(userID int64) (map[model.EmailCategory]bool, error) {
+	prefs := make(map[model.EmailCategory]bool)
+	for _, c := range model.EmailCategories {
+		prefs[c] = true
+	}
+
+	rows, err := u.DB.Query(`
+		SELECT category, subscribed
+		FROM gosaas_email_preferences
+		WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c model.EmailCategory
+		var subscribed bool
+		if err := rows.Scan(&c, &subscribed); err != nil {
+			return nil, err
+		}
+		if _, ok := prefs[c]; ok {
+			prefs[c] = subscribed
+		}
+	}
+	return prefs, rows.Err()
+}
+
+// SetEmailPreference subscribes or unsubscribes the user from a category of
+// emails.
+func (u *Users) SetEmailPreference(userID int64, category model.EmailCategory, subscribed bool) error {
+	if category == model.EmailTransactional {
+		return fmt.Errorf("cannot unsubscribe from the transactional emails")
+	}
+
+	_, err := u.DB.Exec(`
+		INSERT INTO gosaas_email_preferences(user_id, category, subscribed, updated)
+		VALUES($1, $2, $3, $4)
+		ON CONFLICT (user_id, category) DO UPDATE
+		SET subscribed = EXCLUDED.subscribed, updated = EXCLUDED.updated
+	`, userID, category, subscribed, time.Now())
+	return err
+}
+
+// AcceptsEmail returns whether the address accepts the category of emails, the
+// transactional emails and the addresses without user are always accepted.
+func (u *Users) AcceptsEmail(email string, category model.EmailCategory) (bool, error) {
+	if category == model.EmailTransactional || len(category) == 0 {
+		return true, nil
+	}
+
+	var subscribed bool
+	err := u.DB.QueryRow(`
+		SELECT p.subscribed
+		FROM gosaas_email_preferences p
+		INNER JOIN gosaas_users u ON u.id = p.user_id
+		WHERE lower(u.email) = lower($1) AND p.category = $2
+	`, email, category).Scan(&subscribed)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return subscribed, err
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
 type scanner interface {
 	Scan(dest ...interface{}) error
 }
 
+// accountColumns are the gosaas_accounts columns read by scanAccount.
+const accountColumns = `id, email, stripe_id, subscription_id, plan, is_yearly, subscribed_on, seats, is_active, past_due_since,
+			is_trial, trial_plan, trial_start, trial_end, trial_extended, trial_reminded`
+
+func (u *Users) scanAccount(row scanner, account *model.Account) error {
+	var pastDue, trialStart, trialEnd sql.NullTime
+	err := row.Scan(&account.ID,
+		&account.Email,
+		&account.StripeID,
+		&account.SubscriptionID,
+		&account.Plan,
+		&account.IsYearly,
+		&account.SubscribedOn,
+		&account.Seats,
+		&account.IsActive,
+		&pastDue,
+		&account.TrialInfo.IsTrial,
+		&account.TrialInfo.Plan,
+		&trialStart,
+		&trialEnd,
+		&account.TrialInfo.Extended,
+		&account.TrialInfo.Reminded,
+	)
+	if err != nil {
+		return err
+	}
+	if pastDue.Valid {
+		account.PastDueSince = pastDue.Time
+	}
+	if trialStart.Valid {
+		account.TrialInfo.Start = trialStart.Time
+	}
+	if trialEnd.Valid {
+		account.TrialInfo.End = trialEnd.Time
+	}
+	return nil
+}
+
 func (u *Users) AddToken(accountID, userID int64, name string) (*model.AccessToken, error) {
 	return nil, fmt.Errorf("not implemented")
 }
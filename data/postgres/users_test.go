@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jlb922/gosaas/model"
 )
@@ -98,3 +99,124 @@ func TestUsersCancel(t *testing.T) {
 		t.Errorf("expected sub id to be '' got: %s", check.SubscribedOn)
 	}
 }
+
+func TestUsersPastDue(t *testing.T) {
+	t.Parallel()
+
+	users := &Users{DB: db}
+	acct := createAccountAndUser(t, users, "pastdue@unittest.com", "1234")
+
+	since := time.Now().UTC().Truncate(time.Second)
+	if err := users.SetPastDue(acct.ID, since); err != nil {
+		t.Fatal(err)
+	}
+
+	check, err := users.GetDetail(acct.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if !check.IsPastDue() || check.PastDueSince.Unix() != since.Unix() {
+		t.Errorf("expected the account past due since %v got: %v", since, check.PastDueSince)
+	}
+
+	if err := users.SetPastDue(acct.ID, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	check, err = users.GetDetail(acct.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if check.IsPastDue() {
+		t.Errorf("expected the account restored got past due since: %v", check.PastDueSince)
+	}
+}
+
+func TestUsersTrial(t *testing.T) {
+	t.Parallel()
+
+	users := &Users{DB: db}
+	acct := createAccountAndUser(t, users, "trial@unittest.com", "1234")
+
+	start := time.Now().UTC().Truncate(time.Second)
+	trial := model.Trial{IsTrial: true, Plan: "planA", Start: start, End: start.AddDate(0, 0, 14), Extended: 7}
+	if err := users.SetTrial(acct.ID, trial); err != nil {
+		t.Fatal(err)
+	}
+
+	check, err := users.GetDetail(acct.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if !check.TrialInfo.IsTrial || check.TrialInfo.Plan != "planA" || check.TrialInfo.Extended != 7 || check.TrialInfo.End.Unix() != trial.End.Unix() {
+		t.Errorf("expected the trial %+v got: %+v", trial, check.TrialInfo)
+	}
+
+	ending, err := users.Trials(start.AddDate(0, 0, 15))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, a := range ending {
+		if a.ID != acct.ID {
+			continue
+		}
+		found = true
+		if a.Email != acct.Email || a.TrialInfo.Plan != "planA" || a.TrialInfo.End.Unix() != trial.End.Unix() {
+			t.Errorf("expected the account and its trial %+v got: %+v", trial, a)
+		}
+	}
+	if !found {
+		t.Errorf("expected the account in the trials ending, got %d accounts", len(ending))
+	}
+
+	if err := users.ConvertToPaid(acct.ID, "stripe_trial", "sub_trial", "planA", false, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	check, err = users.GetDetail(acct.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if check.TrialInfo.IsTrial {
+		t.Error("expected the trial to end once paid")
+	}
+}
+
+func TestUsersEmailPreferences(t *testing.T) {
+	t.Parallel()
+
+	users := &Users{DB: db}
+	acct := createAccountAndUser(t, users, "prefs@unittest.com", "1234")
+	userID := acct.Users[0].ID
+
+	prefs, err := users.EmailPreferences(userID)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(prefs) != len(model.EmailCategories) || !prefs[model.EmailMarketing] {
+		t.Errorf("expected all categories to be subscribed, got %v", prefs)
+	}
+
+	if err := users.SetEmailPreference(userID, model.EmailMarketing, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetEmailPreference(userID, model.EmailTransactional, false); err == nil {
+		t.Error("expected an error unsubscribing from the transactional emails")
+	}
+
+	if prefs, _ := users.EmailPreferences(userID); prefs[model.EmailMarketing] || !prefs[model.EmailProduct] {
+		t.Errorf("expected to be unsubscribed from marketing only, got %v", prefs)
+	}
+
+	tests := []struct {
+		email    string
+		category model.EmailCategory
+		accepts  bool
+	}{
+		{"Prefs@UnitTest.com", model.EmailMarketing, false},
+		{"prefs@unittest.com", model.EmailProduct, true},
+		{"prefs@unittest.com", model.EmailTransactional, true},
+		{"nobody@unittest.com", model.EmailMarketing, true},
+	}
+	for _, tc := range tests {
+		if ok, err := users.AcceptsEmail(tc.email, tc.category); err != nil || ok != tc.accepts {
+			t.Errorf("%s %s: expected %v got %v %v", tc.email, tc.category, tc.accepts, ok, err)
+		}
+	}
+}
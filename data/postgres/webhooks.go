@@ -52,7 +52,7 @@ func (wh *Webhooks) Delete(accountID int64, event, url string) error {
 }
 
 func (wh *Webhooks) AllSubscriptions(event string) ([]model.Webhook, error) {
-	rows, err := wh.DB.Query("SELECT * FROM webhooks WHERE events = $1")
+	rows, err := wh.DB.Query("SELECT * FROM webhooks WHERE events = $1", event)
 	if err != nil {
 		return nil, err
 	}
@@ -75,11 +75,11 @@ func (wh *Webhooks) AllSubscriptions(event string) ([]model.Webhook, error) {
 }
 
 func (wh *Webhooks) scan(rows *sql.Rows, hook *model.Webhook) error {
-	return rows.Scan(hook.ID,
-		hook.AccountID,
-		hook.EventName,
-		hook.TargetURL,
-		hook.IsActive,
-		hook.Created,
+	return rows.Scan(&hook.ID,
+		&hook.AccountID,
+		&hook.EventName,
+		&hook.TargetURL,
+		&hook.IsActive,
+		&hook.Created,
 	)
 }
@@ -0,0 +1,216 @@
+package gosaas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jlb922/gosaas/cache"
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/payment"
+	"github.com/jlb922/gosaas/queue"
+	"github.com/jlb922/gosaas/queue/email"
+)
+
+// defaultReminderDays are the days after a failed payment the dunning
+// reminders are sent when none are configured.
+var defaultReminderDays = []int{3, 7}
+
+// DunningReminder is the payload of the queue.TaskDunning task. Since is when
+// the payment failed and Day the number of days after it the task runs.
+type DunningReminder struct {
+	AccountID int64     `json:"accountId"`
+	Since     time.Time `json:"since"`
+	Day       int       `json:"day"`
+}
+
+var (
+	billingDBMu sync.RWMutex
+	billingDB   *data.DB
+)
+
+// SetBillingDB sets the database used by the billing tasks executed by the
+// queue and the cron jobs, the dunning reminders and the trials job fail until
+// it is set.
+//
+//	gosaas.SetBillingDB(db)
+func SetBillingDB(db *data.DB) {
+	billingDBMu.Lock()
+	defer billingDBMu.Unlock()
+	billingDB = db
+}
+
+func currentBillingDB() *data.DB {
+	billingDBMu.RLock()
+	defer billingDBMu.RUnlock()
+	return billingDB
+}
+
+func init() {
+	queue.Register(queue.TaskDunning, runDunning)
+}
+
+// BillingBanner is the billing state the pages show to the past due accounts.
+// DowngradeOn is zero when there is no grace period.
+type BillingBanner struct {
+	PastDue     bool
+	Since       time.Time
+	DowngradeOn time.Time
+}
+
+// getBillingBanner returns the banner of the authenticated account, nil if its
+// payments are up to date.
+func getBillingBanner(ctx context.Context) *BillingBanner {
+	auth, ok := ctx.Value(ContextAuth).(Auth)
+	if !ok {
+		return nil
+	}
+
+	since, err := cache.GetAccountPastDue(auth.AccountID)
+	if err != nil {
+		log.Println("unable to get the account past due state", auth.AccountID, err)
+		return nil
+	} else if since.IsZero() {
+		return nil
+	}
+
+	banner := &BillingBanner{PastDue: true, Since: since}
+	if grace := config.Current.Dunning.GraceDays; grace > 0 {
+		banner.DowngradeOn = since.AddDate(0, 0, grace)
+	}
+	return banner
+}
+
+// startDunning marks the account past due and schedules its first reminder.
+func startDunning(db *data.DB, account *model.Account) error {
+	since := time.Now().UTC().Truncate(time.Second)
+	if err := db.Users.SetPastDue(account.ID, since); err != nil {
+		return fmt.Errorf("unable to set this account %v past due: %v", account.ID, err)
+	}
+	account.PastDueSince = since
+	cacheAccountPastDue(account.ID, since)
+
+	scheduleDunning(account.ID, since, 0)
+	return nil
+}
+
+// stopDunning restores the past due account, its scheduled reminders do
+// nothing once they run.
+func stopDunning(db *data.DB, account *model.Account) error {
+	if err := db.Users.SetPastDue(account.ID, time.Time{}); err != nil {
+		return fmt.Errorf("unable to restore this account %v: %v", account.ID, err)
+	}
+	account.PastDueSince = time.Time{}
+	cacheAccountPastDue(account.ID, time.Time{})
+	return nil
+}
+
+// scheduleDunning enqueues the dunning step following day.
+func scheduleDunning(accountID int64, since time.Time, day int) {
+	next := nextDunningDay(day)
+	if next == 0 {
+		return
+	}
+
+	p := DunningReminder{AccountID: accountID, Since: since, Day: next}
+	if _, err := queue.EnqueueAt(queue.TaskDunning, p, since.AddDate(0, 0, next)); err != nil {
+		log.Println("unable to schedule the dunning reminder", accountID, next, err)
+	}
+}
+
+// nextDunningDay returns the day of the step following day, a reminder or the
+// downgrade at the end of the grace period, 0 if there is none.
+func nextDunningDay(day int) int {
+	grace := config.Current.Dunning.GraceDays
+
+	days := config.Current.Dunning.ReminderDays
+	if len(days) == 0 {
+		days = defaultReminderDays
+	}
+	days = append([]int(nil), days...)
+	sort.Ints(days)
+
+	for _, d := range days {
+		if d > day && (grace == 0 || d < grace) {
+			return d
+		}
+	}
+	if grace > day {
+		return grace
+	}
+	return 0
+}
+
+// runDunning executes the queue.TaskDunning tasks. The account is reminded to
+// update its payment details, or downgraded once the grace period is over.
+func runDunning(ctx context.Context, p DunningReminder) error {
+	db := currentBillingDB()
+	if db == nil {
+		return errors.New("the billing database is not set, see SetBillingDB")
+	}
+
+	account, err := db.Users.GetDetail(p.AccountID)
+	if err == sql.ErrNoRows {
+		return queue.Permanent(err)
+	} else if err != nil {
+		return err
+	}
+
+	// a payment succeeded or failed again since this step was scheduled
+	if !account.IsPastDue() || account.PastDueSince.Unix() != p.Since.Unix() {
+		return nil
+	}
+
+	grace := config.Current.Dunning.GraceDays
+	if grace > 0 && p.Day >= grace {
+		return downgradePastDue(db, account)
+	}
+
+	data := map[string]interface{}{
+		"Since": formatBillingDate(p.Since.Unix()),
+		"URL":   config.Current.AppURL,
+	}
+	if grace > 0 {
+		data["DowngradeOn"] = formatBillingDate(p.Since.AddDate(0, 0, grace).Unix())
+	}
+	sendBillingEmail(account, email.TemplatePaymentReminder, model.EmailTransactional, data)
+
+	scheduleDunning(account.ID, p.Since, p.Day)
+	return nil
+}
+
+// downgradePastDue cancels the subscription of the account still past due at
+// the end of the grace period, it is back on the free plan.
+func downgradePastDue(db *data.DB, account *model.Account) error {
+	if len(account.SubscriptionID) > 0 {
+		err := payment.Current().CancelSubscription(account.SubscriptionID)
+		if err != nil && err != payment.ErrNotFound {
+			return err
+		}
+	}
+
+	if err := db.Users.Cancel(account.ID); err != nil {
+		return fmt.Errorf("unable to cancel this account %v: %v", account.ID, err)
+	}
+	cacheAccountPlan(account.ID, "")
+	cacheAccountPastDue(account.ID, time.Time{})
+
+	sendBillingEmail(account, email.TemplateCanceled, model.EmailTransactional, map[string]interface{}{
+		"URL": config.Current.AppURL,
+	})
+	return nil
+}
+
+// cacheAccountPastDue refreshes the past due state shown in the billing banner.
+func cacheAccountPastDue(accountID int64, since time.Time) {
+	if err := cache.SetAccountPastDue(accountID, since); err != nil {
+		log.Println("unable to cache the account past due state", accountID, err)
+	}
+}
@@ -0,0 +1,205 @@
+package gosaas
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jlb922/gosaas/cache"
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/payment"
+	"github.com/jlb922/gosaas/queue"
+	"github.com/jlb922/gosaas/queue/email"
+)
+
+// queuedDunning returns the dunning steps scheduled for the account and
+// removes them from the queue.
+func queuedDunning(t *testing.T, accountID int64) []DunningReminder {
+	tasks, err := queue.Tasks(queue.StatusQueued, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []DunningReminder
+	for _, ti := range tasks {
+		var p DunningReminder
+		if ti.ID != queue.TaskDunning || json.Unmarshal(ti.Data, &p) != nil || p.AccountID != accountID {
+			continue
+		}
+		if ti.RunAt == nil || !ti.RunAt.Equal(p.Since.AddDate(0, 0, p.Day)) {
+			t.Errorf("expected the dunning step of day %d to run on %v, got %v", p.Day, p.Since.AddDate(0, 0, p.Day), ti.RunAt)
+		}
+		steps = append(steps, p)
+		queue.Purge(ti.UUID)
+	}
+	return steps
+}
+
+func Test_Billing_DunningDays(t *testing.T) {
+	defer func(d config.DunningConfig) { config.Current.Dunning = d }(config.Current.Dunning)
+
+	cases := []struct {
+		reminders []int
+		grace     int
+		steps     []int
+	}{
+		{nil, 0, []int{3, 7}},
+		{[]int{7, 1, 3}, 0, []int{1, 3, 7}},
+		{nil, 5, []int{3, 5}},
+		{[]int{3, 7, 14}, 14, []int{3, 7, 14}},
+		{[]int{2}, 1, []int{1}},
+	}
+	for _, c := range cases {
+		config.Current.Dunning = config.DunningConfig{ReminderDays: c.reminders, GraceDays: c.grace}
+
+		var steps []int
+		for day := nextDunningDay(0); day > 0; day = nextDunningDay(day) {
+			steps = append(steps, day)
+		}
+		if fmt.Sprint(steps) != fmt.Sprint(c.steps) {
+			t.Errorf("reminders %v grace %d: expected the steps %v got %v", c.reminders, c.grace, c.steps, steps)
+		}
+	}
+}
+
+func Test_Billing_Dunning(t *testing.T) {
+	cache.New(false, true, nil)
+
+	defer func(s string, d config.DunningConfig) {
+		config.Current.StripeWebhookSecret, config.Current.Dunning = s, d
+	}(config.Current.StripeWebhookSecret, config.Current.Dunning)
+	config.Current.StripeWebhookSecret = testStripeSecret
+	config.Current.Dunning = config.DunningConfig{ReminderDays: []int{3}, GraceDays: 7}
+
+	f := payment.NewFake()
+	f.Prices["pro"] = 3000
+	payment.SetProvider(f)
+	defer payment.SetProvider(nil)
+
+	cus, _ := f.CreateCustomer("dunning@test.com", "tok_visa")
+	sub, _ := f.CreateSubscription(payment.SubscriptionParams{CustomerID: cus.ID, Plan: "pro", Quantity: 1})
+
+	const to = "dunning@test.com"
+	id := time.Now().UnixNano()
+	ta := &testAccounts{accounts: map[int64]*model.Account{
+		id: {ID: id, Email: to, StripeID: cus.ID, SubscriptionID: sub.ID, Plan: "pro", Seats: 1},
+	}}
+	db := &data.DB{Users: ta, Billing: &testBillingEvents{ids: make(map[string]bool)}}
+	SetBillingDB(db)
+	defer SetBillingDB(nil)
+	queuedEmails(t, to)
+
+	ctx := context.WithValue(context.Background(), ContextAuth, Auth{AccountID: id, Email: to})
+	fail := func(evt string) {
+		payload := stripeEvent(evt, StripeInvoicePaymentFailed, map[string]interface{}{"customer": cus.ID, "amount_due": 3000})
+		if rec := stripeEventRequest(db, payload, time.Now(), testStripeSecret); rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// the payment fails, the account is past due until it is paid
+	fail("evt_dunning_1")
+	since := ta.accounts[id].PastDueSince
+	if since.IsZero() {
+		t.Fatal("expected the account to be past due")
+	}
+	if vd := CreateViewData(ctx, nil, nil); vd.Billing == nil || !vd.Billing.PastDue || !vd.Billing.DowngradeOn.Equal(since.AddDate(0, 0, 7)) {
+		t.Errorf("expected the past due banner, got %+v", vd.Billing)
+	}
+	if steps := queuedDunning(t, id); len(steps) != 1 || steps[0].Day != 3 {
+		t.Errorf("expected the reminder of day 3 to be scheduled, got %+v", steps)
+	}
+	queuedEmails(t, to)
+
+	// Stripe's retries do not restart the dunning
+	fail("evt_dunning_2")
+	if !ta.accounts[id].PastDueSince.Equal(since) || len(queuedDunning(t, id)) > 0 {
+		t.Error("expected the dunning to continue from the first failure")
+	}
+	queuedEmails(t, to)
+
+	if err := runDunning(context.Background(), DunningReminder{AccountID: id, Since: since, Day: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if templates := queuedEmails(t, to); len(templates) != 1 || templates[0] != email.TemplatePaymentReminder {
+		t.Errorf("expected the payment reminder email, got %v", templates)
+	}
+	if steps := queuedDunning(t, id); len(steps) != 1 || steps[0].Day != 7 {
+		t.Errorf("expected the downgrade of day 7 to be scheduled, got %+v", steps)
+	}
+
+	// the grace period is over
+	if err := runDunning(context.Background(), DunningReminder{AccountID: id, Since: since, Day: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if a := ta.accounts[id]; a.IsPaid() || a.IsPastDue() {
+		t.Errorf("expected the account to be downgraded, got %+v", a)
+	}
+	if _, err := f.NextInvoice(cus.ID); err == nil {
+		t.Error("expected the subscription to be canceled")
+	}
+	if templates := queuedEmails(t, to); len(templates) != 1 || templates[0] != email.TemplateCanceled {
+		t.Errorf("expected the canceled email, got %v", templates)
+	}
+	if vd := CreateViewData(ctx, nil, nil); vd.Billing != nil {
+		t.Errorf("expected no banner, got %+v", vd.Billing)
+	}
+}
+
+func Test_Billing_DunningRestored(t *testing.T) {
+	cache.New(false, true, nil)
+
+	defer func(s string, d config.DunningConfig) {
+		config.Current.StripeWebhookSecret, config.Current.Dunning = s, d
+	}(config.Current.StripeWebhookSecret, config.Current.Dunning)
+	config.Current.StripeWebhookSecret = testStripeSecret
+	config.Current.Dunning = config.DunningConfig{}
+
+	const to = "dunning-restored@test.com"
+	id := time.Now().UnixNano()
+	ta := &testAccounts{accounts: map[int64]*model.Account{
+		id: {ID: id, Email: to, StripeID: "cus_restored", SubscriptionID: "sub_restored", Plan: "pro", Seats: 1},
+	}}
+	db := &data.DB{Users: ta, Billing: &testBillingEvents{ids: make(map[string]bool)}}
+	SetBillingDB(db)
+	defer SetBillingDB(nil)
+
+	payload := stripeEvent("evt_restored_1", StripeInvoicePaymentFailed, map[string]interface{}{"customer": "cus_restored", "amount_due": 3000})
+	if rec := stripeEventRequest(db, payload, time.Now(), testStripeSecret); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	since := ta.accounts[id].PastDueSince
+	if steps := queuedDunning(t, id); len(steps) != 1 || steps[0].Day != 3 {
+		t.Errorf("expected the reminder of day 3 to be scheduled, got %+v", steps)
+	}
+
+	payload = stripeEvent("evt_restored_2", StripeInvoicePaid, map[string]interface{}{"customer": "cus_restored", "amount_paid": 3000})
+	if rec := stripeEventRequest(db, payload, time.Now(), testStripeSecret); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	if a := ta.accounts[id]; a.IsPastDue() || !a.IsPaid() {
+		t.Errorf("expected the account to be restored, got %+v", a)
+	}
+
+	ctx := context.WithValue(context.Background(), ContextAuth, Auth{AccountID: id, Email: to})
+	if vd := CreateViewData(ctx, nil, nil); vd.Billing != nil {
+		t.Errorf("expected no banner, got %+v", vd.Billing)
+	}
+
+	// the reminder scheduled before the payment does nothing
+	queuedEmails(t, to)
+	if err := runDunning(context.Background(), DunningReminder{AccountID: id, Since: since, Day: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if templates := queuedEmails(t, to); len(templates) > 0 {
+		t.Errorf("expected no reminder, got %v", templates)
+	}
+	if len(queuedDunning(t, id)) > 0 {
+		t.Error("expected no dunning step to be scheduled")
+	}
+}
@@ -0,0 +1,581 @@
+package gosaas
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlb922/gosaas/cache"
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/queue/email"
+)
+
+// Email handles everything related to the /email requests, the delivery events
+// posted by the email providers update the outbox.
+//
+// POST /email/events/amazonses -> Amazon SES notifications via SNS
+// POST /email/events/postmark -> Postmark delivery, bounce and spam complaint webhooks
+// POST /email/events/mailgun -> Mailgun delivered, failed and complained webhooks
+// GET /email/unsubscribe?token= -> asks to confirm the unsubscription
+// POST /email/unsubscribe?token= -> unsubscribes from a category of emails, in one click (RFC 8058)
+//
+// Hard bounced and complained addresses are suppressed, they do not receive
+// emails anymore.
+type Email struct{}
+
+func newEmail() *Route {
+	var e interface{} = Email{}
+	return &Route{
+		Logger:      true,
+		WithDB:      true,
+		MinimumRole: model.RolePublic,
+		Handler:     e.(http.Handler),
+	}
+}
+
+func (e Email) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var head string
+	head, r.URL.Path = ShiftPath(r.URL.Path)
+	if head == "events" && r.Method == http.MethodPost {
+		head, r.URL.Path = ShiftPath(r.URL.Path)
+		e.events(w, r, head)
+		return
+	} else if head == "unsubscribe" {
+		e.unsubscribe(w, r)
+		return
+	}
+	notFound(w)
+}
+
+// emailEvent is a delivery event of an email sent by a provider.
+type emailEvent struct {
+	MessageID string
+	Recipient string
+	Status    model.EmailStatus
+	Reason    string
+}
+
+// maxEventSize limits the size of the delivery events.
+const maxEventSize = 1 << 20
+
+func (e Email) events(w http.ResponseWriter, r *http.Request, provider string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEventSize))
+	if err != nil {
+		Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var events []emailEvent
+	switch config.EmailProvider(provider) {
+	case config.EmailProviderSES:
+		events, err = sesEvents(body)
+	case config.EmailProviderPostmark:
+		events, err = postmarkEvents(r, body)
+	case config.EmailProviderMailgun:
+		events, err = mailgunEvents(body)
+	default:
+		notFound(w)
+		return
+	}
+	if err != nil {
+		Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	db, ok := r.Context().Value(ContextDatabase).(*data.DB)
+	if !ok || db.Emails == nil {
+		Respond(w, r, http.StatusInternalServerError, errors.New("the email outbox is not available"))
+		return
+	}
+
+	for _, ev := range events {
+		if err := db.Emails.UpdateStatus(provider, ev.MessageID, ev.Status, ev.Reason); err != nil {
+			// the providers retry the failed webhooks
+			Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if ev.Status == model.EmailBounced || ev.Status == model.EmailComplained {
+			if err := db.Emails.Suppress(ev.Recipient, string(ev.Status)); err != nil {
+				Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+	Respond(w, r, http.StatusOK, true)
+}
+
+// unsubscribe shows the unsubscribe confirmation on GET, the mail clients'
+// one-click POST and the confirmation form unsubscribe.
+func (e Email) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	lng := getLanguage(r.Context())
+
+	address, category, err := email.ParseUnsubscribeToken(config.Current.SecretKey, r.URL.Query().Get("token"))
+	if err == nil && !isEmailCategory(model.EmailCategory(category)) {
+		err = email.ErrInvalidUnsubscribe
+	}
+	if err != nil {
+		renderUnsubscribe(w, http.StatusBadRequest, lng, unsubscribeData{Invalid: true})
+		return
+	}
+
+	page := unsubscribeData{Email: address, Category: translateEmail(lng, "email-category-"+category)}
+	if r.Method == http.MethodGet {
+		renderUnsubscribe(w, http.StatusOK, lng, page)
+		return
+	} else if r.Method != http.MethodPost {
+		Respond(w, r, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	db, ok := r.Context().Value(ContextDatabase).(*data.DB)
+	if !ok {
+		Respond(w, r, http.StatusInternalServerError, errors.New("database not available"))
+		return
+	}
+
+	// the addresses without user have nothing to unsubscribe from
+	user, err := db.Users.GetUserByEmail(address)
+	if err == nil {
+		err = db.Users.SetEmailPreference(user.ID, model.EmailCategory(category), false)
+	} else if err == sql.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	page.Done = true
+	renderUnsubscribe(w, http.StatusOK, lng, page)
+}
+
+func isEmailCategory(c model.EmailCategory) bool {
+	for _, ec := range model.EmailCategories {
+		if ec == c {
+			return true
+		}
+	}
+	return false
+}
+
+type unsubscribeData struct {
+	Email    string
+	Category string
+	Invalid  bool
+	Done     bool
+}
+
+func renderUnsubscribe(w http.ResponseWriter, status int, lng string, data unsubscribeData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	t := template.Must(unsubscribePage.Clone())
+	t.Funcs(template.FuncMap{"translate": func(key string, a ...interface{}) string {
+		return translateEmail(lng, key, a...)
+	}})
+	if err := t.Execute(w, data); err != nil {
+		log.Println("unable to render the unsubscribe page", err)
+	}
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Funcs(template.FuncMap{"translate": translateEmail}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{{ translate "unsubscribe-title" }}</title>
+</head>
+<body>
+<h1>{{ translate "unsubscribe-title" }}</h1>
+{{ if .Invalid }}
+<p>{{ translate "unsubscribe-invalid" }}</p>
+{{ else if .Done }}
+<p>{{ translate "unsubscribe-done" .Email .Category }}</p>
+{{ else }}
+<form method="post">
+<p>{{ translate "unsubscribe-confirm" .Email .Category }}</p>
+<button type="submit">{{ translate "unsubscribe-button" }}</button>
+</form>
+{{ end }}
+</body>
+</html>
+`))
+
+// postmarkEvents returns the event of a Postmark webhook, the requests must be
+// authenticated with the configured webhook credentials.
+func postmarkEvents(r *http.Request, body []byte) ([]emailEvent, error) {
+	c := config.Current.Postmark
+	if len(c.WebhookUsername) == 0 || len(c.WebhookPassword) == 0 {
+		return nil, errors.New("the Postmark webhook credentials are not configured")
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if !ok ||
+		subtle.ConstantTimeCompare([]byte(user), []byte(c.WebhookUsername)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(pass), []byte(c.WebhookPassword)) != 1 {
+		return nil, errors.New("invalid Postmark webhook credentials")
+	}
+
+	var ev struct {
+		RecordType  string
+		MessageID   string
+		Recipient   string
+		Email       string
+		Type        string
+		Description string
+		Details     string
+	}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return nil, err
+	}
+
+	switch ev.RecordType {
+	case "Delivery":
+		return []emailEvent{{MessageID: ev.MessageID, Recipient: ev.Recipient, Status: model.EmailDelivered, Reason: ev.Details}}, nil
+	case "Bounce":
+		// soft bounces and auto-responders are not delivery failures
+		if ev.Type == "HardBounce" || ev.Type == "BadEmailAddress" {
+			return []emailEvent{{MessageID: ev.MessageID, Recipient: ev.Email, Status: model.EmailBounced, Reason: ev.Description}}, nil
+		}
+	case "SpamComplaint":
+		return []emailEvent{{MessageID: ev.MessageID, Recipient: ev.Email, Status: model.EmailComplained, Reason: ev.Type}}, nil
+	}
+	return nil, nil
+}
+
+// mailgunTolerance is how old a Mailgun webhook can be, the tokens of the
+// accepted webhooks are kept as long to refuse their replays.
+const mailgunTolerance = 5 * time.Minute
+
+// mailgunEvents returns the event of a Mailgun webhook after verifying its
+// signature with the webhook signing key, its timestamp and that its token was
+// not used before.
+func mailgunEvents(body []byte) ([]emailEvent, error) {
+	key := config.Current.Mailgun.WebhookSigningKey
+	if len(key) == 0 {
+		return nil, errors.New("the Mailgun webhook signing key is not configured")
+	}
+
+	var hook struct {
+		Signature struct {
+			Timestamp string `json:"timestamp"`
+			Token     string `json:"token"`
+			Signature string `json:"signature"`
+		} `json:"signature"`
+		Event struct {
+			Event     string `json:"event"`
+			Severity  string `json:"severity"`
+			Recipient string `json:"recipient"`
+			Reason    string `json:"reason"`
+			Message   struct {
+				Headers struct {
+					MessageID string `json:"message-id"`
+				} `json:"headers"`
+			} `json:"message"`
+			DeliveryStatus struct {
+				Description string `json:"description"`
+				Message     string `json:"message"`
+			} `json:"delivery-status"`
+		} `json:"event-data"`
+	}
+	if err := json.Unmarshal(body, &hook); err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(hook.Signature.Timestamp + hook.Signature.Token))
+	sig, err := hex.DecodeString(hook.Signature.Signature)
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid Mailgun webhook signature")
+	}
+
+	ts, err := strconv.ParseInt(hook.Signature.Timestamp, 10, 64)
+	if age := time.Since(time.Unix(ts, 0)); err != nil || age > mailgunTolerance || age < -mailgunTolerance {
+		return nil, errors.New("the Mailgun webhook timestamp is outside of the tolerance")
+	}
+	if fresh, err := cache.MarkSeen("mailgun_"+hook.Signature.Token, 2*mailgunTolerance); err != nil {
+		return nil, err
+	} else if !fresh {
+		return nil, errors.New("the Mailgun webhook was already received")
+	}
+
+	ev := hook.Event
+	id := strings.Trim(ev.Message.Headers.MessageID, "<>")
+	switch ev.Event {
+	case "delivered":
+		return []emailEvent{{MessageID: id, Recipient: ev.Recipient, Status: model.EmailDelivered, Reason: ev.DeliveryStatus.Message}}, nil
+	case "failed":
+		// temporary failures are retried by Mailgun
+		if ev.Severity == "permanent" {
+			reason := ev.DeliveryStatus.Description
+			if len(reason) == 0 {
+				reason = ev.Reason
+			}
+			return []emailEvent{{MessageID: id, Recipient: ev.Recipient, Status: model.EmailBounced, Reason: reason}}, nil
+		}
+	case "complained":
+		return []emailEvent{{MessageID: id, Recipient: ev.Recipient, Status: model.EmailComplained}}, nil
+	}
+	return nil, nil
+}
+
+// snsMessage is a message posted by Amazon SNS.
+type snsMessage struct {
+	Type             string
+	MessageId        string
+	Token            string
+	TopicArn         string
+	Subject          string
+	Message          string
+	Timestamp        string
+	SubscribeURL     string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string
+}
+
+// snsHost matches the SNS endpoints the signing certificates and the
+// subscription confirmations are fetched from.
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// sesEvents returns the events of an Amazon SES notification after verifying
+// the SNS signature and topic. The subscriptions of the topics are confirmed.
+func sesEvents(body []byte) ([]emailEvent, error) {
+	var msg snsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+
+	allowed := false
+	for _, arn := range config.Current.SES.TopicARNs {
+		if arn == msg.TopicArn {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("the SNS topic %s is not allowed", msg.TopicArn)
+	}
+
+	if err := msg.verify(); err != nil {
+		return nil, err
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		return nil, snsConfirm(msg.SubscribeURL)
+	case "Notification":
+		return sesNotification(msg.Message)
+	}
+	return nil, nil
+}
+
+// verify checks the signature of the message with the SNS certificate.
+func (msg snsMessage) verify() error {
+	var alg x509.SignatureAlgorithm
+	switch msg.SignatureVersion {
+	case "1":
+		alg = x509.SHA1WithRSA
+	case "2":
+		alg = x509.SHA256WithRSA
+	default:
+		return fmt.Errorf("unsupported SNS signature version %q", msg.SignatureVersion)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid SNS signature: %v", err)
+	}
+
+	cert, err := snsCertificate(msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	if err := cert.CheckSignature(alg, []byte(msg.signedString()), sig); err != nil {
+		return fmt.Errorf("invalid SNS signature: %v", err)
+	}
+	return nil
+}
+
+// signedString returns the fields of the message signed by SNS.
+func (msg snsMessage) signedString() string {
+	fields := [][2]string{{"Message", msg.Message}, {"MessageId", msg.MessageId}}
+	if msg.Type == "Notification" {
+		if len(msg.Subject) > 0 {
+			fields = append(fields, [2]string{"Subject", msg.Subject})
+		}
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", msg.SubscribeURL})
+	}
+	fields = append(fields, [2]string{"Timestamp", msg.Timestamp})
+	if msg.Type != "Notification" {
+		fields = append(fields, [2]string{"Token", msg.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", msg.TopicArn}, [2]string{"Type", msg.Type})
+
+	var sb strings.Builder
+	for _, f := range fields {
+		sb.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+	return sb.String()
+}
+
+// snsURL returns the URL if it is an HTTPS URL of an SNS endpoint.
+func snsURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "https" || !snsHost.MatchString(u.Hostname()) {
+		return "", fmt.Errorf("invalid SNS URL %q", s)
+	}
+	return u.String(), nil
+}
+
+var (
+	snsCertsMu sync.Mutex
+	snsCerts   = make(map[string]*x509.Certificate)
+
+	snsClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// snsCertificate returns the signing certificate at the URL, the certificates
+// are kept once downloaded.
+var snsCertificate = func(certURL string) (*x509.Certificate, error) {
+	u, err := snsURL(certURL)
+	if err != nil {
+		return nil, err
+	}
+
+	snsCertsMu.Lock()
+	defer snsCertsMu.Unlock()
+
+	if cert, ok := snsCerts[u]; ok {
+		return cert, nil
+	}
+
+	res, err := snsClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("invalid SNS certificate at %s", u)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	snsCerts[u] = cert
+	return cert, nil
+}
+
+// snsConfirm confirms the subscription of the topic.
+var snsConfirm = func(subscribeURL string) error {
+	u, err := snsURL(subscribeURL)
+	if err != nil {
+		return err
+	}
+
+	res, err := snsClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to confirm the SNS subscription: %s", res.Status)
+	}
+	log.Println("confirmed the SNS subscription", u)
+	return nil
+}
+
+// sesNotification returns the events of an SES notification, either published
+// by the identity notifications (notificationType) or a configuration set
+// (eventType).
+func sesNotification(message string) ([]emailEvent, error) {
+	var n struct {
+		NotificationType string `json:"notificationType"`
+		EventType        string `json:"eventType"`
+		Mail             struct {
+			MessageID string `json:"messageId"`
+		} `json:"mail"`
+		Bounce struct {
+			BounceType        string `json:"bounceType"`
+			BounceSubType     string `json:"bounceSubType"`
+			BouncedRecipients []struct {
+				EmailAddress   string `json:"emailAddress"`
+				DiagnosticCode string `json:"diagnosticCode"`
+			} `json:"bouncedRecipients"`
+		} `json:"bounce"`
+		Complaint struct {
+			ComplaintFeedbackType string `json:"complaintFeedbackType"`
+			ComplainedRecipients  []struct {
+				EmailAddress string `json:"emailAddress"`
+			} `json:"complainedRecipients"`
+		} `json:"complaint"`
+		Delivery struct {
+			Recipients   []string `json:"recipients"`
+			SMTPResponse string   `json:"smtpResponse"`
+		} `json:"delivery"`
+	}
+	if err := json.Unmarshal([]byte(message), &n); err != nil {
+		return nil, err
+	}
+
+	kind := n.NotificationType
+	if len(kind) == 0 {
+		kind = n.EventType
+	}
+
+	var events []emailEvent
+	switch kind {
+	case "Delivery":
+		for _, to := range n.Delivery.Recipients {
+			events = append(events, emailEvent{MessageID: n.Mail.MessageID, Recipient: to, Status: model.EmailDelivered, Reason: n.Delivery.SMTPResponse})
+		}
+	case "Bounce":
+		// transient bounces are retried by SES
+		if n.Bounce.BounceType != "Permanent" {
+			return nil, nil
+		}
+		for _, to := range n.Bounce.BouncedRecipients {
+			reason := to.DiagnosticCode
+			if len(reason) == 0 {
+				reason = n.Bounce.BounceSubType
+			}
+			events = append(events, emailEvent{MessageID: n.Mail.MessageID, Recipient: to.EmailAddress, Status: model.EmailBounced, Reason: reason})
+		}
+	case "Complaint":
+		for _, to := range n.Complaint.ComplainedRecipients {
+			events = append(events, emailEvent{MessageID: n.Mail.MessageID, Recipient: to.EmailAddress, Status: model.EmailComplained, Reason: n.Complaint.ComplaintFeedbackType})
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,366 @@
+package gosaas
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlb922/gosaas/cache"
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/queue/email"
+)
+
+// testEmails records the status updates and the suppressed addresses.
+type testEmails struct {
+	statuses   map[string]model.EmailStatus
+	suppressed map[string]string
+}
+
+func newTestEmails() *testEmails {
+	return &testEmails{statuses: make(map[string]model.EmailStatus), suppressed: make(map[string]string)}
+}
+
+func (es *testEmails) Record(e *model.Email) error { return nil }
+
+func (es *testEmails) UpdateStatus(provider, messageID string, status model.EmailStatus, reason string) error {
+	es.statuses[provider+":"+messageID] = status
+	return nil
+}
+
+func (es *testEmails) Suppress(email, reason string) error {
+	es.suppressed[email] = reason
+	return nil
+}
+
+func (es *testEmails) IsSuppressed(email string) (bool, error) {
+	_, ok := es.suppressed[email]
+	return ok, nil
+}
+
+func emailEventRequest(es *testEmails, provider string, body []byte, setup func(r *http.Request)) *httptest.ResponseRecorder {
+	ctx := context.WithValue(context.Background(), ContextDatabase, &data.DB{Emails: es})
+	ctx = context.WithValue(ctx, ContextContentIsJSON, true)
+
+	req := httptest.NewRequest("POST", "/events/"+provider, bytes.NewReader(body)).WithContext(ctx)
+	if setup != nil {
+		setup(req)
+	}
+	rec := httptest.NewRecorder()
+	Email{}.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_Email_PostmarkEvents(t *testing.T) {
+	defer func(c config.PostmarkConfig) { config.Current.Postmark = c }(config.Current.Postmark)
+	config.Current.Postmark.WebhookUsername = "hook"
+	config.Current.Postmark.WebhookPassword = "secret"
+
+	es := newTestEmails()
+	auth := func(r *http.Request) { r.SetBasicAuth("hook", "secret") }
+
+	rec := emailEventRequest(es, "postmark", []byte(`{"RecordType":"Delivery","MessageID":"m1","Recipient":"a@test.com"}`), auth)
+	if rec.Code != http.StatusOK || es.statuses["postmark:m1"] != model.EmailDelivered {
+		t.Fatalf("expected the email to be delivered, got %d %v", rec.Code, es.statuses)
+	}
+
+	emailEventRequest(es, "postmark", []byte(`{"RecordType":"Bounce","Type":"SoftBounce","MessageID":"m2","Email":"b@test.com"}`), auth)
+	emailEventRequest(es, "postmark", []byte(`{"RecordType":"Bounce","Type":"HardBounce","MessageID":"m3","Email":"c@test.com"}`), auth)
+	emailEventRequest(es, "postmark", []byte(`{"RecordType":"SpamComplaint","MessageID":"m4","Email":"d@test.com"}`), auth)
+	if _, ok := es.statuses["postmark:m2"]; ok {
+		t.Error("a soft bounce should not change the status")
+	}
+	if es.statuses["postmark:m3"] != model.EmailBounced || es.statuses["postmark:m4"] != model.EmailComplained {
+		t.Errorf("unexpected statuses %v", es.statuses)
+	}
+	if len(es.suppressed) != 2 || es.suppressed["c@test.com"] != "bounced" || es.suppressed["d@test.com"] != "complained" {
+		t.Errorf("unexpected suppressed addresses %v", es.suppressed)
+	}
+
+	rec = emailEventRequest(es, "postmark", []byte(`{"RecordType":"SpamComplaint","MessageID":"m5","Email":"e@test.com"}`), nil)
+	if rec.Code != http.StatusForbidden || len(es.suppressed) != 2 {
+		t.Errorf("expected an unauthenticated event to be refused, got %d", rec.Code)
+	}
+}
+
+func mailgunEvent(key, event, severity, to, id string) []byte {
+	token := fmt.Sprintf("%s-%d", id, time.Now().UnixNano())
+	return signedMailgunEvent(key, time.Now(), token, event, severity, to, id)
+}
+
+func signedMailgunEvent(key string, at time.Time, token, event, severity, to, id string) []byte {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(ts + token))
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"signature": map[string]string{"timestamp": ts, "token": token, "signature": hex.EncodeToString(mac.Sum(nil))},
+		"event-data": map[string]interface{}{
+			"event":     event,
+			"severity":  severity,
+			"recipient": to,
+			"message":   map[string]interface{}{"headers": map[string]string{"message-id": id}},
+		},
+	})
+	return b
+}
+
+func Test_Email_MailgunEvents(t *testing.T) {
+	cache.New(false, true, nil)
+
+	defer func(c config.MailgunConfig) { config.Current.Mailgun = c }(config.Current.Mailgun)
+	config.Current.Mailgun.WebhookSigningKey = "signing-key"
+
+	es := newTestEmails()
+	emailEventRequest(es, "mailgun", mailgunEvent("signing-key", "delivered", "", "a@test.com", "m1@mg.test.com"), nil)
+	emailEventRequest(es, "mailgun", mailgunEvent("signing-key", "failed", "temporary", "b@test.com", "m2@mg.test.com"), nil)
+	emailEventRequest(es, "mailgun", mailgunEvent("signing-key", "failed", "permanent", "c@test.com", "m3@mg.test.com"), nil)
+	emailEventRequest(es, "mailgun", mailgunEvent("signing-key", "complained", "", "d@test.com", "m4@mg.test.com"), nil)
+
+	want := map[string]model.EmailStatus{
+		"mailgun:m1@mg.test.com": model.EmailDelivered,
+		"mailgun:m3@mg.test.com": model.EmailBounced,
+		"mailgun:m4@mg.test.com": model.EmailComplained,
+	}
+	if len(es.statuses) != len(want) {
+		t.Errorf("unexpected statuses %v", es.statuses)
+	}
+	for k, s := range want {
+		if es.statuses[k] != s {
+			t.Errorf("expected %s to be %s, got %s", k, s, es.statuses[k])
+		}
+	}
+	if len(es.suppressed) != 2 {
+		t.Errorf("unexpected suppressed addresses %v", es.suppressed)
+	}
+
+	rec := emailEventRequest(es, "mailgun", mailgunEvent("wrong-key", "complained", "", "e@test.com", "m5@mg.test.com"), nil)
+	if rec.Code != http.StatusForbidden || len(es.suppressed) != 2 {
+		t.Errorf("expected an invalid signature to be refused, got %d", rec.Code)
+	}
+}
+
+func Test_Email_MailgunReplay(t *testing.T) {
+	cache.New(false, true, nil)
+
+	defer func(c config.MailgunConfig) { config.Current.Mailgun = c }(config.Current.Mailgun)
+	config.Current.Mailgun.WebhookSigningKey = "signing-key"
+
+	es := newTestEmails()
+	token := fmt.Sprintf("replay-%d", time.Now().UnixNano())
+
+	stale := signedMailgunEvent("signing-key", time.Now().Add(-10*time.Minute), token+"-stale", "complained", "", "a@test.com", "m1@mg.test.com")
+	if rec := emailEventRequest(es, "mailgun", stale, nil); rec.Code != http.StatusForbidden || len(es.statuses) > 0 {
+		t.Errorf("expected a stale event to be refused, got %d", rec.Code)
+	}
+
+	event := signedMailgunEvent("signing-key", time.Now(), token, "complained", "", "b@test.com", "m2@mg.test.com")
+	if rec := emailEventRequest(es, "mailgun", event, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	delete(es.statuses, "mailgun:m2@mg.test.com")
+
+	if rec := emailEventRequest(es, "mailgun", event, nil); rec.Code != http.StatusForbidden || len(es.statuses) > 0 {
+		t.Errorf("expected a replayed event to be refused, got %d", rec.Code)
+	}
+}
+
+// signSNS signs the message like SNS with SignatureVersion 2.
+func signSNS(t *testing.T, key *rsa.PrivateKey, msg snsMessage) []byte {
+	msg.SignatureVersion = "2"
+	msg.SigningCertURL = "https://sns.us-east-1.amazonaws.com/cert.pem"
+
+	h := sha256.Sum256([]byte(msg.signedString()))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	b, _ := json.Marshal(msg)
+	return b
+}
+
+func Test_Email_SESEvents(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	defer func(f func(string) (*x509.Certificate, error)) { snsCertificate = f }(snsCertificate)
+	snsCertificate = func(u string) (*x509.Certificate, error) {
+		if _, err := snsURL(u); err != nil {
+			return nil, err
+		}
+		return cert, nil
+	}
+	var confirmed string
+	defer func(f func(string) error) { snsConfirm = f }(snsConfirm)
+	snsConfirm = func(u string) error {
+		confirmed = u
+		return nil
+	}
+
+	const topic = "arn:aws:sns:us-east-1:123456789012:ses-events"
+	defer func(c config.SESConfig) { config.Current.SES = c }(config.Current.SES)
+	config.Current.SES.TopicARNs = []string{topic}
+
+	es := newTestEmails()
+	rec := emailEventRequest(es, "amazonses", signSNS(t, key, snsMessage{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    "1",
+		Token:        "tok",
+		TopicArn:     topic,
+		Message:      "confirm",
+		Timestamp:    "2020-01-01T00:00:00.000Z",
+		SubscribeURL: "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription",
+	}), nil)
+	if rec.Code != http.StatusOK || len(confirmed) == 0 {
+		t.Fatalf("expected the subscription to be confirmed, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	notifications := []string{
+		`{"notificationType":"Delivery","mail":{"messageId":"m1"},"delivery":{"recipients":["a@test.com"]}}`,
+		`{"notificationType":"Bounce","mail":{"messageId":"m2"},"bounce":{"bounceType":"Transient","bouncedRecipients":[{"emailAddress":"b@test.com"}]}}`,
+		`{"eventType":"Bounce","mail":{"messageId":"m3"},"bounce":{"bounceType":"Permanent","bouncedRecipients":[{"emailAddress":"c@test.com","diagnosticCode":"550 unknown user"}]}}`,
+		`{"notificationType":"Complaint","mail":{"messageId":"m4"},"complaint":{"complainedRecipients":[{"emailAddress":"d@test.com"}]}}`,
+	}
+	for i, n := range notifications {
+		rec := emailEventRequest(es, "amazonses", signSNS(t, key, snsMessage{
+			Type:      "Notification",
+			MessageId: string(rune('a' + i)),
+			TopicArn:  topic,
+			Message:   n,
+			Timestamp: "2020-01-01T00:00:00.000Z",
+		}), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	if es.statuses["amazonses:m1"] != model.EmailDelivered || es.statuses["amazonses:m3"] != model.EmailBounced ||
+		es.statuses["amazonses:m4"] != model.EmailComplained || len(es.statuses) != 3 {
+		t.Errorf("unexpected statuses %v", es.statuses)
+	}
+	if len(es.suppressed) != 2 || es.suppressed["c@test.com"] != "bounced" {
+		t.Errorf("unexpected suppressed addresses %v", es.suppressed)
+	}
+
+	// a tampered message, an unknown topic or a foreign certificate are refused
+	b := signSNS(t, key, snsMessage{Type: "Notification", MessageId: "x", TopicArn: topic, Message: notifications[3]})
+	b = bytes.Replace(b, []byte("d@test.com"), []byte("x@test.com"), 1)
+	if rec := emailEventRequest(es, "amazonses", b, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("expected a tampered message to be refused, got %d", rec.Code)
+	}
+
+	b = signSNS(t, key, snsMessage{Type: "Notification", MessageId: "y", TopicArn: topic + "-other", Message: notifications[3]})
+	if rec := emailEventRequest(es, "amazonses", b, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("expected an unknown topic to be refused, got %d", rec.Code)
+	}
+
+	if _, err := snsURL("https://sns.us-east-1.amazonaws.com.evil.com/cert.pem"); err == nil {
+		t.Error("expected a foreign certificate URL to be refused")
+	}
+	if len(es.suppressed) != 2 {
+		t.Errorf("unexpected suppressed addresses %v", es.suppressed)
+	}
+}
+
+// testUsers stores the email preferences of the known users.
+type testUsers struct {
+	data.UserServices
+	users map[string]int64
+	prefs map[int64]model.EmailCategory
+}
+
+func (u *testUsers) GetUserByEmail(email string) (*model.User, error) {
+	id, ok := u.users[email]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &model.User{ID: id, Email: email}, nil
+}
+
+func (u *testUsers) SetEmailPreference(userID int64, category model.EmailCategory, subscribed bool) error {
+	if !subscribed {
+		u.prefs[userID] = category
+	}
+	return nil
+}
+
+func Test_Email_Unsubscribe(t *testing.T) {
+	defer func(c config.Configuration) { config.Current = c }(config.Current)
+	config.Current.AppURL, config.Current.SecretKey = "https://app.test", "key"
+
+	users := &testUsers{users: map[string]int64{"you@test.com": 42}, prefs: make(map[int64]model.EmailCategory)}
+	request := func(method, link string, body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ContextDatabase, &data.DB{Users: users})
+		req := httptest.NewRequest(method, strings.TrimPrefix(link, "https://app.test/email"), strings.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		Email{}.ServeHTTP(rec, req)
+		return rec
+	}
+
+	link := email.UnsubscribeURL("you@test.com", string(model.EmailMarketing))
+
+	// prefetching the link does not unsubscribe
+	rec := request("GET", link, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<form method=\"post\">") || len(users.prefs) != 0 {
+		t.Fatalf("expected the confirmation form, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// one-click unsubscribe from the mail client
+	rec = request("POST", link, "List-Unsubscribe=One-Click")
+	if rec.Code != http.StatusOK || users.prefs[42] != model.EmailMarketing {
+		t.Errorf("expected the user to be unsubscribed, got %d %v", rec.Code, users.prefs)
+	}
+
+	// an unknown address has nothing to unsubscribe from
+	if rec := request("POST", email.UnsubscribeURL("nobody@test.com", string(model.EmailProduct)), ""); rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 got %d", rec.Code)
+	}
+
+	for _, l := range []string{
+		link + "x",
+		email.UnsubscribeURL("you@test.com", string(model.EmailTransactional)),
+		"/unsubscribe",
+	} {
+		if rec := request("POST", l, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 got %d", l, rec.Code)
+		}
+	}
+	if len(users.prefs) != 1 {
+		t.Errorf("unexpected preferences %v", users.prefs)
+	}
+}
@@ -19,4 +19,4 @@ require (
 	github.com/NYTimes/gziphandler v1.1.1
 )
 
-go 1.13
+go 1.18
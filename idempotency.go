@@ -0,0 +1,158 @@
+package gosaas
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jlb922/gosaas/cache"
+)
+
+// IdempotencyExpiration is how long the response of a request made with an
+// Idempotency-Key is kept and replayed for retries.
+var IdempotencyExpiration = 24 * time.Hour
+
+// Idempotency is a middleware that makes mutating requests safe to retry.
+//
+// When a POST, PUT, PATCH or DELETE request carries an "Idempotency-Key" HTTP header
+// the first response (status, headers and body) is saved per account and key. Retries
+// with the same key receive the saved response with an "Idempotent-Replayed: true" header
+// instead of executing the handler again.
+//
+// If the first request is still executing, a retry gets a StatusConflict error. If the key is
+// reused with a different request body it gets a StatusUnprocessableEntity error.
+func Idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if len(key) == 0 || !isMutatingMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		keys, _ := ctx.Value(ContextAuth).(Auth)
+
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+		fp := requestFingerprint(r, b)
+
+		ok, prev, err := cache.ReserveIdempotencyKey(keys.AccountID, key, fp, IdempotencyExpiration)
+		if err != nil {
+			Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !ok {
+			if prev.Fingerprint != fp {
+				Respond(w, r, http.StatusUnprocessableEntity, fmt.Errorf("the Idempotency-Key %s was already used with a different request", key))
+				return
+			} else if !prev.Completed {
+				Respond(w, r, http.StatusConflict, fmt.Errorf("a request with the Idempotency-Key %s is already in progress", key))
+				return
+			}
+
+			for k, v := range prev.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(prev.StatusCode)
+			w.Write(prev.Body)
+
+			logRequest(r, prev.StatusCode)
+			return
+		}
+
+		// the key is released unless the response is saved, so the client is
+		// able to retry after a panic of the handler
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+			if err := cache.ReleaseIdempotencyKey(keys.AccountID, key); err != nil {
+				log.Println("unable to release idempotency key", key, err)
+			}
+		}()
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		// server errors are not saved so the client is able to retry
+		if rec.statusCode >= http.StatusInternalServerError {
+			return
+		}
+
+		resp := cache.IdempotentResponse{
+			Fingerprint: fp,
+			StatusCode:  rec.statusCode,
+			Header:      w.Header(),
+			Body:        rec.body.Bytes(),
+		}
+		if err := cache.SaveIdempotentResponse(keys.AccountID, key, resp, IdempotencyExpiration); err != nil {
+			log.Println("unable to save idempotent response", key, err)
+			return
+		}
+		saved = true
+	})
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestFingerprint identifies a request by its method, path and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte(r.URL.Path))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder writes through to the underlying http.ResponseWriter while
+// keeping a copy of the status code and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        *bytes.Buffer
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+		body:           bytes.NewBuffer(nil),
+	}
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
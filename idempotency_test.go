@@ -0,0 +1,104 @@
+package gosaas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Idempotency_ReplayAndMismatch(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	h := Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		Respond(w, r, http.StatusCreated, calls)
+	}))
+
+	key := fmt.Sprintf("unit-test-%d", time.Now().UnixNano())
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/billing/changeplan", bytes.NewReader([]byte(body)))
+		req.Header.Set("Idempotency-Key", key)
+		ctx := context.WithValue(req.Context(), ContextAuth, Auth{AccountID: 1})
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req.WithContext(ctx))
+		return rec
+	}
+
+	first := send(`{"plan":"pro"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status %d got %d", http.StatusCreated, first.Code)
+	}
+
+	retry := send(`{"plan":"pro"}`)
+	if calls != 1 {
+		t.Errorf("expected the handler to be called once got %d", calls)
+	} else if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected the Idempotent-Replayed header on the retry")
+	} else if retry.Body.String() != first.Body.String() {
+		t.Errorf("expected replayed body %s got %s", first.Body.String(), retry.Body.String())
+	}
+
+	mismatch := send(`{"plan":"starter"}`)
+	if mismatch.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d got %d", http.StatusUnprocessableEntity, mismatch.Code)
+	}
+}
+
+func Test_Idempotency_IgnoresSafeMethods(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	h := Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/billing/invoices", nil)
+		req.Header.Set("Idempotency-Key", "unit-test-get")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if calls != 2 {
+		t.Errorf("expected the handler to be called twice got %d", calls)
+	}
+}
+
+func Test_Idempotency_ReleasedOnPanic(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	h := Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("unit test")
+		}
+		Respond(w, r, http.StatusCreated, calls)
+	}))
+
+	key := fmt.Sprintf("unit-test-panic-%d", time.Now().UnixNano())
+	send := func() (rec *httptest.ResponseRecorder, panicked bool) {
+		req := httptest.NewRequest("POST", "/billing/changeplan", bytes.NewReader([]byte(`{"plan":"pro"}`)))
+		req.Header.Set("Idempotency-Key", key)
+		ctx := context.WithValue(req.Context(), ContextAuth, Auth{AccountID: 1})
+
+		defer func() { panicked = recover() != nil }()
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req.WithContext(ctx))
+		return rec, false
+	}
+
+	if _, panicked := send(); !panicked {
+		t.Fatal("expected the handler panic to propagate")
+	}
+
+	retry, _ := send()
+	if retry.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("expected the retry to execute the handler, got status %d and %d calls", retry.Code, calls)
+	}
+}
@@ -14,18 +14,167 @@ type EmailProvider string
 const (
 	// EmailProviderSES used with Amazon SES email service.
 	EmailProviderSES EmailProvider = "amazonses"
+	// EmailProviderGoogle used with the Gmail SMTP server, authenticated with
+	// EmailLogin and EmailPassword.
+	EmailProviderGoogle EmailProvider = "google"
+	// EmailProviderSMTP used with the SMTP server defined by SMTP.
+	EmailProviderSMTP EmailProvider = "smtp"
+	// EmailProviderPostmark used with the Postmark API defined by Postmark.
+	EmailProviderPostmark EmailProvider = "postmark"
+	// EmailProviderMailgun used with the Mailgun API defined by Mailgun.
+	EmailProviderMailgun EmailProvider = "mailgun"
+	// EmailProviderSendGrid used with the SendGrid API defined by SendGrid.
+	EmailProviderSendGrid EmailProvider = "sendgrid"
+	// EmailProviderCatcher keeps the emails in the mailbox read on /tools/mail
+	// instead of sending them, it is always used in dev mode.
+	EmailProviderCatcher EmailProvider = "catcher"
+)
+
+// PostmarkConfig defines the Postmark server used by the postmark email provider.
+type PostmarkConfig struct {
+	ServerToken string `json:"serverToken"`
+	// MessageStream defaults to "outbound".
+	MessageStream string `json:"messageStream"`
+	// WebhookUsername and WebhookPassword are the basic authentication
+	// credentials set on the webhook URL, the events are refused without them.
+	WebhookUsername string `json:"webhookUsername"`
+	WebhookPassword string `json:"webhookPassword"`
+}
+
+// MailgunConfig defines the Mailgun domain used by the mailgun email provider.
+type MailgunConfig struct {
+	Domain string `json:"domain"`
+	APIKey string `json:"apiKey"`
+	// BaseURL defaults to https://api.mailgun.net, use https://api.eu.mailgun.net
+	// for domains in the EU region.
+	BaseURL string `json:"baseURL"`
+	// WebhookSigningKey verifies the signature of the webhook events, they are
+	// refused without it.
+	WebhookSigningKey string `json:"webhookSigningKey"`
+}
+
+// SESConfig defines the SNS topics publishing the Amazon SES notifications.
+type SESConfig struct {
+	// TopicARNs are the topics the notifications are accepted from, they are
+	// refused from any other topic.
+	TopicARNs []string `json:"topicArns"`
+}
+
+// SendGridConfig defines the API key used by the sendgrid email provider.
+type SendGridConfig struct {
+	APIKey string `json:"apiKey"`
+}
+
+// SMTPConfig defines the SMTP server used by the smtp email provider.
+type SMTPConfig struct {
+	Host string `json:"host"`
+	// Port defaults to 587, or 465 when Security is "tls".
+	Port int `json:"port"`
+	// Security is "starttls" (default), "tls" for implicit TLS or "none".
+	Security string `json:"security"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Auth is the authentication mechanism: "plain" (default), "login" or "cram-md5".
+	// No authentication is made without Username.
+	Auth string `json:"auth"`
+}
+
+// DunningConfig defines the reminders sent to the past due accounts, the
+// accounts whose payment failed.
+type DunningConfig struct {
+	// ReminderDays are the days after the failed payment a reminder is sent,
+	// 3 and 7 by default.
+	ReminderDays []int `json:"reminderDays"`
+	// GraceDays is the number of days after the failed payment the account is
+	// downgraded to the free plan. Zero never downgrades it, the subscription
+	// is canceled by Stripe once its retries are exhausted.
+	GraceDays int `json:"graceDays"`
+}
+
+// TrialConfig defines the trial the new accounts start at signup.
+type TrialConfig struct {
+	// Plan is the ID or name of the plan tried, the trial lasts its TrialDays.
+	// There's no trial when it is empty.
+	Plan string `json:"plan"`
+	// EndingDays is how many days before its end the trial ending soon email
+	// is sent, 3 by default.
+	EndingDays int `json:"endingDays"`
+}
+
+// CorsPolicy defines which cross-origin requests are allowed.
+//
+// Origins are matched exactly (https://app.example.com) or with a wildcard
+// subdomain (https://*.example.com). A single "*" allows any origin.
+type CorsPolicy struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowedMethods   []string `json:"allowedMethods"`
+	AllowedHeaders   []string `json:"allowedHeaders"`
+	ExposedHeaders   []string `json:"exposedHeaders"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	// MaxAge is the number of seconds a preflight response can be cached.
+	MaxAge int `json:"maxAge"`
+}
+
+// SecurityPolicy defines the security HTTP headers added to every response.
+//
+// Empty values fall back to safe defaults, HSTS is only sent when HSTSMaxAge
+// is set and the request was made over HTTPS.
+type SecurityPolicy struct {
+	HSTSMaxAge            int    `json:"hstsMaxAge"`
+	HSTSIncludeSubdomains bool   `json:"hstsIncludeSubdomains"`
+	HSTSPreload           bool   `json:"hstsPreload"`
+	ContentSecurityPolicy string `json:"contentSecurityPolicy"`
+	FrameOptions          string `json:"frameOptions"`
+	ReferrerPolicy        string `json:"referrerPolicy"`
+}
+
+// RateLimits defines the API quotas of an account. Zero values are not set
+// and fall back to the next level (route, plan, configuration, built-in).
+type RateLimits struct {
+	// DailyCalls is the number of calls allowed per 24 hours.
+	DailyCalls int64 `json:"dailyCalls"`
+	// PerMinute is the number of calls allowed per minute.
+	PerMinute int64 `json:"perMinute"`
+}
+
+// RateLimitHeaderStyle defines which HTTP headers report the rate limits.
+type RateLimitHeaderStyle string
+
+const (
+	// RateLimitHeadersIETF uses the IETF draft RateLimit-Limit, RateLimit-Remaining
+	// and RateLimit-Reset headers, the reset being in seconds.
+	RateLimitHeadersIETF RateLimitHeaderStyle = "ietf"
+	// RateLimitHeadersX uses the X-RateLimit-Limit, X-RateLimit-Remaining and
+	// X-RateLimit-Reset headers, the reset being a Unix timestamp.
+	RateLimitHeadersX RateLimitHeaderStyle = "x"
 )
 
 // Configuration defines important settings used across the library.
 type Configuration struct {
-	EmailLogin    string        `json:"emailLogin"`
-	EmailPassword string        `json:"emailPassword"`
-	EmailFrom     string        `json:"emailFrom"`
-	EmailFromName string        `json:"emailFromName"`
-	EmailProvider EmailProvider `json:"emailProvider"`
+	// AppName and AppURL are used in the emails, i.e. for the links.
+	AppName string `json:"appName"`
+	AppURL  string `json:"appURL"`
+	// SecretKey signs the unsubscribe links of the emails.
+	SecretKey string `json:"secretKey"`
 
-	StripeKey string             `json:"stripeKey"`
-	Plans     []data.BillingPlan `json:"plans"`
+	EmailLogin    string         `json:"emailLogin"`
+	EmailPassword string         `json:"emailPassword"`
+	EmailFrom     string         `json:"emailFrom"`
+	EmailFromName string         `json:"emailFromName"`
+	EmailProvider EmailProvider  `json:"emailProvider"`
+	SMTP          SMTPConfig     `json:"smtp"`
+	Postmark      PostmarkConfig `json:"postmark"`
+	Mailgun       MailgunConfig  `json:"mailgun"`
+	SendGrid      SendGridConfig `json:"sendgrid"`
+	SES           SESConfig      `json:"ses"`
+
+	StripeKey string `json:"stripeKey"`
+	// StripeWebhookSecret is the signing secret of the /stripe/webhooks
+	// endpoint, the events without a valid Stripe-Signature are refused.
+	StripeWebhookSecret string             `json:"stripeWebhookSecret"`
+	Plans               []data.BillingPlan `json:"plans"`
+	Dunning             DunningConfig      `json:"dunning"`
+	Trial               TrialConfig        `json:"trial"`
 
 	SignUpTemplate            string `json:"signupTemplate"`
 	SignUpSendEmailValidation bool   `json:"sendEmailValidation"`
@@ -37,6 +186,18 @@ type Configuration struct {
 	ForgotLoginTemplate       string `json:"forgotLoginTemplate"`
 	ResetLoginTemplate        string `json:"resetLoginTemplate"`
 	PwdChgSuccessRedirect     string `json:"pwdChgSuccessRedirect"`
+
+	Cors     CorsPolicy     `json:"cors"`
+	Security SecurityPolicy `json:"security"`
+
+	RateLimits       RateLimits           `json:"rateLimits"`
+	PublicRateLimits RateLimits           `json:"publicRateLimits"`
+	RateLimitHeaders RateLimitHeaderStyle `json:"rateLimitHeaders"`
+	// TrustedProxies are the IPs or CIDRs of the proxies allowed to set X-Forwarded-For.
+	TrustedProxies []string `json:"trustedProxies"`
+	// Operators are the IDs of the accounts operating the application, only
+	// their admins can use the operator tools, i.e. /tools/queue.
+	Operators []int64 `json:"operators"`
 }
 
 // Current holds the current configuration
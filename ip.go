@@ -0,0 +1,127 @@
+package gosaas
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// ClientIP returns the IP address of the client making the request.
+//
+// The X-Forwarded-For header is only used when the request comes from one of the
+// "trustedProxies" of the config file. It is read from right to left, the
+// first address that is not a trusted proxy is the client. This prevents clients
+// from spoofing their IP by sending their own X-Forwarded-For header.
+func ClientIP(r *http.Request) string {
+	remote := remoteIP(r)
+	trusted := currentTrustedProxies()
+	if !isTrustedProxy(trusted, remote) {
+		return remote
+	}
+
+	ip := remote
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		ip = hop
+		if !isTrustedProxy(trusted, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// isFromTrustedProxy returns if the request comes from one of the
+// "trustedProxies" of the config, their X-Forwarded-* headers can be used.
+func isFromTrustedProxy(r *http.Request) bool {
+	return isTrustedProxy(currentTrustedProxies(), remoteIP(r))
+}
+
+func remoteIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return remote
+}
+
+var (
+	trustedProxiesMu sync.RWMutex
+	trustedProxies   []string
+	trustedNets      []*net.IPNet
+)
+
+// currentTrustedProxies returns the parsed "trustedProxies" of the config, they
+// are parsed again only when the config changes.
+func currentTrustedProxies() []*net.IPNet {
+	proxies := config.Current.TrustedProxies
+
+	trustedProxiesMu.RLock()
+	same, nets := equalStrings(trustedProxies, proxies), trustedNets
+	trustedProxiesMu.RUnlock()
+	if same {
+		return nets
+	}
+
+	nets = parseTrustedProxies(proxies)
+
+	trustedProxiesMu.Lock()
+	defer trustedProxiesMu.Unlock()
+	trustedProxies = append([]string(nil), proxies...)
+	trustedNets = nets
+	return nets
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil {
+				bits := 32
+				if ip.To4() == nil {
+					bits = 128
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+func isTrustedProxy(trusted []*net.IPNet, s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package gosaas
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+func Test_IP_ClientIP(t *testing.T) {
+	config.Current.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.10"}
+	defer func() { config.Current.TrustedProxies = nil }()
+
+	tests := []struct {
+		remote string
+		xff    string
+		want   string
+	}{
+		{"203.0.113.5:1234", "", "203.0.113.5"},
+		// untrusted remote, the header is ignored
+		{"203.0.113.5:1234", "1.2.3.4", "203.0.113.5"},
+		{"10.0.0.2:1234", "198.51.100.7", "198.51.100.7"},
+		// spoofed left-most entry is ignored
+		{"10.0.0.2:1234", "1.2.3.4, 198.51.100.7, 192.168.1.10", "198.51.100.7"},
+		{"10.0.0.2:1234", "garbage", "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		if len(tt.xff) > 0 {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+
+		if ip := ClientIP(req); ip != tt.want {
+			t.Errorf("remote %s xff %s: expected %s got %s", tt.remote, tt.xff, tt.want, ip)
+		}
+	}
+}
+
+func Test_IP_TrustedProxiesParsedOnce(t *testing.T) {
+	config.Current.TrustedProxies = []string{"10.0.0.0/8"}
+	defer func() { config.Current.TrustedProxies = nil }()
+
+	nets := currentTrustedProxies()
+	if again := currentTrustedProxies(); len(again) != 1 || &again[0] != &nets[0] {
+		t.Error("expected the trusted proxies to be parsed once")
+	}
+
+	config.Current.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.10"}
+	if nets := currentTrustedProxies(); len(nets) != 2 {
+		t.Errorf("expected the changed trusted proxies to be parsed, got %v", nets)
+	}
+}
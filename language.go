@@ -2,9 +2,16 @@ package gosaas
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+
+	"github.com/jlb922/gosaas/queue/email"
 )
 
+func init() {
+	email.SetTranslator(translateEmail)
+}
+
 // Language is a middleware handling the language cookie named "lng".Language
 //
 // This is used in HTML templates and Go code when using the Translate
@@ -23,3 +30,20 @@ func Language(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// translateEmail translates the keys used in the email templates, falling back
+// to English when the recipient's language pack does not have the key.
+func translateEmail(lng, key string, a ...interface{}) string {
+	s, ok := languagePacks[lng][key]
+	if !ok {
+		s, ok = languagePacks["en"][key]
+	}
+	if !ok {
+		return key
+	}
+
+	if len(a) == 0 {
+		return s
+	}
+	return fmt.Sprintf(s, a...)
+}
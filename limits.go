@@ -0,0 +1,155 @@
+package gosaas
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jlb922/gosaas/cache"
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+)
+
+// RateLimits defines the daily and per minute API quotas.
+//
+// Quotas are resolved for each request in this order, the first non-zero
+// value wins:
+//
+// 1. The RateLimits of the Route.
+//
+// 2. The "dailyCalls" and "perMinute" params of the account's billing plan.
+//
+// 3. The "rateLimits" key of the gosaas.json config file.
+//
+// 4. The built-in defaults, 9999 calls per day and 60 per minute.
+//
+// Anonymous requests are limited per client IP. They use the route's RateLimits,
+// then the "publicRateLimits" key of the config file and 1000 calls per day and
+// 20 per minute by default.
+type RateLimits = config.RateLimits
+
+const (
+	defaultDailyCalls       = 9999
+	defaultPerMinute        = 60
+	defaultPublicDailyCalls = 1000
+	defaultPublicPerMinute  = 20
+)
+
+// resolveRateLimits returns the quotas for the request's route and account.
+func resolveRateLimits(ctx context.Context) RateLimits {
+	var limits RateLimits
+	if v, ok := ctx.Value(ContextRateLimits).(*RateLimits); ok && v != nil {
+		limits = *v
+	}
+
+	keys, ok := ctx.Value(ContextAuth).(Auth)
+	if !ok || keys.AccountID == 0 {
+		limits = mergeRateLimits(limits, config.Current.PublicRateLimits)
+		return mergeRateLimits(limits, RateLimits{DailyCalls: defaultPublicDailyCalls, PerMinute: defaultPublicPerMinute})
+	}
+
+	if limits.DailyCalls == 0 || limits.PerMinute == 0 {
+		limits = mergeRateLimits(limits, planRateLimits(keys.AccountID))
+	}
+
+	limits = mergeRateLimits(limits, config.Current.RateLimits)
+	return mergeRateLimits(limits, RateLimits{DailyCalls: defaultDailyCalls, PerMinute: defaultPerMinute})
+}
+
+// rateLimitKey returns what the calls are counted against, the account for
+// authenticated requests and the client IP for anonymous ones.
+func rateLimitKey(r *http.Request) string {
+	if keys, ok := r.Context().Value(ContextAuth).(Auth); ok && keys.AccountID > 0 {
+		return fmt.Sprintf("%d", keys.AccountID)
+	}
+	return "ip_" + ClientIP(r)
+}
+
+// planRateLimits returns the quotas set in the params of the account's current plan.
+func planRateLimits(accountID int64) RateLimits {
+	var limits RateLimits
+
+	name, err := cache.GetAccountPlan(accountID)
+	if err != nil {
+		log.Println("unable to get the account plan from cache", err)
+		return limits
+	} else if len(name) == 0 {
+		return limits
+	}
+
+	plan, ok := data.FindPlan(name)
+	if !ok {
+		return limits
+	}
+
+	if v, ok := plan.IntParam("dailyCalls"); ok {
+		limits.DailyCalls = v
+	}
+	if v, ok := plan.IntParam("perMinute"); ok {
+		limits.PerMinute = v
+	}
+	return limits
+}
+
+// mergeRateLimits fills the zero values of limits with the fallback ones.
+func mergeRateLimits(limits, fallback RateLimits) RateLimits {
+	if limits.DailyCalls == 0 {
+		limits.DailyCalls = fallback.DailyCalls
+	}
+	if limits.PerMinute == 0 {
+		limits.PerMinute = fallback.PerMinute
+	}
+	return limits
+}
+
+// activePlan returns the plan the account is entitled to, the trial plan
+// while a trial is active.
+func activePlan(acct *model.Account) string {
+	if acct.TrialInfo.IsActive(time.Now()) {
+		return acct.TrialInfo.Plan
+	}
+	return acct.Plan
+}
+
+// cacheAccountPlan refreshes the plan used to resolve the account quotas
+// so plan changes apply immediately.
+func cacheAccountPlan(accountID int64, plan string) {
+	if err := cache.SetAccountPlan(accountID, plan); err != nil {
+		log.Println("unable to cache the account plan", accountID, err)
+	}
+}
+
+// setRateLimitHeaders reports the rate limit state to the client. When both the
+// Throttler and the RateLimiter apply, the most restrictive one is reported.
+func setRateLimitHeaders(w http.ResponseWriter, res *cache.LimitResult) {
+	prefix := "RateLimit-"
+	if config.Current.RateLimitHeaders == config.RateLimitHeadersX {
+		prefix = "X-RateLimit-"
+	}
+
+	headers := w.Header()
+	if v := headers.Get(prefix + "Remaining"); len(v) > 0 {
+		if remaining, err := strconv.ParseInt(v, 10, 64); err == nil && remaining <= res.Remaining {
+			return
+		}
+	}
+
+	reset := ceilSeconds(res.Reset)
+	if prefix == "X-RateLimit-" {
+		reset = time.Now().Add(res.Reset).Unix()
+	}
+
+	headers.Set(prefix+"Limit", strconv.FormatInt(res.Limit, 10))
+	headers.Set(prefix+"Remaining", strconv.FormatInt(res.Remaining, 10))
+	headers.Set(prefix+"Reset", strconv.FormatInt(reset, 10))
+}
+
+// ceilSeconds rounds a duration up to the second so clients never retry too early.
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,100 @@
+package gosaas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jlb922/gosaas/cache"
+)
+
+func Test_Limits_RouteOverride(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ContextRateLimits, &RateLimits{PerMinute: 5})
+
+	limits := resolveRateLimits(ctx)
+	if limits.PerMinute != 5 {
+		t.Errorf("expected the route override of 5 per minute got %d", limits.PerMinute)
+	} else if limits.DailyCalls == 0 {
+		t.Error("expected the daily calls to fall back to a default")
+	}
+}
+
+func Test_Limits_MergeFallback(t *testing.T) {
+	limits := mergeRateLimits(RateLimits{DailyCalls: 100}, RateLimits{DailyCalls: 1, PerMinute: 2})
+	if limits.DailyCalls != 100 {
+		t.Errorf("expected daily calls to be kept at 100 got %d", limits.DailyCalls)
+	} else if limits.PerMinute != 2 {
+		t.Errorf("expected per minute to fall back to 2 got %d", limits.PerMinute)
+	}
+}
+
+func Test_Limits_HeadersReportMostRestrictive(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	setRateLimitHeaders(rec, &cache.LimitResult{Limit: 9999, Remaining: 9000, Reset: 2 * time.Hour})
+	setRateLimitHeaders(rec, &cache.LimitResult{Limit: 60, Remaining: 10, Reset: 1500 * time.Millisecond})
+
+	if v := rec.Header().Get("RateLimit-Limit"); v != "60" {
+		t.Errorf("expected limit 60 got %s", v)
+	} else if v := rec.Header().Get("RateLimit-Remaining"); v != "10" {
+		t.Errorf("expected remaining 10 got %s", v)
+	} else if v := rec.Header().Get("RateLimit-Reset"); v != "2" {
+		t.Errorf("expected reset 2 got %s", v)
+	}
+}
+
+func Test_RateLimiter_TooManyRequests(t *testing.T) {
+	h := RateLimiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	ctx := context.WithValue(context.Background(), ContextRateLimits, &RateLimits{PerMinute: 1})
+	ctx = context.WithValue(ctx, ContextAuth, Auth{AccountID: time.Now().UnixNano()})
+
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/test", nil)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req.WithContext(ctx))
+	}
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d got %d", http.StatusTooManyRequests, rec.Code)
+	} else if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	} else if rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("expected 0 remaining got %s", rec.Header().Get("RateLimit-Remaining"))
+	} else if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a JSON error got %s", rec.Header().Get("Content-Type"))
+	}
+}
+
+func Test_RateLimiter_AnonymousKeyedByIP(t *testing.T) {
+	h := RateLimiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	ctx := context.WithValue(context.Background(), ContextRateLimits, &RateLimits{PerMinute: 1})
+	ip := fmt.Sprintf("198.51.100.%d", time.Now().UnixNano()%250)
+
+	send := func(remote string) int {
+		req := httptest.NewRequest("POST", "/users/forgot", nil)
+		req.RemoteAddr = remote + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req.WithContext(ctx))
+		return rec.Code
+	}
+
+	if code := send(ip); code != http.StatusOK {
+		t.Fatalf("expected status %d got %d", http.StatusOK, code)
+	} else if code := send(ip); code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d for the same IP got %d", http.StatusTooManyRequests, code)
+	}
+
+	if code := send("2001:db8::" + fmt.Sprintf("%x", time.Now().UnixNano()%65535)); code != http.StatusOK {
+		t.Errorf("expected status %d for another IP got %d", http.StatusOK, code)
+	}
+}
@@ -0,0 +1,25 @@
+CREATE TABLE gosaas_queue_tasks(
+	id BIGINT PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+	uuid TEXT UNIQUE,
+	task_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	run_at TIMESTAMPTZ NOT NULL,
+	created TIMESTAMPTZ NOT NULL,
+	updated TIMESTAMPTZ NOT NULL,
+	started TIMESTAMPTZ,
+	finished TIMESTAMPTZ,
+	reserved_by TEXT,
+	reserved_until TIMESTAMPTZ
+);
+
+CREATE INDEX gosaas_queue_tasks_due ON gosaas_queue_tasks(run_at, id) WHERE status IN ('queued', 'failed');
+CREATE INDEX gosaas_queue_tasks_status ON gosaas_queue_tasks(status, created);
+
+CREATE TABLE gosaas_queue_rates(
+	key TEXT PRIMARY KEY,
+	count BIGINT NOT NULL,
+	expires TIMESTAMPTZ NOT NULL
+);
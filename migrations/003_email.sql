@@ -0,0 +1,23 @@
+CREATE TABLE gosaas_emails(
+	id BIGINT PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+	task_id TEXT UNIQUE NOT NULL,
+	recipient TEXT NOT NULL,
+	template TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	provider TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	created TIMESTAMP NOT NULL,
+	updated TIMESTAMP NOT NULL
+);
+
+CREATE INDEX gosaas_emails_message_idx ON gosaas_emails(provider, message_id);
+CREATE INDEX gosaas_emails_recipient_idx ON gosaas_emails(recipient, created);
+
+-- addresses that hard bounced or complained, they do not receive emails anymore
+CREATE TABLE gosaas_email_suppressions(
+	email TEXT PRIMARY KEY,
+	reason TEXT NOT NULL,
+	created TIMESTAMP NOT NULL
+);
@@ -0,0 +1,9 @@
+-- the categories of emails the users unsubscribed from or subscribed back to,
+-- they are subscribed to all categories without row
+CREATE TABLE gosaas_email_preferences(
+	user_id INTEGER REFERENCES gosaas_users(id) ON DELETE CASCADE,
+	category TEXT NOT NULL,
+	subscribed BOOL NOT NULL,
+	updated TIMESTAMP NOT NULL,
+	PRIMARY KEY(user_id, category)
+);
@@ -0,0 +1,6 @@
+-- the Stripe webhook events already processed, a replayed event is ignored
+CREATE TABLE gosaas_billing_events(
+	id TEXT PRIMARY KEY,
+	type TEXT NOT NULL,
+	received TIMESTAMP NOT NULL
+);
@@ -0,0 +1,3 @@
+-- the accounts whose payment failed are past due until a payment succeeds,
+-- the dunning reminders are sent from that date
+ALTER TABLE gosaas_accounts ADD COLUMN past_due_since TIMESTAMP NULL;
@@ -0,0 +1,10 @@
+-- the trial of the accounts, trial_end includes the days it was extended by
+ALTER TABLE gosaas_accounts
+	ADD COLUMN is_trial BOOL NOT NULL DEFAULT false,
+	ADD COLUMN trial_plan TEXT NOT NULL DEFAULT '',
+	ADD COLUMN trial_start TIMESTAMP NULL,
+	ADD COLUMN trial_end TIMESTAMP NULL,
+	ADD COLUMN trial_extended INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN trial_reminded BOOL NOT NULL DEFAULT false;
+
+CREATE INDEX gosaas_accounts_trial_end_idx ON gosaas_accounts(trial_end) WHERE is_trial;
@@ -16,6 +16,9 @@ type Account struct {
 	Seats          int       ` json:"seats"`
 	TrialInfo      Trial     ` json:"trial"`
 	IsActive       bool      ` json:"active"`
+	// PastDueSince is when the last payment failed, it is zero once a payment
+	// succeeds.
+	PastDueSince time.Time `json:"pastDueSince"`
 
 	Users []User ` json:"users"`
 }
@@ -25,12 +28,26 @@ func (a *Account) IsPaid() bool {
 	return len(a.StripeID) > 0 && len(a.SubscriptionID) > 0
 }
 
-// Trial represents the trial information for an account.
+// IsPastDue returns if the last payment of this account failed.
+func (a *Account) IsPastDue() bool {
+	return !a.PastDueSince.IsZero()
+}
+
+// Trial represents the trial information for an account. Extended is the
+// number of days the trial was extended by, they are included in End.
+// Reminded is set once the trial ending soon email is sent.
 type Trial struct {
 	IsTrial  bool      ` json:"trial"`
 	Plan     string    ` json:"plan"`
 	Start    time.Time ` json:"start"`
 	Extended int       ` json:"extended"`
+	End      time.Time `json:"end"`
+	Reminded bool      `json:"reminded"`
+}
+
+// IsActive returns if the account is entitled to the trial plan at t.
+func (tr Trial) IsActive(t time.Time) bool {
+	return tr.IsTrial && len(tr.Plan) > 0 && t.Before(tr.End)
 }
 
 // Roles are used with user access control and authorization. You may add custom roles in-between the
@@ -89,3 +106,57 @@ type Webhook struct {
 	IsActive  bool      `json:"active"`
 	Created   time.Time `json:"created"`
 }
+
+// EmailStatus is the delivery status of an email in the outbox.
+type EmailStatus string
+
+const (
+	// EmailSent is an email accepted by the provider.
+	EmailSent EmailStatus = "sent"
+	// EmailDelivered is an email accepted by the recipient's server.
+	EmailDelivered EmailStatus = "delivered"
+	// EmailBounced is an email permanently rejected by the recipient's server.
+	EmailBounced EmailStatus = "bounced"
+	// EmailComplained is an email the recipient marked as spam.
+	EmailComplained EmailStatus = "complained"
+	// EmailFailed is an email the provider refused.
+	EmailFailed EmailStatus = "failed"
+	// EmailSuppressed is an email not sent since its recipient is suppressed.
+	EmailSuppressed EmailStatus = "suppressed"
+)
+
+// Email represents an email in the outbox.
+//
+// TaskID is the UUID of the queued task sending the email, MessageID the ID
+// the provider assigned to it which its delivery events refer to.
+type Email struct {
+	ID        int64       `json:"id"`
+	TaskID    string      `json:"taskId"`
+	To        string      `json:"to"`
+	Template  string      `json:"template"`
+	Subject   string      `json:"subject"`
+	Provider  string      `json:"provider"`
+	MessageID string      `json:"messageId"`
+	Status    EmailStatus `json:"status"`
+	Reason    string      `json:"reason"`
+	Created   time.Time   `json:"created"`
+	Updated   time.Time   `json:"updated"`
+}
+
+// EmailCategory groups the emails, users can unsubscribe from every category
+// but the transactional emails (account, security and receipts).
+type EmailCategory string
+
+const (
+	// EmailTransactional are the emails users cannot unsubscribe from.
+	EmailTransactional EmailCategory = "transactional"
+	// EmailProduct are the product updates and tips.
+	EmailProduct EmailCategory = "product"
+	// EmailBilling are the billing reminders and notices.
+	EmailBilling EmailCategory = "billing"
+	// EmailMarketing are the newsletters and promotions.
+	EmailMarketing EmailCategory = "marketing"
+)
+
+// EmailCategories are the categories users can unsubscribe from.
+var EmailCategories = []EmailCategory{EmailProduct, EmailBilling, EmailMarketing}
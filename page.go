@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jlb922/gosaas/model"
@@ -21,6 +22,12 @@ import (
 var (
 	pageTemplates *template.Template
 	languagePacks map[string]map[string]string
+
+	// the templates are parsed once more for every server's Static so their
+	// asset function uses it, templatesVersion changes when they are reloaded.
+	templatesMu      sync.RWMutex
+	templateFiles    []string
+	templatesVersion int
 )
 
 func init() {
@@ -63,20 +70,73 @@ func LoadTemplates() {
 	//	}
 	//}
 
-	t, err := template.New("").Funcs(template.FuncMap{
+	t, err := parseTemplates(tmpl, AssetURL)
+	if err != nil {
+		log.Fatal("error while parsing templates", err)
+	}
+
+	pageTemplates = t
+
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	templateFiles = tmpl
+	templatesVersion++
+}
+
+func parseTemplates(tmpl []string, asset func(string) string) (*template.Template, error) {
+	return template.New("").Funcs(template.FuncMap{
 		"translate":  Translate,
 		"translatef": Translatef,
+		"asset":      asset,
 		"money": func(amount int) string {
 			m := float64(amount) / 100.0
 			return fmt.Sprintf("%.2f $", m)
 		},
 		//	}).ParseFiles(tmpl...)
 	}).ParseFiles(tmpl...)
-	if err != nil {
-		log.Fatal("error while parsing templates", err)
+}
+
+// requestTemplates returns the templates whose asset function uses the Static
+// of the server handling the request.
+func requestTemplates(r *http.Request) *template.Template {
+	static, ok := r.Context().Value(ContextStatic).(*Static)
+	if !ok || static == nil {
+		return pageTemplates
 	}
+	return static.templates()
+}
 
-	pageTemplates = t
+// templates returns the page templates using s for their asset function, they
+// are parsed on the first page served with s and after LoadTemplates.
+func (s *Static) templates() *template.Template {
+	templatesMu.RLock()
+	files, version := templateFiles, templatesVersion
+	templatesMu.RUnlock()
+	if len(files) == 0 {
+		return pageTemplates
+	}
+
+	s.tmplMu.RLock()
+	t, v := s.tmpl, s.tmplVersion
+	s.tmplMu.RUnlock()
+	if t != nil && v == version {
+		return t
+	}
+
+	s.tmplMu.Lock()
+	defer s.tmplMu.Unlock()
+	if s.tmpl != nil && s.tmplVersion == version {
+		return s.tmpl
+	}
+
+	t, err := parseTemplates(files, s.URL)
+	if err != nil {
+		// the error is not logged again until the templates are reloaded
+		log.Println("error while parsing templates", err)
+		t = pageTemplates
+	}
+	s.tmpl, s.tmplVersion = t, version
+	return t
 }
 
 // ServePage will render and respond with an HTML template.ServePage
@@ -91,7 +151,7 @@ func LoadTemplates() {
 // 	}
 func ServePage(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
 
-	t := pageTemplates.Lookup(name)
+	t := requestTemplates(r).Lookup(name)
 
 	if err := t.Execute(w, data); err != nil {
 		fmt.Println("error while rendering the template ", err)
@@ -188,14 +248,19 @@ func ExtractLimitAndOffset(r *http.Request) (limit int, offset int) {
 
 // ViewData is the base data needed for all pages to render.
 //
-// It will automatically get the user's language, role and if there's an alert
+// It will automatically get the user's language, role, CSRF token and if there's an alert
 // to display. You can view this a a wrapper around what you would have sent to the
 // page being redered.
+//
+// Billing is set when the account is past due so the pages can show a banner
+// asking to update the payment details.
 type ViewData struct {
-	Language string
-	Role     model.Roles
-	Alert    *Notification
-	Data     interface{}
+	Language  string
+	Role      model.Roles
+	Alert     *Notification
+	CSRFToken string
+	Billing   *BillingBanner
+	Data      interface{}
 }
 
 // Notification can be used to display alert to the user in an HTML template.
@@ -218,6 +283,11 @@ func getLanguage(ctx context.Context) string {
 	return lng
 }
 
+func getCSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(ContextCSRFToken).(string)
+	return token
+}
+
 func getRole(ctx context.Context) model.Roles {
 	auth, ok := ctx.Value(ContextAuth).(Auth)
 	if !ok {
@@ -230,10 +300,12 @@ func getRole(ctx context.Context) model.Roles {
 // notification will be automatically added along side the data.
 func CreateViewData(ctx context.Context, alert *Notification, data interface{}) ViewData {
 	return ViewData{
-		Alert:    alert,
-		Data:     data,
-		Language: getLanguage(ctx),
-		Role:     getRole(ctx),
+		Alert:     alert,
+		Data:      data,
+		Language:  getLanguage(ctx),
+		Role:      getRole(ctx),
+		CSRFToken: getCSRFToken(ctx),
+		Billing:   getBillingBanner(ctx),
 	}
 }
 
@@ -0,0 +1,434 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory Provider for the tests. The subscriptions are billed
+// monthly at the price of their plan per seat, the plan and quantity changes
+// are prorated like Stripe does and billed on the next invoice.
+type Fake struct {
+	// Prices are the monthly prices per seat of the plans, in cents.
+	Prices map[string]int64
+	// Err is returned by all the calls when set, i.e. a declined card.
+	Err error
+	// Now returns the current time, time.Now by default.
+	Now func() time.Time
+
+	mu            sync.Mutex
+	seq           int
+	customers     map[string]*Customer
+	subscriptions map[string]*fakeSubscription
+	cards         map[string][]Card
+	invoices      map[string][]Invoice
+	pending       map[string][]InvoiceLine
+}
+
+type fakeSubscription struct {
+	Subscription
+	periodStart time.Time
+}
+
+// NewFake returns an empty Fake provider.
+func NewFake() *Fake {
+	return &Fake{
+		Prices:        make(map[string]int64),
+		customers:     make(map[string]*Customer),
+		subscriptions: make(map[string]*fakeSubscription),
+		cards:         make(map[string][]Card),
+		invoices:      make(map[string][]Invoice),
+		pending:       make(map[string][]InvoiceLine),
+	}
+}
+
+func (f *Fake) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+func (f *Fake) id(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s_%d", prefix, f.seq)
+}
+
+// CreateCustomer creates a customer, a Visa card ending in 4242 is added when
+// the source is not empty.
+func (f *Fake) CreateCustomer(email, source string) (*Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	c := &Customer{ID: f.id("cus"), Email: email}
+	f.customers[c.ID] = c
+	if len(source) > 0 {
+		f.cards[c.ID] = append(f.cards[c.ID], Card{ID: f.id("card"), Last4: "4242", Brand: "Visa", ExpMonth: 12, ExpYear: f.now().Year() + 1})
+	}
+
+	cus := *c
+	return &cus, nil
+}
+
+// GetCustomer returns the customer.
+func (f *Fake) GetCustomer(id string) (*Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	c, ok := f.customers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	cus := *c
+	return &cus, nil
+}
+
+// CreateSubscription subscribes the customer to the plan, the subscription is
+// trialing during the TrialDays.
+func (f *Fake) CreateSubscription(p SubscriptionParams) (*Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	if _, ok := f.customers[p.CustomerID]; !ok {
+		return nil, ErrNotFound
+	}
+	if _, ok := f.Prices[p.Plan]; !ok {
+		return nil, fmt.Errorf("no such plan: %s", p.Plan)
+	}
+
+	now := f.now()
+	s := &fakeSubscription{
+		Subscription: Subscription{
+			ID:               f.id("sub"),
+			CustomerID:       p.CustomerID,
+			Plan:             p.Plan,
+			Quantity:         p.Quantity,
+			Status:           "active",
+			CurrentPeriodEnd: now.AddDate(0, 1, 0),
+		},
+		periodStart: now,
+	}
+	if p.TrialDays > 0 {
+		s.Status = "trialing"
+		s.TrialEnd = now.AddDate(0, 0, p.TrialDays)
+		s.CurrentPeriodEnd = s.TrialEnd
+	}
+	f.subscriptions[s.ID] = s
+
+	sub := s.Subscription
+	return &sub, nil
+}
+
+// UpdateSubscription changes the plan and the quantity, the prorations are
+// billed on the next invoice.
+func (f *Fake) UpdateSubscription(id string, p SubscriptionParams) (*Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	s, ok := f.subscriptions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	lines, err := f.prorate(s, p)
+	if err != nil {
+		return nil, err
+	}
+	f.pending[s.CustomerID] = append(f.pending[s.CustomerID], lines...)
+
+	if len(p.Plan) > 0 {
+		s.Plan = p.Plan
+	}
+	s.Quantity = p.Quantity
+
+	sub := s.Subscription
+	return &sub, nil
+}
+
+// prorate returns the credit for the unused time of the current plan and the
+// charge for the remaining time of the new one, there is nothing to prorate
+// during the trial.
+func (f *Fake) prorate(s *fakeSubscription, p SubscriptionParams) ([]InvoiceLine, error) {
+	plan := p.Plan
+	if len(plan) == 0 {
+		plan = s.Plan
+	}
+	price, ok := f.Prices[plan]
+	if !ok {
+		return nil, fmt.Errorf("no such plan: %s", plan)
+	}
+
+	if s.Status == "trialing" || (plan == s.Plan && p.Quantity == s.Quantity) {
+		return nil, nil
+	}
+
+	period := s.CurrentPeriodEnd.Sub(s.periodStart)
+	left := float64(s.CurrentPeriodEnd.Sub(f.now())) / float64(period)
+	if left <= 0 {
+		return nil, nil
+	}
+
+	return []InvoiceLine{
+		{
+			Description: "Unused time on " + s.Plan,
+			Plan:        s.Plan,
+			Quantity:    s.Quantity,
+			Amount:      -int64(float64(f.Prices[s.Plan]*int64(s.Quantity)) * left),
+			Proration:   true,
+		},
+		{
+			Description: "Remaining time on " + plan,
+			Plan:        plan,
+			Quantity:    p.Quantity,
+			Amount:      int64(float64(price*int64(p.Quantity)) * left),
+			Proration:   true,
+		},
+	}, nil
+}
+
+// CancelSubscription cancels the subscription immediately.
+func (f *Fake) CancelSubscription(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+
+	s, ok := f.subscriptions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.Status = "canceled"
+	return nil
+}
+
+// Cards returns the customer's cards.
+func (f *Fake) Cards(customerID string) ([]Card, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	if _, ok := f.customers[customerID]; !ok {
+		return nil, ErrNotFound
+	}
+	return append(make([]Card, 0), f.cards[customerID]...), nil
+}
+
+// AddCard adds the card to the customer.
+func (f *Fake) AddCard(customerID string, p CardParams) (*Card, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	if _, ok := f.customers[customerID]; !ok {
+		return nil, ErrNotFound
+	}
+	if len(p.Number) < 4 {
+		return nil, errors.New("invalid card number")
+	}
+
+	c := Card{ID: f.id("card"), Name: p.Name, Last4: p.Number[len(p.Number)-4:], Brand: "Visa"}
+	fmt.Sscan(p.ExpMonth, &c.ExpMonth)
+	fmt.Sscan(p.ExpYear, &c.ExpYear)
+	f.cards[customerID] = append(f.cards[customerID], c)
+	return &c, nil
+}
+
+// UpdateCard changes the card's name and expiration.
+func (f *Fake) UpdateCard(customerID, cardID string, p CardParams) (*Card, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	for i, c := range f.cards[customerID] {
+		if c.ID != cardID {
+			continue
+		}
+
+		if len(p.Name) > 0 {
+			c.Name = p.Name
+		}
+		fmt.Sscan(p.ExpMonth, &c.ExpMonth)
+		fmt.Sscan(p.ExpYear, &c.ExpYear)
+		f.cards[customerID][i] = c
+		return &c, nil
+	}
+	return nil, ErrNotFound
+}
+
+// DeleteCard removes the card from the customer.
+func (f *Fake) DeleteCard(customerID, cardID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+
+	cards := f.cards[customerID]
+	for i, c := range cards {
+		if c.ID == cardID {
+			f.cards[customerID] = append(cards[:i:i], cards[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Invoices returns the customer's invoices, the most recent first.
+func (f *Fake) Invoices(customerID string) ([]Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	if _, ok := f.customers[customerID]; !ok {
+		return nil, ErrNotFound
+	}
+	return append(make([]Invoice, 0), f.invoices[customerID]...), nil
+}
+
+// NextInvoice returns the pending items and the next period of the
+// customer's subscription.
+func (f *Fake) NextInvoice(customerID string) (*Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	s := f.subscriptionOf(customerID)
+	if s == nil {
+		return nil, ErrNotFound
+	}
+	return f.upcoming(s, f.pending[customerID]), nil
+}
+
+// CreateInvoice bills the customer's pending items, they are paid
+// immediately.
+func (f *Fake) CreateInvoice(customerID string) (*Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	if _, ok := f.customers[customerID]; !ok {
+		return nil, ErrNotFound
+	}
+
+	lines := f.pending[customerID]
+	if len(lines) == 0 {
+		return nil, errors.New("nothing to invoice for customer")
+	}
+	delete(f.pending, customerID)
+
+	now := f.now()
+	i := Invoice{
+		ID:          f.id("in"),
+		CustomerID:  customerID,
+		Number:      fmt.Sprintf("FAKE-%04d", len(f.invoices[customerID])+1),
+		Status:      "paid",
+		Currency:    "usd",
+		Paid:        true,
+		Created:     now,
+		PeriodStart: now,
+		PeriodEnd:   now,
+		Lines:       lines,
+	}
+	if s := f.subscriptionOf(customerID); s != nil {
+		i.SubscriptionID = s.ID
+	}
+	for _, l := range lines {
+		i.Total += l.Amount
+	}
+	i.AmountDue, i.AmountPaid = i.Total, i.Total
+
+	f.invoices[customerID] = append([]Invoice{i}, f.invoices[customerID]...)
+	return &i, nil
+}
+
+// PreviewProration returns the next invoice as if the subscription was
+// updated with p now, the subscription is not changed.
+func (f *Fake) PreviewProration(subscriptionID string, p SubscriptionParams) (*Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	s, ok := f.subscriptions[subscriptionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	lines, err := f.prorate(s, p)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := *s
+	if len(p.Plan) > 0 {
+		preview.Plan = p.Plan
+	}
+	preview.Quantity = p.Quantity
+
+	pending := append(append([]InvoiceLine{}, f.pending[s.CustomerID]...), lines...)
+	return f.upcoming(&preview, pending), nil
+}
+
+// subscriptionOf returns the customer's subscription that is not canceled.
+func (f *Fake) subscriptionOf(customerID string) *fakeSubscription {
+	for _, s := range f.subscriptions {
+		if s.CustomerID == customerID && s.Status != "canceled" {
+			return s
+		}
+	}
+	return nil
+}
+
+func (f *Fake) upcoming(s *fakeSubscription, pending []InvoiceLine) *Invoice {
+	i := &Invoice{
+		CustomerID:     s.CustomerID,
+		SubscriptionID: s.ID,
+		Status:         "draft",
+		Currency:       "usd",
+		Created:        s.CurrentPeriodEnd,
+		PeriodStart:    s.CurrentPeriodEnd,
+		PeriodEnd:      s.CurrentPeriodEnd.AddDate(0, 1, 0),
+		Lines:          append([]InvoiceLine{}, pending...),
+	}
+	i.Lines = append(i.Lines, InvoiceLine{
+		Description: fmt.Sprintf("%d × %s", s.Quantity, s.Plan),
+		Plan:        s.Plan,
+		Quantity:    s.Quantity,
+		Amount:      f.Prices[s.Plan] * int64(s.Quantity),
+	})
+
+	for _, l := range i.Lines {
+		i.Total += l.Amount
+	}
+	i.AmountDue = i.Total
+	return i
+}
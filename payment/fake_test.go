@@ -0,0 +1,119 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFake_Subscription(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake()
+	f.Now = func() time.Time { return now }
+	f.Prices["starter"] = 1000
+	f.Prices["pro"] = 3000
+
+	c, err := f.CreateCustomer("a@test.com", "tok_visa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cards, _ := f.Cards(c.ID); len(cards) != 1 || cards[0].Last4 != "4242" {
+		t.Errorf("expected the source card, got %v", cards)
+	}
+
+	s, err := f.CreateSubscription(SubscriptionParams{CustomerID: c.ID, Plan: "starter", Quantity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := f.NextInvoice(c.ID); next.Total != 2000 {
+		t.Errorf("expected the next invoice to be 2000, got %d", next.Total)
+	}
+
+	// half the period left
+	now = now.Add(s.CurrentPeriodEnd.Sub(now) / 2)
+
+	preview, err := f.PreviewProration(s.ID, SubscriptionParams{CustomerID: c.ID, Plan: "pro", Quantity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// -1000 unused starter, +3000 remaining pro, 6000 next period
+	if preview.Total != 8000 {
+		t.Errorf("expected the preview to be 8000, got %d %v", preview.Total, preview.Lines)
+	}
+	if _, err := f.CreateInvoice(c.ID); err == nil {
+		t.Error("the preview should not create pending items")
+	}
+
+	if _, err := f.UpdateSubscription(s.ID, SubscriptionParams{Plan: "pro", Quantity: 2}); err != nil {
+		t.Fatal(err)
+	}
+	i, err := f.CreateInvoice(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if i.Total != 2000 || !i.Paid || len(i.Lines) != 2 {
+		t.Errorf("expected a paid invoice of 2000, got %v", i)
+	}
+	if list, _ := f.Invoices(c.ID); len(list) != 1 || list[0].ID != i.ID {
+		t.Errorf("unexpected invoices %v", list)
+	}
+
+	if err := f.CancelSubscription(s.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NextInvoice(c.ID); err != ErrNotFound {
+		t.Errorf("expected no next invoice once canceled, got %v", err)
+	}
+}
+
+func TestFake_Trial(t *testing.T) {
+	f := NewFake()
+	f.Prices["pro"] = 3000
+
+	c, _ := f.CreateCustomer("a@test.com", "")
+	s, err := f.CreateSubscription(SubscriptionParams{CustomerID: c.ID, Plan: "pro", Quantity: 1, TrialDays: 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != "trialing" || s.TrialEnd.IsZero() || !s.CurrentPeriodEnd.Equal(s.TrialEnd) {
+		t.Errorf("unexpected trial subscription %v", s)
+	}
+
+	// nothing is prorated during the trial
+	if _, err := f.UpdateSubscription(s.ID, SubscriptionParams{Quantity: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := f.NextInvoice(c.ID); next.Total != 9000 || len(next.Lines) != 1 {
+		t.Errorf("unexpected next invoice %v", next)
+	}
+}
+
+func TestFake_Cards(t *testing.T) {
+	f := NewFake()
+	c, _ := f.CreateCustomer("a@test.com", "")
+
+	card, err := f.AddCard(c.ID, CardParams{Name: "A", Number: "4000056655665556", ExpMonth: "4", ExpYear: "2030"})
+	if err != nil {
+		t.Fatal(err)
+	} else if card.Last4 != "5556" || card.ExpMonth != 4 || card.ExpYear != 2030 {
+		t.Errorf("unexpected card %v", card)
+	}
+
+	if card, err = f.UpdateCard(c.ID, card.ID, CardParams{ExpMonth: "5", ExpYear: "2031"}); err != nil {
+		t.Fatal(err)
+	} else if card.Name != "A" || card.ExpMonth != 5 || card.ExpYear != 2031 {
+		t.Errorf("unexpected updated card %v", card)
+	}
+
+	if err := f.DeleteCard(c.ID, card.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.DeleteCard(c.ID, card.ID); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	declined := errors.New("card declined")
+	f.Err = declined
+	if _, err := f.AddCard(c.ID, CardParams{Number: "4000000000000002"}); err != declined {
+		t.Errorf("expected the card to be declined, got %v", err)
+	}
+}
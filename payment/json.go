@@ -0,0 +1,126 @@
+package payment
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// stripeID is a Stripe object reference, i.e. the invoice's customer.
+type stripeID struct {
+	ID string `json:"id"`
+}
+
+// stripeInvoice is the JSON format of a Stripe invoice, the times are Unix
+// timestamps.
+type stripeInvoice struct {
+	ID               string    `json:"id"`
+	Customer         *stripeID `json:"customer"`
+	Subscription     string    `json:"subscription"`
+	Number           string    `json:"number"`
+	Status           string    `json:"status"`
+	Currency         string    `json:"currency"`
+	Total            int64     `json:"total"`
+	AmountDue        int64     `json:"amount_due"`
+	AmountPaid       int64     `json:"amount_paid"`
+	Paid             bool      `json:"paid"`
+	Created          int64     `json:"created"`
+	PeriodStart      int64     `json:"period_start"`
+	PeriodEnd        int64     `json:"period_end"`
+	HostedInvoiceURL string    `json:"hosted_invoice_url"`
+	Lines            struct {
+		Data []stripeInvoiceLine `json:"data"`
+	} `json:"lines"`
+}
+
+type stripeInvoiceLine struct {
+	Description string    `json:"description"`
+	Plan        *stripeID `json:"plan"`
+	Quantity    int64     `json:"quantity"`
+	Amount      int64     `json:"amount"`
+	Proration   bool      `json:"proration"`
+}
+
+// MarshalJSON encodes the invoice like a Stripe invoice.
+func (i Invoice) MarshalJSON() ([]byte, error) {
+	si := stripeInvoice{
+		ID:               i.ID,
+		Subscription:     i.SubscriptionID,
+		Number:           i.Number,
+		Status:           i.Status,
+		Currency:         i.Currency,
+		Total:            i.Total,
+		AmountDue:        i.AmountDue,
+		AmountPaid:       i.AmountPaid,
+		Paid:             i.Paid,
+		Created:          timestamp(i.Created),
+		PeriodStart:      timestamp(i.PeriodStart),
+		PeriodEnd:        timestamp(i.PeriodEnd),
+		HostedInvoiceURL: i.URL,
+	}
+	if len(i.CustomerID) > 0 {
+		si.Customer = &stripeID{ID: i.CustomerID}
+	}
+
+	si.Lines.Data = make([]stripeInvoiceLine, 0, len(i.Lines))
+	for _, l := range i.Lines {
+		line := stripeInvoiceLine{
+			Description: l.Description,
+			Quantity:    int64(l.Quantity),
+			Amount:      l.Amount,
+			Proration:   l.Proration,
+		}
+		if len(l.Plan) > 0 {
+			line.Plan = &stripeID{ID: l.Plan}
+		}
+		si.Lines.Data = append(si.Lines.Data, line)
+	}
+	return json.Marshal(si)
+}
+
+// UnmarshalJSON decodes an invoice encoded like a Stripe invoice.
+func (i *Invoice) UnmarshalJSON(b []byte) error {
+	var si stripeInvoice
+	if err := json.Unmarshal(b, &si); err != nil {
+		return err
+	}
+
+	*i = Invoice{
+		ID:             si.ID,
+		SubscriptionID: si.Subscription,
+		Number:         si.Number,
+		Status:         si.Status,
+		Currency:       si.Currency,
+		Total:          si.Total,
+		AmountDue:      si.AmountDue,
+		AmountPaid:     si.AmountPaid,
+		Paid:           si.Paid,
+		Created:        unix(si.Created),
+		PeriodStart:    unix(si.PeriodStart),
+		PeriodEnd:      unix(si.PeriodEnd),
+		URL:            si.HostedInvoiceURL,
+		Lines:          make([]InvoiceLine, 0, len(si.Lines.Data)),
+	}
+	if si.Customer != nil {
+		i.CustomerID = si.Customer.ID
+	}
+	for _, l := range si.Lines.Data {
+		line := InvoiceLine{
+			Description: l.Description,
+			Quantity:    int(l.Quantity),
+			Amount:      l.Amount,
+			Proration:   l.Proration,
+		}
+		if l.Plan != nil {
+			line.Plan = l.Plan.ID
+		}
+		i.Lines = append(i.Lines, line)
+	}
+	return nil
+}
+
+func timestamp(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,44 @@
+package payment
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInvoice_JSON(t *testing.T) {
+	inv := Invoice{
+		ID:          "in_1",
+		CustomerID:  "cus_1",
+		Currency:    "usd",
+		Total:       2500,
+		AmountDue:   2500,
+		Created:     time.Unix(1577836800, 0),
+		PeriodStart: time.Unix(1577836800, 0),
+		URL:         "https://pay.test/in_1",
+		Lines:       []InvoiceLine{{Description: "Pro", Plan: "pro", Quantity: 2, Amount: 2500}},
+	}
+
+	b, err := json.Marshal(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the Stripe invoice format
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["amount_due"] != float64(2500) || raw["created"] != float64(1577836800) || raw["period_end"] != float64(0) ||
+		raw["hosted_invoice_url"] != inv.URL || raw["customer"].(map[string]interface{})["id"] != "cus_1" {
+		t.Errorf("unexpected invoice JSON %s", b)
+	}
+
+	var got Invoice
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, inv) {
+		t.Errorf("expected %+v got %+v", inv, got)
+	}
+}
@@ -0,0 +1,142 @@
+// Package payment abstracts the payment provider used for the billing, Stripe
+// by default. The billing handlers and the queue's invoice task use the
+// Provider set via SetProvider, tests can set a Fake.
+package payment
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when the customer, subscription, card or invoice does
+// not exist at the provider.
+var ErrNotFound = errors.New("not found at the payment provider")
+
+// Customer is a paying account at the provider.
+type Customer struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+// Card is a payment method of a customer, only its last 4 digits are known.
+type Card struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Last4    string `json:"last4"`
+	Brand    string `json:"brand"`
+	ExpMonth int    `json:"expMonth"`
+	ExpYear  int    `json:"expYear"`
+}
+
+// CardParams are the card details to add or update, the Number and CVC are
+// only needed to add a card.
+type CardParams struct {
+	Name     string
+	Number   string
+	ExpMonth string
+	ExpYear  string
+	CVC      string
+}
+
+// Subscription bills a customer for a plan, per seat.
+type Subscription struct {
+	ID               string    `json:"id"`
+	CustomerID       string    `json:"customerId"`
+	Plan             string    `json:"plan"`
+	Quantity         int       `json:"quantity"`
+	Status           string    `json:"status"`
+	TrialEnd         time.Time `json:"trialEnd"`
+	CurrentPeriodEnd time.Time `json:"currentPeriodEnd"`
+}
+
+// SubscriptionParams creates or updates a subscription. When updating, an
+// empty Plan keeps the current one, TrialDays and Coupon are ignored.
+type SubscriptionParams struct {
+	CustomerID string
+	Plan       string
+	Quantity   int
+	TrialDays  int
+	Coupon     string
+}
+
+// Invoice is a bill of a customer, the amounts are in the currency's smallest
+// unit, i.e. cents. It is encoded in JSON like a Stripe invoice so the billing
+// responses keep their format whatever the provider.
+type Invoice struct {
+	ID             string
+	CustomerID     string
+	SubscriptionID string
+	Number         string
+	Status         string
+	Currency       string
+	Total          int64
+	AmountDue      int64
+	AmountPaid     int64
+	Paid           bool
+	Created        time.Time
+	PeriodStart    time.Time
+	PeriodEnd      time.Time
+	URL            string
+	Lines          []InvoiceLine
+}
+
+// InvoiceLine is an item of an invoice, the prorations credit the unused time
+// of the previous plan or charge the remaining time of the new one.
+type InvoiceLine struct {
+	Description string
+	Plan        string
+	Quantity    int
+	Amount      int64
+	Proration   bool
+}
+
+// Provider manages the customers, their subscriptions, cards and invoices.
+type Provider interface {
+	CreateCustomer(email, source string) (*Customer, error)
+	GetCustomer(id string) (*Customer, error)
+
+	CreateSubscription(p SubscriptionParams) (*Subscription, error)
+	UpdateSubscription(id string, p SubscriptionParams) (*Subscription, error)
+	CancelSubscription(id string) error
+
+	Cards(customerID string) ([]Card, error)
+	AddCard(customerID string, p CardParams) (*Card, error)
+	UpdateCard(customerID, cardID string, p CardParams) (*Card, error)
+	DeleteCard(customerID, cardID string) error
+
+	Invoices(customerID string) ([]Invoice, error)
+	NextInvoice(customerID string) (*Invoice, error)
+	// CreateInvoice bills the customer's pending items now instead of on the
+	// next billing date.
+	CreateInvoice(customerID string) (*Invoice, error)
+	// PreviewProration returns the next invoice as if the subscription was
+	// updated with p now.
+	PreviewProration(subscriptionID string, p SubscriptionParams) (*Invoice, error)
+}
+
+var (
+	providerMu sync.RWMutex
+	provider   Provider
+)
+
+// SetProvider sets the Provider used for the billing, Stripe is used by
+// default.
+//
+//	payment.SetProvider(payment.NewFake())
+func SetProvider(p Provider) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	provider = p
+}
+
+// Current returns the Provider set via SetProvider or Stripe with the key set
+// via stripe.Key.
+func Current() Provider {
+	providerMu.RLock()
+	defer providerMu.RUnlock()
+	if provider == nil {
+		return &Stripe{}
+	}
+	return provider
+}
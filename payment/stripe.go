@@ -0,0 +1,286 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	stripe "github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/client"
+)
+
+func init() {
+	stripe.Key = os.Getenv("STRIPE_KEY")
+}
+
+// Stripe is the Provider for Stripe. The Key defaults to stripe.Key, the
+// Backends to Stripe's API.
+type Stripe struct {
+	Key      string
+	Backends *stripe.Backends
+}
+
+// NewStripe returns the Stripe provider using the secret key.
+func NewStripe(key string) *Stripe {
+	return &Stripe{Key: key}
+}
+
+func (s *Stripe) api() *client.API {
+	key := s.Key
+	if len(key) == 0 {
+		key = stripe.Key
+	}
+	return client.New(key, s.Backends)
+}
+
+// CreateCustomer creates a customer paying with the source, a card token.
+func (s *Stripe) CreateCustomer(email, source string) (*Customer, error) {
+	p := &stripe.CustomerParams{Email: stripe.String(email)}
+	if len(source) > 0 {
+		p.SetSource(source)
+	}
+
+	c, err := s.api().Customers.New(p)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return &Customer{ID: c.ID, Email: c.Email}, nil
+}
+
+// GetCustomer returns the customer.
+func (s *Stripe) GetCustomer(id string) (*Customer, error) {
+	c, err := s.api().Customers.Get(id, nil)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return &Customer{ID: c.ID, Email: c.Email}, nil
+}
+
+// CreateSubscription subscribes the customer to the plan.
+func (s *Stripe) CreateSubscription(p SubscriptionParams) (*Subscription, error) {
+	params := &stripe.SubscriptionParams{
+		Customer: stripe.String(p.CustomerID),
+		Plan:     stripe.String(p.Plan),
+		Quantity: stripe.Int64(int64(p.Quantity)),
+	}
+	if p.TrialDays > 0 {
+		params.TrialPeriodDays = stripe.Int64(int64(p.TrialDays))
+	}
+	if len(p.Coupon) > 0 {
+		params.Coupon = stripe.String(p.Coupon)
+	}
+
+	sub, err := s.api().Subscriptions.New(params)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return toSubscription(sub), nil
+}
+
+// UpdateSubscription changes the plan and the quantity, the change is prorated.
+func (s *Stripe) UpdateSubscription(id string, p SubscriptionParams) (*Subscription, error) {
+	params := &stripe.SubscriptionParams{Quantity: stripe.Int64(int64(p.Quantity))}
+	if len(p.Plan) > 0 {
+		params.Plan = stripe.String(p.Plan)
+	}
+
+	sub, err := s.api().Subscriptions.Update(id, params)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return toSubscription(sub), nil
+}
+
+// CancelSubscription cancels the subscription immediately.
+func (s *Stripe) CancelSubscription(id string) error {
+	_, err := s.api().Subscriptions.Cancel(id, nil)
+	return stripeError(err)
+}
+
+// Cards returns the customer's cards.
+func (s *Stripe) Cards(customerID string) ([]Card, error) {
+	cards := make([]Card, 0)
+
+	iter := s.api().Cards.List(&stripe.CardListParams{Customer: stripe.String(customerID)})
+	for iter.Next() {
+		if c := iter.Card(); !c.Deleted {
+			cards = append(cards, toCard(c))
+		}
+	}
+	return cards, stripeError(iter.Err())
+}
+
+// AddCard adds a card to the customer.
+func (s *Stripe) AddCard(customerID string, p CardParams) (*Card, error) {
+	c, err := s.api().Cards.New(&stripe.CardParams{
+		Customer: stripe.String(customerID),
+		Name:     stripe.String(p.Name),
+		Number:   stripe.String(p.Number),
+		ExpMonth: stripe.String(p.ExpMonth),
+		ExpYear:  stripe.String(p.ExpYear),
+		CVC:      stripe.String(p.CVC),
+	})
+	if err != nil {
+		return nil, stripeError(err)
+	}
+
+	card := toCard(c)
+	return &card, nil
+}
+
+// UpdateCard changes the card's name and expiration.
+func (s *Stripe) UpdateCard(customerID, cardID string, p CardParams) (*Card, error) {
+	params := &stripe.CardParams{
+		Customer: stripe.String(customerID),
+		ExpMonth: stripe.String(p.ExpMonth),
+		ExpYear:  stripe.String(p.ExpYear),
+	}
+	if len(p.Name) > 0 {
+		params.Name = stripe.String(p.Name)
+	}
+
+	c, err := s.api().Cards.Update(cardID, params)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+
+	card := toCard(c)
+	return &card, nil
+}
+
+// DeleteCard removes the card from the customer.
+func (s *Stripe) DeleteCard(customerID, cardID string) error {
+	_, err := s.api().Cards.Del(cardID, &stripe.CardParams{Customer: stripe.String(customerID)})
+	return stripeError(err)
+}
+
+// Invoices returns the customer's invoices, the most recent first.
+func (s *Stripe) Invoices(customerID string) ([]Invoice, error) {
+	invoices := make([]Invoice, 0)
+
+	iter := s.api().Invoices.List(&stripe.InvoiceListParams{Customer: stripe.String(customerID)})
+	for iter.Next() {
+		invoices = append(invoices, *toInvoice(iter.Invoice()))
+	}
+	return invoices, stripeError(iter.Err())
+}
+
+// NextInvoice returns the upcoming invoice of the customer.
+func (s *Stripe) NextInvoice(customerID string) (*Invoice, error) {
+	i, err := s.api().Invoices.GetNext(&stripe.InvoiceParams{Customer: stripe.String(customerID)})
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return toInvoice(i), nil
+}
+
+// CreateInvoice invoices the customer's pending invoice items.
+func (s *Stripe) CreateInvoice(customerID string) (*Invoice, error) {
+	i, err := s.api().Invoices.New(&stripe.InvoiceParams{Customer: stripe.String(customerID)})
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return toInvoice(i), nil
+}
+
+// PreviewProration returns the upcoming invoice with the prorations of the
+// subscription change.
+func (s *Stripe) PreviewProration(subscriptionID string, p SubscriptionParams) (*Invoice, error) {
+	params := &stripe.InvoiceParams{
+		Customer:                  stripe.String(p.CustomerID),
+		Subscription:              stripe.String(subscriptionID),
+		SubscriptionQuantity:      stripe.Int64(int64(p.Quantity)),
+		SubscriptionProrationDate: stripe.Int64(time.Now().Unix()),
+	}
+	if len(p.Plan) > 0 {
+		params.SubscriptionPlan = stripe.String(p.Plan)
+	}
+
+	i, err := s.api().Invoices.GetNext(params)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return toInvoice(i), nil
+}
+
+// stripeError returns ErrNotFound for the missing resources.
+func stripeError(err error) error {
+	var se *stripe.Error
+	if errors.As(err, &se) && se.HTTPStatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func unix(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(t, 0)
+}
+
+func toSubscription(s *stripe.Subscription) *Subscription {
+	sub := &Subscription{
+		ID:               s.ID,
+		Quantity:         int(s.Quantity),
+		Status:           string(s.Status),
+		TrialEnd:         unix(s.TrialEnd),
+		CurrentPeriodEnd: unix(s.CurrentPeriodEnd),
+	}
+	if s.Customer != nil {
+		sub.CustomerID = s.Customer.ID
+	}
+	if s.Plan != nil {
+		sub.Plan = s.Plan.ID
+	}
+	return sub
+}
+
+func toCard(c *stripe.Card) Card {
+	return Card{
+		ID:       c.ID,
+		Name:     c.Name,
+		Last4:    c.Last4,
+		Brand:    string(c.Brand),
+		ExpMonth: int(c.ExpMonth),
+		ExpYear:  int(c.ExpYear),
+	}
+}
+
+func toInvoice(i *stripe.Invoice) *Invoice {
+	inv := &Invoice{
+		ID:             i.ID,
+		SubscriptionID: i.Subscription,
+		Number:         i.Number,
+		Status:         string(i.Status),
+		Currency:       string(i.Currency),
+		Total:          i.Total,
+		AmountDue:      i.AmountDue,
+		AmountPaid:     i.AmountPaid,
+		Paid:           i.Paid,
+		Created:        unix(i.Created),
+		PeriodStart:    unix(i.PeriodStart),
+		PeriodEnd:      unix(i.PeriodEnd),
+		URL:            i.HostedInvoiceURL,
+		Lines:          make([]InvoiceLine, 0),
+	}
+	if i.Customer != nil {
+		inv.CustomerID = i.Customer.ID
+	}
+	if i.Lines != nil {
+		for _, l := range i.Lines.Data {
+			line := InvoiceLine{
+				Description: l.Description,
+				Quantity:    int(l.Quantity),
+				Amount:      l.Amount,
+				Proration:   l.Proration,
+			}
+			if l.Plan != nil {
+				line.Plan = l.Plan.ID
+			}
+			inv.Lines = append(inv.Lines, line)
+		}
+	}
+	return inv
+}
@@ -0,0 +1,73 @@
+package payment
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	stripe "github.com/stripe/stripe-go"
+)
+
+func stripeTestServer(t *testing.T, handler http.HandlerFunc) *Stripe {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	backend := stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{URL: srv.URL})
+	return &Stripe{Key: "sk_test", Backends: &stripe.Backends{API: backend}}
+}
+
+func TestStripe_CreateSubscription(t *testing.T) {
+	var form url.Values
+	s := stripeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/subscriptions" || r.Method != "POST" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		form, _ = url.ParseQuery(string(b))
+		w.Write([]byte(`{"id":"sub_1","customer":"cus_1","plan":{"id":"pro"},"quantity":3,"status":"trialing","trial_end":1600000000,"current_period_end":1600000000}`))
+	})
+
+	sub, err := s.CreateSubscription(SubscriptionParams{CustomerID: "cus_1", Plan: "pro", Quantity: 3, TrialDays: 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("customer") != "cus_1" || form.Get("plan") != "pro" || form.Get("quantity") != "3" || form.Get("trial_period_days") != "14" {
+		t.Errorf("unexpected parameters %v", form)
+	}
+	if sub.ID != "sub_1" || sub.CustomerID != "cus_1" || sub.Plan != "pro" || sub.Quantity != 3 || sub.TrialEnd.Unix() != 1600000000 {
+		t.Errorf("unexpected subscription %v", sub)
+	}
+}
+
+func TestStripe_PreviewProration(t *testing.T) {
+	s := stripeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/v1/invoices/upcoming" || q.Get("subscription") != "sub_1" || q.Get("subscription_plan") != "pro" || q.Get("subscription_proration_date") == "" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, q)
+		}
+		w.Write([]byte(`{"customer":"cus_1","subscription":"sub_1","total":2500,"currency":"usd","lines":{"data":[
+			{"amount":-500,"proration":true,"quantity":1,"plan":{"id":"starter"}},
+			{"amount":3000,"quantity":1,"plan":{"id":"pro"}}]}}`))
+	})
+
+	i, err := s.PreviewProration("sub_1", SubscriptionParams{CustomerID: "cus_1", Plan: "pro", Quantity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Total != 2500 || i.CustomerID != "cus_1" || len(i.Lines) != 2 || !i.Lines[0].Proration || i.Lines[1].Plan != "pro" {
+		t.Errorf("unexpected invoice %v", i)
+	}
+}
+
+func TestStripe_NotFound(t *testing.T) {
+	s := stripeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"type":"invalid_request_error","code":"resource_missing","message":"No such customer: cus_x"}}`))
+	})
+
+	if _, err := s.GetCustomer("cus_x"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
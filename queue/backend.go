@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Backend stores the queued tasks and their status.
+//
+// Three backends are available: Redis (the default set by New), Postgres and
+// in-memory. Use SetBackend to change it:
+//
+//	queue.SetBackend(queue.NewPostgresBackend(db.Connection))
+//
+// Tasks are reserved by one consumer at a time and must be acknowledged, retried,
+// postponed or buried once executed. Tasks reserved by a consumer that stopped
+// without doing so are made available again.
+type Backend interface {
+	// Push queues a task to execute at or after at, a zero at means now.
+	Push(qt QueueTask, payload string, at time.Time) error
+	// Reserve waits up to timeout for a due task, it returns ErrNoTask if none arrived.
+	// A zero timeout does not wait.
+	Reserve(timeout time.Duration) (*Delivery, error)
+	// Start marks a reserved task as running.
+	Start(d *Delivery) error
+	// Ack marks a reserved task as succeeded.
+	Ack(d *Delivery) error
+	// Retry marks a reserved task as failed, the next version of the task
+	// executes at or after at.
+	Retry(d *Delivery, next QueueTask, payload string, at time.Time) error
+	// Postpone puts a reserved task back in the queue until at, without
+	// counting an attempt.
+	Postpone(d *Delivery, at time.Time) error
+	// Bury marks a reserved task as dead, it will not be executed again.
+	Bury(d *Delivery, next QueueTask, payload string) error
+	// Allow counts an execution of the task for its rate limit and returns how
+	// long to wait when rate executions per period are exceeded.
+	Allow(id TaskID, rate int64, per time.Duration) (time.Duration, error)
+
+	// Inspect returns the status of a task or ErrTaskNotFound.
+	Inspect(uuid string) (*TaskInfo, error)
+	// Tasks returns the tasks with the status, or all if empty, the most recent first.
+	Tasks(status Status, offset, limit int) ([]TaskInfo, error)
+	// Requeue queues a dead or failed task for immediate execution, resetting its attempts.
+	Requeue(uuid string) error
+	// Purge removes a task that is not running.
+	Purge(uuid string) error
+	// PurgeStatus removes all the tasks with the status and returns how many were removed.
+	PurgeStatus(status Status) (int, error)
+
+	// Subscribe registers this instance as a consumer.
+	Subscribe() error
+	// Unsubscribe makes the tasks still reserved by this consumer available again.
+	Unsubscribe() error
+	// Maintain runs the backend housekeeping of a consumer until done is closed.
+	Maintain(done <-chan struct{})
+}
+
+// Delivery is a task reserved by a consumer.
+type Delivery struct {
+	// ID identifies the task for the backend.
+	ID string
+	// Payload is the task as queued.
+	Payload string
+	// Task is the decoded payload, it is set before the task is started.
+	Task QueueTask
+}
+
+var (
+	// ErrNoTask is returned by Reserve when no task is due.
+	ErrNoTask = errors.New("no task available")
+	// ErrNoBackend is returned when neither New nor SetBackend were called.
+	ErrNoBackend = errors.New("the queue has no backend, call New or SetBackend")
+)
+
+var (
+	backendMu sync.RWMutex
+	backend   Backend
+)
+
+// SetBackend sets where the tasks are stored, it must be called before the
+// tasks are enqueued and before SetAsSubscriber.
+func SetBackend(b Backend) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	backend = b
+}
+
+func currentBackend() (Backend, error) {
+	backendMu.RLock()
+	defer backendMu.RUnlock()
+	if backend == nil {
+		return nil, ErrNoBackend
+	}
+	return backend, nil
+}
+
+// clock is implemented by the backends controlling the current time, the
+// retries and delays are computed from it.
+type clock interface {
+	now() time.Time
+}
+
+// timeOf returns the current time of the backend.
+func timeOf(b Backend) time.Time {
+	if c, ok := b.(clock); ok {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// rateWindow returns the window of the rate limit and the time left in it.
+func rateWindow(id TaskID, per time.Duration, now time.Time) (string, time.Duration) {
+	window := now.UnixNano() / int64(per)
+	left := time.Duration((window+1)*int64(per) - now.UnixNano())
+	return fmt.Sprintf("%s_%d", id, window), left
+}
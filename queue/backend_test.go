@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// drain processes the due tasks of b until none is left.
+func drain(t *testing.T, b Backend) int {
+	n := 0
+	for {
+		d, err := b.Reserve(0)
+		if err == ErrNoTask {
+			return n
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		process(b, d)
+		n++
+	}
+}
+
+// testBackend runs the same scenario against every Backend implementation.
+func testBackend(t *testing.T, b Backend) {
+	setTestExecutors()
+	SetBackend(b)
+	defer SetBackend(nil)
+
+	SetRetryPolicy(taskTestFail, RetryPolicy{MaxRetries: 1})
+	defer SetRetryPolicy(taskTestFail, DefaultRetryPolicy)
+
+	ok, err := Enqueue(taskTestOK, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail, err := Enqueue(taskTestFail, "fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := EnqueueIn(taskTestOK, "later", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the failing task is retried without backoff then dies
+	if n := drain(t, b); n != 3 {
+		t.Errorf("processed %d tasks, expected 3", n)
+	}
+
+	if ti, err := Inspect(ok); err != nil {
+		t.Fatal(err)
+	} else if ti.Status != StatusSucceeded || ti.Finished == nil {
+		t.Errorf("unexpected succeeded task %+v", ti)
+	}
+	if ti, err := Inspect(fail); err != nil {
+		t.Fatal(err)
+	} else if ti.Status != StatusDead || ti.Attempts != 2 || ti.LastError != "boom" {
+		t.Errorf("unexpected dead task %+v", ti)
+	}
+	if ti, err := Inspect(later); err != nil {
+		t.Fatal(err)
+	} else if ti.Status != StatusQueued || ti.RunAt == nil || time.Until(*ti.RunAt) < 59*time.Minute {
+		t.Errorf("unexpected delayed task %+v", ti)
+	}
+
+	if dead, err := Tasks(StatusDead, 0, 10); err != nil {
+		t.Fatal(err)
+	} else if len(dead) != 1 || dead[0].UUID != fail || string(dead[0].Data) != `"fail"` {
+		t.Errorf("unexpected dead tasks %+v", dead)
+	}
+	if all, err := Tasks("", 1, 1); err != nil {
+		t.Fatal(err)
+	} else if len(all) != 1 || all[0].UUID != fail {
+		t.Errorf("unexpected second most recent task %+v", all)
+	}
+
+	if err := Retry(fail); err != nil {
+		t.Fatal(err)
+	}
+	if err := Retry(fail); err == nil {
+		t.Error("queued tasks cannot be retried")
+	}
+
+	// a consumer stopping releases the tasks it reserved
+	if _, err := b.Reserve(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Reserve(0); err != ErrNoTask {
+		t.Errorf("expected ErrNoTask while the task is reserved, got %v", err)
+	}
+	if err := b.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := b.Reserve(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Task, _ = decodeTask(d.Payload)
+	if err := b.Ack(d); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Purge(later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Inspect(later); err != ErrTaskNotFound {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+	if n, err := PurgeStatus(StatusSucceeded); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("purged %d succeeded tasks, expected 2", n)
+	}
+
+	// undecodable payloads are buried but not listed
+	if err := b.Push(QueueTask{}, "not json", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	drain(t, b)
+	if n, err := PurgeStatus(StatusDead); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("purged %d dead tasks, expected 0", n)
+	}
+	if tasks, _ := Tasks("", 0, 0); len(tasks) != 0 {
+		t.Errorf("expected no tasks left, got %+v", tasks)
+	}
+
+	if wait, err := b.Allow(taskTestOK, 1, time.Hour); err != nil || wait != 0 {
+		t.Errorf("first execution wait %v err %v", wait, err)
+	}
+	if wait, _ := b.Allow(taskTestOK, 1, time.Hour); wait <= 0 || wait > time.Hour {
+		t.Errorf("second execution wait %v", wait)
+	}
+}
+
+func TestBackend_Redis(t *testing.T) {
+	b := newTestBackend(t)
+	b.client.Del(b.client.Keys(keyRate + "*").Val()...)
+	testBackend(t, b)
+}
+
+func TestBackend_RedisRateExpiry(t *testing.T) {
+	b := newTestBackend(t)
+	window, _ := rateWindow(taskTestOK, time.Minute, time.Now())
+	key := keyRate + window
+	b.client.Del(key)
+
+	// a window key left without expiry is given one
+	b.client.Set(key, 5, 0)
+	if _, err := b.Allow(taskTestOK, 10, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := b.client.TTL(key).Val(); ttl <= 0 || ttl > time.Minute+time.Second {
+		t.Errorf("expected the rate window to expire, got ttl %v", ttl)
+	}
+	if n, _ := b.client.Get(key).Int64(); n != 6 {
+		t.Errorf("expected 6 executions counted, got %d", n)
+	}
+}
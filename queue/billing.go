@@ -1,32 +1,49 @@
 package queue
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"time"
 
-	stripe "github.com/stripe/stripe-go"
-	"github.com/stripe/stripe-go/invoice"
+	"github.com/jlb922/gosaas/payment"
 )
 
 func init() {
-	stripe.Key = os.Getenv("STRIPE_KEY")
+	Register(TaskCreateInvoice, func(ctx context.Context, customerID string) error {
+		if biller == nil {
+			return fmt.Errorf("the queue has not been initialized via New")
+		}
+		return biller.Run(ctx, customerID)
+	})
 }
 
-type Billing struct{}
+// InvoiceDelay is how long the invoice creation is delayed after a subscription
+// change, it lets other add/remove operations happen in between so they are
+// charged on the same invoice.
+var InvoiceDelay = 2 * time.Hour
 
-func (b *Billing) Run(qt QueueTask) error {
-	id, ok := qt.Data.(string)
-	if !ok {
-		return fmt.Errorf("the data should be a stripe customer ID")
+// Billing creates an invoice for a customer of the payment provider, the data
+// is the customer ID. Enqueue it via EnqueueIn with InvoiceDelay.
+//
+// The Provider defaults to the one set via payment.SetProvider.
+type Billing struct {
+	Provider payment.Provider
+}
+
+// Run creates an invoice for the customer's pending invoice items.
+func (b *Billing) Run(ctx context.Context, customerID string) error {
+	if len(customerID) == 0 {
+		return Permanent(fmt.Errorf("the data should be a stripe customer ID"))
 	}
 
-	// we delay execution for 2 hours to let add/remove
-	// operations in between creating the invoice
-	// since we're on a go routine we can use a time.Sleep
-	time.Sleep(2 * time.Hour)
+	p := b.Provider
+	if p == nil {
+		p = payment.Current()
+	}
 
-	p := &stripe.InvoiceParams{Customer: &id}
-	_, err := invoice.New(p)
+	_, err := p.CreateInvoice(customerID)
+	if err == payment.ErrNotFound {
+		return Permanent(err)
+	}
 	return err
 }
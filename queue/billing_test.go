@@ -0,0 +1,31 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jlb922/gosaas/payment"
+)
+
+func TestBilling_CreateInvoice(t *testing.T) {
+	f := payment.NewFake()
+	f.Prices["pro"] = 3000
+	c, _ := f.CreateCustomer("a@test.com", "tok_visa")
+	s, _ := f.CreateSubscription(payment.SubscriptionParams{CustomerID: c.ID, Plan: "pro", Quantity: 1})
+	f.UpdateSubscription(s.ID, payment.SubscriptionParams{Quantity: 2})
+
+	b := &Billing{Provider: f}
+	if err := b.Run(context.Background(), c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := f.Invoices(c.ID); len(list) != 1 || list[0].Total <= 0 {
+		t.Errorf("expected the prorations to be invoiced, got %v", list)
+	}
+
+	if err := b.Run(context.Background(), ""); !isPermanent(err) {
+		t.Errorf("expected a permanent error without a customer, got %v", err)
+	}
+	if err := b.Run(context.Background(), "cus_unknown"); !isPermanent(err) {
+		t.Errorf("expected a permanent error for an unknown customer, got %v", err)
+	}
+}
@@ -1,63 +1,232 @@
 package queue
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
 	"github.com/jlb922/gosaas/queue/email"
+	uuid "github.com/satori/go.uuid"
 )
 
+// SendEmailParameter is the payload of the TaskEmail task. The email is rendered
+// from the Template in the recipient's Language when it is sent, see email.Render.
 type SendEmailParameter struct {
-	From    string `json:"From"`
-	To      string `json:"To"`
-	Subject string `json:"Subject"`
-	Body    string `json:"Body"`
+	From     string `json:"From"`
+	FromName string `json:"FromName,omitempty"`
+	To       string `json:"To"`
+	ToName   string `json:"ToName,omitempty"`
+	ReplyTo  string `json:"ReplyTo,omitempty"`
+	// Subject overrides the subject defined by the template.
+	Subject  string                 `json:"Subject,omitempty"`
+	Template string                 `json:"Template"`
+	Language string                 `json:"Language,omitempty"`
+	Data     map[string]interface{} `json:"Data,omitempty"`
+	// Tags and Metadata are passed to the provider, they are reported back
+	// in its events.
+	Tags     []string          `json:"Tags,omitempty"`
+	Metadata map[string]string `json:"Metadata,omitempty"`
+	// Category defaults to transactional, the emails of the other categories
+	// have an unsubscribe link and are not sent to the unsubscribed users.
+	Category model.EmailCategory `json:"Category,omitempty"`
 }
 
+// TaskVersion is 2 since the emails are rendered from templates, the version 1
+// had a raw Body.
+func (SendEmailParameter) TaskVersion() int { return 2 }
+
+// Email sends the TaskEmail tasks via the Provider. Send returns the message ID
+// the provider assigned, the sent emails are recorded with it in the Outbox.
 type Email struct {
-	Send func(p SendEmailParameter) error
+	Provider string
+	Send     func(m email.Message) (string, error)
+}
+
+// Outbox records the emails sent by the TaskEmail executor and the addresses
+// that must not receive emails anymore, data.EmailServices implements it.
+type Outbox interface {
+	Record(e *model.Email) error
+	IsSuppressed(email string) (bool, error)
+}
+
+var (
+	outboxMu sync.RWMutex
+	outbox   Outbox
+)
+
+// SetOutbox records the emails in o, the emails to suppressed addresses are
+// dropped. Nothing is recorded by default.
+//
+//	queue.SetOutbox(db.Emails)
+func SetOutbox(o Outbox) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	outbox = o
 }
 
-type Emailer interface {
-	Send(toEmail, toName, fromEmail, fromName, subject, body, replyTo string) error
+func currentOutbox() Outbox {
+	outboxMu.RLock()
+	defer outboxMu.RUnlock()
+	return outbox
 }
 
-func (e *Email) Run(qt QueueTask) error {
-	// First make sure the qt.Data interface can be cast to a map[string]interface{}
-	m, ok := qt.Data.(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("this data is not a proper SendEmailParameter")
+// Preferences returns whether an address accepts a category of emails,
+// data.UserServices implements it.
+type Preferences interface {
+	AcceptsEmail(email string, category model.EmailCategory) (bool, error)
+}
+
+var (
+	preferencesMu sync.RWMutex
+	preferences   Preferences
+)
+
+// SetPreferences checks the users' preferences before sending the emails that
+// are not transactional. They are all sent by default.
+//
+//	queue.SetPreferences(db.Users)
+func SetPreferences(p Preferences) {
+	preferencesMu.Lock()
+	defer preferencesMu.Unlock()
+	preferences = p
+}
+
+func currentPreferences() Preferences {
+	preferencesMu.RLock()
+	defer preferencesMu.RUnlock()
+	return preferences
+}
+
+func init() {
+	Register(TaskEmail, func(ctx context.Context, p SendEmailParameter) error {
+		if emailer == nil {
+			return fmt.Errorf("the queue has not been initialized via New")
+		}
+		return emailer.Run(ctx, p)
+	}, Upgrade(1, func(data json.RawMessage) (json.RawMessage, error) {
+		return nil, fmt.Errorf("emails with a raw Body cannot be rendered, enqueue them with a Template")
+	}))
+}
+
+// Run renders and sends the email. A missing or invalid template and the errors
+// the provider reports as permanent are not retried, the emails to suppressed
+// addresses and to the users unsubscribed from their category are dropped.
+func (e *Email) Run(ctx context.Context, p SendEmailParameter) error {
+	lng := p.Language
+	if len(lng) == 0 {
+		lng = "en"
 	}
 
-	// then fill the struct values
-	var p SendEmailParameter
-	if err := fillStruct(&p, m); err != nil {
-		return fmt.Errorf("error fill struct: %s", err.Error())
+	rec := &model.Email{
+		TaskID:   TaskUUID(ctx),
+		To:       p.To,
+		Template: p.Template,
+		Provider: e.Provider,
+	}
+	if len(rec.TaskID) == 0 {
+		rec.TaskID = uuid.NewV4().String()
 	}
 
-	return e.Send(p)
-}
+	category := p.Category
+	if len(category) == 0 {
+		category = model.EmailTransactional
+	}
+
+	data, unsubscribe := p.Data, ""
+	if category != model.EmailTransactional {
+		if prefs := currentPreferences(); prefs != nil {
+			ok, err := prefs.AcceptsEmail(p.To, category)
+			if err != nil {
+				return err
+			} else if !ok {
+				record(rec, model.EmailSuppressed, "unsubscribed from "+string(category))
+				return nil
+			}
+		}
+
+		// the layout links to the unsubscribe URL
+		if unsubscribe = email.UnsubscribeURL(p.To, string(category)); len(unsubscribe) > 0 {
+			data = make(map[string]interface{}, len(p.Data)+1)
+			for k, v := range p.Data {
+				data[k] = v
+			}
+			data["UnsubscribeURL"] = unsubscribe
+		}
+	}
+
+	m, err := email.Render(p.Template, lng, data)
+	if err != nil {
+		record(rec, model.EmailFailed, err.Error())
+		return Permanent(err)
+	}
+	if len(unsubscribe) > 0 {
+		m.SetUnsubscribe(unsubscribe)
+	}
+
+	m.From, m.FromName = p.From, p.FromName
+	if len(m.From) == 0 {
+		m.From, m.FromName = config.Current.EmailFrom, config.Current.EmailFromName
+	}
+	m.To, m.ToName = p.To, p.ToName
+	m.ReplyTo = p.ReplyTo
+	m.Tags, m.Metadata = p.Tags, p.Metadata
+	if len(p.Subject) > 0 {
+		m.Subject = p.Subject
+	}
+	rec.Subject = m.Subject
 
-func (e *Email) sendEmailDev(p SendEmailParameter) error {
-	fmt.Println("email would have been sent:")
-	fmt.Println(p)
+	if o := currentOutbox(); o != nil {
+		suppressed, err := o.IsSuppressed(m.To)
+		if err != nil {
+			return err
+		} else if suppressed {
+			record(rec, model.EmailSuppressed, "")
+			return nil
+		}
+	}
+
+	id, err := e.Send(*m)
+	if err != nil {
+		if email.IsPermanent(err) {
+			record(rec, model.EmailFailed, err.Error())
+			return Permanent(err)
+		}
+		return err
+	}
+
+	rec.MessageID = id
+	record(rec, model.EmailSent, "")
 	return nil
 }
 
-func (e *Email) sendEmailProd(p SendEmailParameter) error {
-	var emailer Emailer
-	if config.Current.EmailProvider == "amazonses" {
-		emailer = &email.AmazonSES{}
-	}
-	if config.Current.EmailProvider == "google" {
-		emailer = &email.Gmail{}
+// record saves the email in the outbox, the errors are only logged, the email
+// must not be sent twice because it could not be recorded.
+func record(rec *model.Email, status model.EmailStatus, reason string) {
+	o := currentOutbox()
+	if o == nil {
+		return
 	}
 
-	if emailer == nil {
-		log.Println("cannot find email provider named: %s", config.Current.EmailProvider)
-		return nil
+	rec.Status, rec.Reason = status, reason
+	if err := o.Record(rec); err != nil {
+		log.Println("unable to record the email in the outbox", rec.TaskID, err)
 	}
+}
+
+// sendEmailDev keeps the email in the mailbox read on /tools/mail.
+func (e *Email) sendEmailDev(m email.Message) (string, error) {
+	log.Println("caught email to", m.To, "read it on /tools/mail")
+	return email.Deliver(&email.Catcher{}, m)
+}
 
-	return emailer.Send(p.To, p.To, p.From, p.From, p.Subject, p.Body, "")
+func (e *Email) sendEmailProd(m email.Message) (string, error) {
+	p, err := email.Get(e.Provider)
+	if err != nil {
+		return "", err
+	}
+	return email.Deliver(p, m)
 }
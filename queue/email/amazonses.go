@@ -2,118 +2,80 @@ package email
 
 import (
 	"bytes"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"html"
 	"html/template"
-	"log"
-	"net/smtp"
 	"strings"
 
-	"github.com/jlb922/gosaas/internal/config"
 	ses "github.com/sourcegraph/go-ses"
 	"gopkg.in/gomail.v2"
 )
 
+// AmazonSES sends the emails via Amazon SES, configured from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_KEY and AWS_SES_ENDPOINT environment variables.
 type AmazonSES struct{}
 
-type Gmail struct{}
-
-var auth smtp.Auth
-
-type Request struct {
-	from    string
-	to      []string
-	subject string
-	body    string
+// Send uses Amazon SES to send the email, the text part is converted from the HTML
+// one when it is empty.
+func (a AmazonSES) Send(m Message) error {
+	_, err := a.SendMessage(m)
+	return err
 }
 
-// Send uses Amazon SES to send an HTML email, it will convert body to text automatically
-func (a AmazonSES) Send(toEmail, toName, fromEmail, fromName, subject, body, replyTo string) error {
-	if len(toEmail) == 0 || strings.Index(toEmail, "@") == -1 {
-		return fmt.Errorf("empty To email")
-	}
-
-	m := gomail.NewMessage()
-	m.SetHeader("To", toEmail)
-	if len(replyTo) > 0 {
-		m.SetHeader("From", fromName+" <"+replyTo+">")
-		m.SetHeader("Reply-To", replyTo)
-	} else {
-		m.SetHeader("From", fromName+" <"+fromEmail+">")
+// SendMessage sends the email and returns its SES MessageId.
+func (a AmazonSES) SendMessage(m Message) (string, error) {
+	b, err := build(m)
+	if err != nil {
+		return "", err
 	}
-	m.SetHeader("Subject", subject)
-	m.AddAlternative("text/plain", stripHTML(body))
-	m.SetBody("text/html", body)
-
-	var b bytes.Buffer
-	m.WriteTo(&b)
 
-	res, err := ses.EnvConfig.SendRawEmail(b.Bytes())
+	res, err := ses.EnvConfig.SendRawEmail(b)
 	if err != nil {
-		return err
-	} else if len(res) == 0 {
-		return errors.New("No email id returned by Amazon SES")
+		return "", err
 	}
 
-	return nil
+	var r struct {
+		MessageID string `xml:"SendRawEmailResult>MessageId"`
+	}
+	if err := xml.Unmarshal([]byte(res), &r); err != nil || len(r.MessageID) == 0 {
+		return "", errors.New("No email id returned by Amazon SES")
+	}
+	return r.MessageID, nil
 }
 
-func newRequest(to []string, subject, body string) *Request {
-	return &Request{
-		to:      to,
-		subject: subject,
-		body:    body,
+// build returns the MIME message with the text and HTML alternatives.
+func build(m Message) ([]byte, error) {
+	if len(m.To) == 0 || strings.Index(m.To, "@") == -1 {
+		return nil, fmt.Errorf("empty To email")
 	}
-}
 
-func (r *Request) parseTemplate(templateFileName string, data interface{}) error {
-	t, err := template.ParseFiles(templateFileName)
-	if err != nil {
-		return err
+	msg := gomail.NewMessage()
+	msg.SetAddressHeader("To", m.To, m.ToName)
+	msg.SetAddressHeader("From", m.From, m.FromName)
+	if len(m.ReplyTo) > 0 {
+		msg.SetHeader("Reply-To", m.ReplyTo)
 	}
-	buf := new(bytes.Buffer)
-	if err = t.Execute(buf, data); err != nil {
-		return err
+	msg.SetHeader("Subject", m.Subject)
+	for k, v := range m.Headers {
+		msg.SetHeader(k, v)
 	}
-	r.body = buf.String()
-	return nil
-}
 
-// Send uses GMail SMTP to send an HTML email, it will convert body to text automatically
-// body holds the link to the reset form
-// Code based off https://medium.com/@dhanushgopinath/sending-html-emails-using-templates-in-golang-9e953ca32f3d
-// TODO - use a package like https://github.com/matcornic/hermes
-func (g Gmail) Send(toEmail, toName, fromEmail, fromName, subject, body, replyTo string) error {
-	if len(toEmail) == 0 || strings.Index(toEmail, "@") == -1 {
-		return fmt.Errorf("empty To email")
+	text := m.Text
+	if len(text) == 0 {
+		text = stripHTML(m.HTML)
 	}
-
-	GMAIL_USERNAME := config.Current.EmailLogin
-	GMAIL_PASSWORD := config.Current.EmailPassword
-	auth = smtp.PlainAuth("", GMAIL_USERNAME, GMAIL_PASSWORD, "smtp.gmail.com")
-	templateData := struct {
-		Name string
-		URL  string
-	}{
-		Name: ",",
-		URL:  body,
+	msg.SetBody("text/plain", text)
+	if len(m.HTML) > 0 {
+		msg.AddAlternative("text/html", m.HTML)
 	}
-	fmt.Println(templateData.URL)
-	r := newRequest([]string{toEmail}, subject, body)
-	if err := r.parseTemplate("./templates/forgot_email.html", templateData); err == nil {
-		mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-		subject := "Subject: " + r.subject + "\n"
-		to := "To: " + toEmail + "\n"
-		msg := []byte(subject + to + mime + "\n" + r.body)
-		addr := "smtp.gmail.com:587"
-		if err := smtp.SendMail(addr, auth, fromEmail, r.to, msg); err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		fmt.Println("Error Parsing Template", err)
+
+	var b bytes.Buffer
+	if _, err := msg.WriteTo(&b); err != nil {
+		return nil, err
 	}
-	return nil
+	return b.Bytes(), nil
 }
 
 // stripHTML returns a version of a string with HTML tags stripped
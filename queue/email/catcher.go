@@ -0,0 +1,219 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// MailboxSize is the number of emails kept by the mailboxes, the oldest are
+// dropped.
+var MailboxSize = 100
+
+// ErrNotCaught is returned when the caught email cannot be found.
+var ErrNotCaught = errors.New("email not found in the mailbox")
+
+// Caught is an email kept by the Catcher.
+type Caught struct {
+	ID   string    `json:"id"`
+	Sent time.Time `json:"sent"`
+	Message
+}
+
+// Mailbox stores the emails caught during development. List returns the most
+// recent first, the emails sent to the address when to is not empty.
+type Mailbox interface {
+	Add(c Caught) error
+	List(to string) ([]Caught, error)
+	Get(id string) (*Caught, error)
+	Clear() error
+}
+
+var (
+	mailboxMu sync.RWMutex
+	mailbox   Mailbox
+)
+
+// SetMailbox sets the Mailbox the "catcher" provider keeps the emails in, the
+// queue sets one in dev mode.
+func SetMailbox(mb Mailbox) {
+	mailboxMu.Lock()
+	defer mailboxMu.Unlock()
+	mailbox = mb
+}
+
+// CurrentMailbox returns the Mailbox set via SetMailbox, nil when the emails
+// are not caught.
+func CurrentMailbox() Mailbox {
+	mailboxMu.RLock()
+	defer mailboxMu.RUnlock()
+	return mailbox
+}
+
+// Catcher keeps the emails in its Mailbox instead of sending them, the current
+// Mailbox is used when it is nil.
+type Catcher struct {
+	Mailbox Mailbox
+}
+
+// Send keeps the email in the mailbox.
+func (c *Catcher) Send(m Message) error {
+	_, err := c.SendMessage(m)
+	return err
+}
+
+// SendMessage keeps the email in the mailbox and returns its ID.
+func (c *Catcher) SendMessage(m Message) (string, error) {
+	mb := c.Mailbox
+	if mb == nil {
+		mb = CurrentMailbox()
+	}
+	if mb == nil {
+		return "", errors.New("no mailbox to catch the emails, call SetMailbox")
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	caught := Caught{ID: hex.EncodeToString(b), Sent: time.Now(), Message: m}
+	return caught.ID, mb.Add(caught)
+}
+
+// MemoryMailbox keeps the caught emails in memory.
+type MemoryMailbox struct {
+	mu     sync.Mutex
+	emails []Caught
+}
+
+// NewMemoryMailbox returns an empty in-memory mailbox.
+func NewMemoryMailbox() *MemoryMailbox {
+	return &MemoryMailbox{}
+}
+
+// Add keeps the email.
+func (mb *MemoryMailbox) Add(c Caught) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.emails = append([]Caught{c}, mb.emails...)
+	if len(mb.emails) > MailboxSize {
+		mb.emails = mb.emails[:MailboxSize]
+	}
+	return nil
+}
+
+// List returns the emails, the most recent first.
+func (mb *MemoryMailbox) List(to string) ([]Caught, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return filterCaught(mb.emails, to), nil
+}
+
+// Get returns the email by ID.
+func (mb *MemoryMailbox) Get(id string) (*Caught, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return findCaught(mb.emails, id)
+}
+
+// Clear removes all emails.
+func (mb *MemoryMailbox) Clear() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.emails = nil
+	return nil
+}
+
+// RedisMailbox keeps the caught emails in a Redis list, they are shared by the
+// web servers and the queue consumers.
+type RedisMailbox struct {
+	rc  *redis.Client
+	key string
+}
+
+// NewRedisMailbox returns a mailbox stored in the gosaas:mailbox list.
+func NewRedisMailbox(rc *redis.Client) *RedisMailbox {
+	return &RedisMailbox{rc: rc, key: "gosaas:mailbox"}
+}
+
+// Add keeps the email.
+func (mb *RedisMailbox) Add(c Caught) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = mb.rc.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LPush(mb.key, b)
+		pipe.LTrim(mb.key, 0, int64(MailboxSize-1))
+		return nil
+	})
+	return err
+}
+
+func (mb *RedisMailbox) all() ([]Caught, error) {
+	items, err := mb.rc.LRange(mb.key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]Caught, 0, len(items))
+	for _, item := range items {
+		var c Caught
+		if err := json.Unmarshal([]byte(item), &c); err != nil {
+			return nil, err
+		}
+		emails = append(emails, c)
+	}
+	return emails, nil
+}
+
+// List returns the emails, the most recent first.
+func (mb *RedisMailbox) List(to string) ([]Caught, error) {
+	emails, err := mb.all()
+	if err != nil {
+		return nil, err
+	}
+	return filterCaught(emails, to), nil
+}
+
+// Get returns the email by ID.
+func (mb *RedisMailbox) Get(id string) (*Caught, error) {
+	emails, err := mb.all()
+	if err != nil {
+		return nil, err
+	}
+	return findCaught(emails, id)
+}
+
+// Clear removes all emails.
+func (mb *RedisMailbox) Clear() error {
+	return mb.rc.Del(mb.key).Err()
+}
+
+func filterCaught(emails []Caught, to string) []Caught {
+	list := make([]Caught, 0, len(emails))
+	for _, c := range emails {
+		if len(to) == 0 || strings.EqualFold(c.To, to) {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+func findCaught(emails []Caught, id string) (*Caught, error) {
+	for _, c := range emails {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, ErrNotCaught
+}
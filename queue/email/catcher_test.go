@@ -0,0 +1,102 @@
+package email
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+// testMailbox runs the same scenario against any mailbox.
+func testMailbox(t *testing.T, mb Mailbox) {
+	defer func(n int) { MailboxSize = n }(MailboxSize)
+	MailboxSize = 3
+
+	c := &Catcher{Mailbox: mb}
+	var ids []string
+	for _, to := range []string{"a@test.com", "b@test.com", "a@test.com", "A@test.com"} {
+		m := testMessage()
+		m.To = to
+		id, err := c.SendMessage(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	all, err := mb.List("")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(all) != 3 || all[0].ID != ids[3] {
+		t.Fatalf("expected the 3 most recent emails, got %+v", all)
+	}
+
+	if list, _ := mb.List("a@test.com"); len(list) != 2 {
+		t.Errorf("expected 2 emails to a@test.com, got %d", len(list))
+	}
+
+	got, err := mb.Get(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	} else if got.To != "b@test.com" || got.HTML != "<p>Hello</p>" || got.Sent.IsZero() {
+		t.Errorf("unexpected email %+v", got)
+	}
+
+	// the oldest was dropped
+	if _, err := mb.Get(ids[0]); err != ErrNotCaught {
+		t.Errorf("expected ErrNotCaught, got %v", err)
+	}
+
+	if err := mb.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := mb.List(""); len(list) != 0 {
+		t.Errorf("expected an empty mailbox, got %d emails", len(list))
+	}
+}
+
+func TestMailbox_Memory(t *testing.T) {
+	testMailbox(t, NewMemoryMailbox())
+}
+
+func TestMailbox_Redis(t *testing.T) {
+	host := os.Getenv("REDIS_ADDR")
+	if len(host) == 0 {
+		host = "127.0.0.1:6379"
+	}
+
+	rc := redis.NewClient(&redis.Options{Addr: host, Password: os.Getenv("REDIS_KEY")})
+	if err := rc.Ping().Err(); err != nil {
+		t.Skip("redis is not available", err)
+	}
+
+	mb := NewRedisMailbox(rc)
+	mb.key = "gosaas:mailbox:test"
+	defer mb.Clear()
+
+	testMailbox(t, mb)
+}
+
+func TestCatcher_CurrentMailbox(t *testing.T) {
+	p, err := Get("catcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetMailbox(nil)
+	if err := p.Send(testMessage()); err == nil {
+		t.Error("expected an error without mailbox")
+	}
+
+	mb := NewMemoryMailbox()
+	SetMailbox(mb)
+	defer SetMailbox(nil)
+
+	id, err := Deliver(p, testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := mb.Get(id); err != nil || c.Subject != "Hello" {
+		t.Errorf("unexpected caught email %+v %v", c, err)
+	}
+}
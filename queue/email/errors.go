@@ -0,0 +1,38 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+)
+
+// SendError is returned by the providers when the email was refused. Permanent
+// errors will fail again if the email is sent as is, i.e. an invalid recipient
+// or API key, the others can be retried later, i.e. rate limits and outages.
+type SendError struct {
+	Provider string
+	// StatusCode is the HTTP status or the SMTP reply code.
+	StatusCode int
+	Message    string
+	Permanent  bool
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// IsPermanent reports whether err is a permanent SendError.
+func IsPermanent(err error) bool {
+	var se *SendError
+	return errors.As(err, &se) && se.Permanent
+}
+
+// smtpError returns a permanent SendError for the negative completion replies
+// (5xx) of an SMTP server, the other errors are returned as is.
+func smtpError(err error) error {
+	var te *textproto.Error
+	if errors.As(err, &te) && te.Code >= 500 {
+		return &SendError{Provider: "smtp", StatusCode: te.Code, Message: te.Msg, Permanent: true}
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package email
+
+import (
+	"io"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+// httpError returns the SendError of a failed API call. Client errors are
+// permanent except timeouts and rate limits.
+func httpError(provider string, res *http.Response, message string) *SendError {
+	code := res.StatusCode
+	permanent := code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+	if len(message) == 0 {
+		message = http.StatusText(code)
+	}
+	return &SendError{Provider: provider, StatusCode: code, Message: message, Permanent: permanent}
+}
+
+// readBody reads at most 64KB of an API response.
+func readBody(res *http.Response) []byte {
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	return b
+}
+
+// address formats an email address with its optional name.
+func address(email, name string) string {
+	if len(name) == 0 {
+		return email
+	}
+	return (&mail.Address{Name: name, Address: email}).String()
+}
+
+// client returns c or an http.Client with a timeout.
+func client(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
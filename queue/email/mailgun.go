@@ -0,0 +1,96 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// Mailgun sends the emails via the Mailgun API.
+//
+// The Metadata are sent as custom variables (v:key), they are included in the
+// event webhooks.
+type Mailgun struct {
+	config.MailgunConfig
+
+	Client *http.Client
+}
+
+// NewMailgun returns a Mailgun provider for the domain.
+func NewMailgun(c config.MailgunConfig) *Mailgun {
+	return &Mailgun{MailgunConfig: c}
+}
+
+// Send sends the email via the Mailgun API.
+func (mg *Mailgun) Send(m Message) error {
+	_, err := mg.SendMessage(m)
+	return err
+}
+
+// SendMessage sends the email and returns its Mailgun message ID, without the
+// angle brackets like in the webhook events.
+func (mg *Mailgun) SendMessage(m Message) (string, error) {
+	if len(mg.Domain) == 0 || len(mg.APIKey) == 0 {
+		return "", errors.New("the Mailgun domain and API key are not configured")
+	}
+
+	form := url.Values{}
+	form.Set("from", address(m.From, m.FromName))
+	form.Set("to", address(m.To, m.ToName))
+	form.Set("subject", m.Subject)
+	if len(m.Text) > 0 {
+		form.Set("text", m.Text)
+	}
+	if len(m.HTML) > 0 {
+		form.Set("html", m.HTML)
+	}
+	if len(m.ReplyTo) > 0 {
+		form.Set("h:Reply-To", m.ReplyTo)
+	}
+	for _, tag := range m.Tags {
+		form.Add("o:tag", tag)
+	}
+	for k, v := range m.Metadata {
+		form.Set("v:"+k, v)
+	}
+	for k, v := range m.Headers {
+		form.Set("h:"+k, v)
+	}
+
+	base := strings.TrimSuffix(mg.BaseURL, "/")
+	if len(base) == 0 {
+		base = "https://api.mailgun.net"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, base+"/v3/"+url.PathEscape(mg.Domain)+"/messages", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("api", mg.APIKey)
+
+	res, err := client(mg.Client).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body := readBody(res)
+
+	var mr struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &mr); err != nil {
+		mr.Message = strings.TrimSpace(string(body))
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return strings.Trim(mr.ID, "<>"), nil
+	}
+	return "", httpError("mailgun", res, mr.Message)
+}
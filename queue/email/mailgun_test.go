@@ -0,0 +1,86 @@
+package email
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+func TestMailgun_Send(t *testing.T) {
+	var got *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "api" || pass != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Forbidden"))
+			return
+		}
+		if r.URL.Path != "/v3/mg.test.com/messages" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Domain not found"}`))
+			return
+		}
+		r.ParseForm()
+		got = r
+		w.Write([]byte(`{"id":"<abc@mg.test.com>","message":"Queued. Thank you."}`))
+	}))
+	defer ts.Close()
+
+	p := NewMailgun(config.MailgunConfig{Domain: "mg.test.com", APIKey: "key", BaseURL: ts.URL})
+
+	m := testMessage()
+	m.ReplyTo = "support@test.com"
+	m.Tags = []string{"welcome", "signup"}
+	m.Metadata = map[string]string{"user": "42"}
+	m.Headers = map[string]string{"X-Test": "1"}
+	id, err := p.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	} else if id != "abc@mg.test.com" {
+		t.Errorf("expected the message ID abc@mg.test.com, got %q", id)
+	}
+	f := got.PostForm
+	if f.Get("h:X-Test") != "1" {
+		t.Errorf("the headers are missing from %v", f)
+	}
+	if f.Get("to") != `"You" <you@test.com>` || f.Get("h:Reply-To") != m.ReplyTo || len(f["o:tag"]) != 2 ||
+		f.Get("v:user") != "42" || f.Get("html") != m.HTML || f.Get("text") != m.Text {
+		t.Errorf("unexpected request %v", f)
+	}
+
+	p.APIKey = "wrong"
+	if err := p.Send(m); !IsPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+
+	p.APIKey, p.Domain = "key", "unknown.com"
+	if err := p.Send(m); !IsPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestMailgun_Errors(t *testing.T) {
+	for status, permanent := range map[int]bool{
+		http.StatusBadRequest:          true,
+		http.StatusTooManyRequests:     false,
+		http.StatusServiceUnavailable:  false,
+		http.StatusInternalServerError: false,
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"message":"failed"}`))
+		}))
+
+		p := NewMailgun(config.MailgunConfig{Domain: "mg.test.com", APIKey: "key", BaseURL: ts.URL})
+		err := p.Send(testMessage())
+		if err == nil || IsPermanent(err) != permanent {
+			t.Errorf("%d: expected permanent=%v, got %v", status, permanent, err)
+		}
+		ts.Close()
+	}
+
+	if err := NewMailgun(config.MailgunConfig{}).Send(testMessage()); err == nil {
+		t.Error("expected an error without domain and API key")
+	}
+}
@@ -0,0 +1,121 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// Postmark sends the emails via the Postmark API.
+//
+// Postmark supports a single tag per email, the first of Message.Tags is used.
+type Postmark struct {
+	config.PostmarkConfig
+
+	// BaseURL defaults to https://api.postmarkapp.com.
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewPostmark returns a Postmark provider for the server.
+func NewPostmark(c config.PostmarkConfig) *Postmark {
+	return &Postmark{PostmarkConfig: c}
+}
+
+type postmarkEmail struct {
+	From          string            `json:"From"`
+	To            string            `json:"To"`
+	ReplyTo       string            `json:"ReplyTo,omitempty"`
+	Subject       string            `json:"Subject"`
+	HTMLBody      string            `json:"HtmlBody,omitempty"`
+	TextBody      string            `json:"TextBody,omitempty"`
+	Tag           string            `json:"Tag,omitempty"`
+	Metadata      map[string]string `json:"Metadata,omitempty"`
+	Headers       []postmarkHeader  `json:"Headers,omitempty"`
+	MessageStream string            `json:"MessageStream,omitempty"`
+}
+
+type postmarkHeader struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type postmarkResponse struct {
+	ErrorCode int    `json:"ErrorCode"`
+	Message   string `json:"Message"`
+	MessageID string `json:"MessageID"`
+}
+
+// postmarkRetryable are the API error codes of a 422 response worth retrying.
+// 405 is "not allowed to send", i.e. the account ran out of credits.
+var postmarkRetryable = map[int]bool{405: true}
+
+// Send sends the email via the Postmark API.
+func (p *Postmark) Send(m Message) error {
+	_, err := p.SendMessage(m)
+	return err
+}
+
+// SendMessage sends the email and returns its Postmark MessageID.
+func (p *Postmark) SendMessage(m Message) (string, error) {
+	if len(p.ServerToken) == 0 {
+		return "", errors.New("the Postmark server token is not configured")
+	}
+
+	e := postmarkEmail{
+		From:          address(m.From, m.FromName),
+		To:            address(m.To, m.ToName),
+		ReplyTo:       m.ReplyTo,
+		Subject:       m.Subject,
+		HTMLBody:      m.HTML,
+		TextBody:      m.Text,
+		Metadata:      m.Metadata,
+		MessageStream: p.MessageStream,
+	}
+	if len(m.Tags) > 0 {
+		e.Tag = m.Tags[0]
+	}
+	for k, v := range m.Headers {
+		e.Headers = append(e.Headers, postmarkHeader{Name: k, Value: v})
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	base := p.BaseURL
+	if len(base) == 0 {
+		base = "https://api.postmarkapp.com"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, base+"/email", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Postmark-Server-Token", p.ServerToken)
+
+	res, err := client(p.Client).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var pr postmarkResponse
+	json.Unmarshal(readBody(res), &pr)
+
+	if res.StatusCode == http.StatusOK && pr.ErrorCode == 0 {
+		return pr.MessageID, nil
+	}
+
+	se := httpError("postmark", res, pr.Message)
+	if res.StatusCode == http.StatusUnprocessableEntity && postmarkRetryable[pr.ErrorCode] {
+		se.Permanent = false
+	}
+	return "", se
+}
@@ -0,0 +1,83 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+func TestPostmark_Send(t *testing.T) {
+	var got postmarkEmail
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/email" || r.Header.Get("X-Postmark-Server-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"ErrorCode":10,"Message":"bad token"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"ErrorCode":0,"Message":"OK","MessageID":"abc"}`))
+	}))
+	defer ts.Close()
+
+	p := NewPostmark(config.PostmarkConfig{ServerToken: "token", MessageStream: "outbound"})
+	p.BaseURL = ts.URL
+
+	m := testMessage()
+	m.ReplyTo = "support@test.com"
+	m.Tags = []string{"welcome", "ignored"}
+	m.Metadata = map[string]string{"user": "42"}
+	m.Headers = map[string]string{"X-Test": "1"}
+	id, err := p.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	} else if id != "abc" {
+		t.Errorf("expected the message ID abc, got %q", id)
+	}
+	if len(got.Headers) != 1 || got.Headers[0].Name != "X-Test" {
+		t.Errorf("the headers are missing from %+v", got)
+	}
+	if got.To != `"You" <you@test.com>` || got.ReplyTo != m.ReplyTo || got.Tag != "welcome" ||
+		got.Metadata["user"] != "42" || got.MessageStream != "outbound" || got.HTMLBody != m.HTML || got.TextBody != m.Text {
+		t.Errorf("unexpected request %+v", got)
+	}
+
+	p.ServerToken = "wrong"
+	if err := p.Send(m); !IsPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestPostmark_Errors(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		permanent bool
+	}{
+		{http.StatusUnprocessableEntity, `{"ErrorCode":300,"Message":"Invalid email request"}`, true},
+		{http.StatusUnprocessableEntity, `{"ErrorCode":406,"Message":"Inactive recipient"}`, true},
+		{http.StatusUnprocessableEntity, `{"ErrorCode":405,"Message":"Not allowed to send"}`, false},
+		{http.StatusTooManyRequests, ``, false},
+		{http.StatusInternalServerError, ``, false},
+	}
+	for _, tc := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			w.Write([]byte(tc.body))
+		}))
+
+		p := NewPostmark(config.PostmarkConfig{ServerToken: "token"})
+		p.BaseURL = ts.URL
+		err := p.Send(testMessage())
+		if err == nil || IsPermanent(err) != tc.permanent {
+			t.Errorf("%d %s: expected permanent=%v, got %v", tc.status, tc.body, tc.permanent, err)
+		}
+		ts.Close()
+	}
+
+	if err := NewPostmark(config.PostmarkConfig{}).Send(testMessage()); err == nil {
+		t.Error("expected an error without server token")
+	}
+}
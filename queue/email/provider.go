@@ -0,0 +1,114 @@
+package email
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// Provider sends emails.
+type Provider interface {
+	Send(m Message) error
+}
+
+// MessageIDSender is implemented by the providers returning the ID they
+// assigned to the email, their delivery events refer to it.
+type MessageIDSender interface {
+	SendMessage(m Message) (string, error)
+}
+
+// Deliver sends the email via p and returns the provider's message ID, it is
+// empty when p does not report one.
+func Deliver(p Provider, m Message) (string, error) {
+	if s, ok := p.(MessageIDSender); ok {
+		return s.SendMessage(m)
+	}
+	return "", p.Send(m)
+}
+
+// ProviderFunc is a function sending emails.
+type ProviderFunc func(m Message) error
+
+// Send calls f(m).
+func (f ProviderFunc) Send(m Message) error {
+	return f(m)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+func init() {
+	Register(string(config.EmailProviderSES), AmazonSES{})
+	Register(string(config.EmailProviderGoogle), Gmail{})
+	Register(string(config.EmailProviderCatcher), &Catcher{})
+
+	// built from the configuration when sending so it can change after init
+	Register(string(config.EmailProviderSMTP), fromConfig(func() Provider {
+		return NewSMTP(config.Current.SMTP)
+	}))
+	Register(string(config.EmailProviderPostmark), fromConfig(func() Provider {
+		return NewPostmark(config.Current.Postmark)
+	}))
+	Register(string(config.EmailProviderMailgun), fromConfig(func() Provider {
+		return NewMailgun(config.Current.Mailgun)
+	}))
+	Register(string(config.EmailProviderSendGrid), fromConfig(func() Provider {
+		return NewSendGrid(config.Current.SendGrid)
+	}))
+}
+
+// fromConfig builds the provider from the configuration for each email.
+type fromConfig func() Provider
+
+func (f fromConfig) Send(m Message) error {
+	return f().Send(m)
+}
+
+func (f fromConfig) SendMessage(m Message) (string, error) {
+	return Deliver(f(), m)
+}
+
+// Register makes a provider available by name, the emailProvider configuration
+// selects which one sends the emails. Registering a name twice replaces the
+// previous provider.
+//
+//	email.Register("mailhog", email.NewSMTP(config.SMTPConfig{Host: "localhost", Port: 1025, Security: "none"}))
+func Register(name string, p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if p == nil {
+		delete(providers, name)
+		return
+	}
+	providers[name] = p
+}
+
+// Get returns the provider registered by name.
+func Get(name string) (Provider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("cannot find email provider named: %s", name)
+	}
+	return p, nil
+}
+
+// Providers returns the names of the registered providers.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
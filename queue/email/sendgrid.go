@@ -0,0 +1,126 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// SendGrid sends the emails via the SendGrid v3 API.
+//
+// The Tags are sent as categories and the Metadata as custom arguments.
+type SendGrid struct {
+	config.SendGridConfig
+
+	// BaseURL defaults to https://api.sendgrid.com.
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewSendGrid returns a SendGrid provider using the API key.
+func NewSendGrid(c config.SendGridConfig) *SendGrid {
+	return &SendGrid{SendGridConfig: c}
+}
+
+type sendgridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendgridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendgridEmail struct {
+	Personalizations []struct {
+		To []sendgridAddress `json:"to"`
+	} `json:"personalizations"`
+	From       sendgridAddress   `json:"from"`
+	ReplyTo    *sendgridAddress  `json:"reply_to,omitempty"`
+	Subject    string            `json:"subject"`
+	Content    []sendgridContent `json:"content"`
+	Categories []string          `json:"categories,omitempty"`
+	CustomArgs map[string]string `json:"custom_args,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// Send sends the email via the SendGrid API.
+func (sg *SendGrid) Send(m Message) error {
+	_, err := sg.SendMessage(m)
+	return err
+}
+
+// SendMessage sends the email and returns the X-Message-Id SendGrid assigned.
+func (sg *SendGrid) SendMessage(m Message) (string, error) {
+	if len(sg.APIKey) == 0 {
+		return "", errors.New("the SendGrid API key is not configured")
+	}
+
+	e := sendgridEmail{
+		From:       sendgridAddress{Email: m.From, Name: m.FromName},
+		Subject:    m.Subject,
+		Categories: m.Tags,
+		CustomArgs: m.Metadata,
+		Headers:    m.Headers,
+	}
+	e.Personalizations = make([]struct {
+		To []sendgridAddress `json:"to"`
+	}, 1)
+	e.Personalizations[0].To = []sendgridAddress{{Email: m.To, Name: m.ToName}}
+	if len(m.ReplyTo) > 0 {
+		e.ReplyTo = &sendgridAddress{Email: m.ReplyTo}
+	}
+	// the text part must come first
+	if len(m.Text) > 0 {
+		e.Content = append(e.Content, sendgridContent{Type: "text/plain", Value: m.Text})
+	}
+	if len(m.HTML) > 0 {
+		e.Content = append(e.Content, sendgridContent{Type: "text/html", Value: m.HTML})
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	base := sg.BaseURL
+	if len(base) == 0 {
+		base = "https://api.sendgrid.com"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, base+"/v3/mail/send", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+sg.APIKey)
+
+	res, err := client(sg.Client).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body := readBody(res)
+	if res.StatusCode == http.StatusAccepted || res.StatusCode == http.StatusOK {
+		return res.Header.Get("X-Message-Id"), nil
+	}
+
+	var sr struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	json.Unmarshal(body, &sr)
+
+	var msgs []string
+	for _, e := range sr.Errors {
+		msgs = append(msgs, e.Message)
+	}
+	return "", httpError("sendgrid", res, strings.Join(msgs, ", "))
+}
@@ -0,0 +1,80 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+func TestSendGrid_Send(t *testing.T) {
+	var got sendgridEmail
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/mail/send" || r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors":[{"message":"The provided authorization grant is invalid"}]}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("X-Message-Id", "abc")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	p := NewSendGrid(config.SendGridConfig{APIKey: "key"})
+	p.BaseURL = ts.URL
+
+	m := testMessage()
+	m.ReplyTo = "support@test.com"
+	m.Tags = []string{"welcome"}
+	m.Metadata = map[string]string{"user": "42"}
+	m.Headers = map[string]string{"X-Test": "1"}
+	id, err := p.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	} else if id != "abc" {
+		t.Errorf("expected the message ID abc, got %q", id)
+	}
+	if got.Headers["X-Test"] != "1" {
+		t.Errorf("the headers are missing from %+v", got)
+	}
+	if len(got.Personalizations) != 1 || got.Personalizations[0].To[0].Email != m.To || got.ReplyTo == nil ||
+		got.ReplyTo.Email != m.ReplyTo || got.Categories[0] != "welcome" || got.CustomArgs["user"] != "42" {
+		t.Errorf("unexpected request %+v", got)
+	}
+	if len(got.Content) != 2 || got.Content[0].Type != "text/plain" || got.Content[1].Type != "text/html" {
+		t.Errorf("unexpected content %+v", got.Content)
+	}
+
+	p.APIKey = "wrong"
+	if err := p.Send(m); !IsPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestSendGrid_Errors(t *testing.T) {
+	for status, permanent := range map[int]bool{
+		http.StatusBadRequest:          true,
+		http.StatusForbidden:           true,
+		http.StatusRequestTimeout:      false,
+		http.StatusTooManyRequests:     false,
+		http.StatusInternalServerError: false,
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"errors":[{"message":"failed"}]}`))
+		}))
+
+		p := NewSendGrid(config.SendGridConfig{APIKey: "key"})
+		p.BaseURL = ts.URL
+		err := p.Send(testMessage())
+		if err == nil || IsPermanent(err) != permanent {
+			t.Errorf("%d: expected permanent=%v, got %v", status, permanent, err)
+		} else if status == http.StatusBadRequest && err.Error() != "sendgrid: 400 failed" {
+			t.Errorf("unexpected error message %v", err)
+		}
+		ts.Close()
+	}
+}
@@ -0,0 +1,207 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// SMTP security modes.
+const (
+	SecurityStartTLS = "starttls"
+	SecurityTLS      = "tls"
+	SecurityNone     = "none"
+)
+
+// SMTP authentication mechanisms.
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+)
+
+// SMTP sends the emails via an SMTP server.
+type SMTP struct {
+	config.SMTPConfig
+
+	// TLSConfig is used for STARTTLS and implicit TLS, the ServerName defaults
+	// to Host.
+	TLSConfig *tls.Config
+	// Timeout limits the whole exchange with the server, 30 seconds by default.
+	Timeout time.Duration
+}
+
+// NewSMTP returns an SMTP provider for the server.
+func NewSMTP(c config.SMTPConfig) *SMTP {
+	return &SMTP{SMTPConfig: c}
+}
+
+// Gmail sends the emails via the Gmail SMTP server, authenticated with the
+// EmailLogin and EmailPassword configuration.
+type Gmail struct{}
+
+// Send uses GMail SMTP to send the email.
+func (g Gmail) Send(m Message) error {
+	return NewSMTP(config.SMTPConfig{
+		Host:     "smtp.gmail.com",
+		Port:     587,
+		Security: SecurityStartTLS,
+		Username: config.Current.EmailLogin,
+		Password: config.Current.EmailPassword,
+	}).Send(m)
+}
+
+func (s *SMTP) addr() string {
+	port := s.Port
+	if port == 0 {
+		port = 587
+		if s.Security == SecurityTLS {
+			port = 465
+		}
+	}
+	return net.JoinHostPort(s.Host, strconv.Itoa(port))
+}
+
+func (s *SMTP) tlsConfig() *tls.Config {
+	if s.TLSConfig != nil {
+		c := s.TLSConfig.Clone()
+		if len(c.ServerName) == 0 {
+			c.ServerName = s.Host
+		}
+		return c
+	}
+	return &tls.Config{ServerName: s.Host}
+}
+
+func (s *SMTP) auth() (smtp.Auth, error) {
+	switch strings.ToLower(s.Auth) {
+	case "", AuthPlain:
+		return smtp.PlainAuth("", s.Username, s.Password, s.Host), nil
+	case AuthLogin:
+		return &loginAuth{username: s.Username, password: s.Password}, nil
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(s.Username, s.Password), nil
+	}
+	return nil, fmt.Errorf("unsupported SMTP auth mechanism: %s", s.Auth)
+}
+
+// Send delivers the email to the SMTP server.
+func (s *SMTP) Send(m Message) error {
+	if len(s.Host) == 0 {
+		return errors.New("the SMTP host is not configured")
+	}
+
+	msg, err := build(m)
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if s.Security == SecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr(), s.tlsConfig())
+	} else {
+		conn, err = dialer.Dial("tcp", s.addr())
+	}
+	if err != nil {
+		return fmt.Errorf("unable to connect to the SMTP server: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	return smtpError(s.deliver(c, m, msg))
+}
+
+// deliver sends the message over the connection to the server.
+func (s *SMTP) deliver(c *smtp.Client, m Message, msg []byte) error {
+	switch s.Security {
+	case "", SecurityStartTLS:
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("the SMTP server does not support STARTTLS")
+		}
+		if err := c.StartTLS(s.tlsConfig()); err != nil {
+			return err
+		}
+	case SecurityTLS, SecurityNone:
+	default:
+		return fmt.Errorf("unsupported SMTP security: %s", s.Security)
+	}
+
+	if len(s.Username) > 0 {
+		a, err := s.auth()
+		if err != nil {
+			return err
+		}
+		if err := c.Auth(a); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// loginAuth implements the LOGIN mechanism, only over TLS or to localhost
+// like smtp.PlainAuth.
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge: %s", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,308 @@
+package email
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// smtpServer is an in-process SMTP server recording the received emails.
+type smtpServer struct {
+	ln       net.Listener
+	tls      *tls.Config
+	startTLS bool
+	rejectTo string
+
+	mu     sync.Mutex
+	auth   string
+	from   string
+	to     string
+	data   string
+	secure bool
+}
+
+func newCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newSMTPServer starts a server, implicit TLS when implicit is true otherwise
+// offering STARTTLS.
+func newSMTPServer(t *testing.T, implicit bool) (*smtpServer, *tls.Config) {
+	cfg := &tls.Config{Certificates: []tls.Certificate{newCertificate(t)}}
+
+	var (
+		ln  net.Listener
+		err error
+	)
+	if implicit {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", cfg)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &smtpServer{ln: ln, tls: cfg, startTLS: !implicit, secure: implicit}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+
+	return s, &tls.Config{InsecureSkipVerify: true}
+}
+
+func (s *smtpServer) config(security string) config.SMTPConfig {
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return config.SMTPConfig{Host: "localhost", Port: p, Security: security}
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP test")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := strings.TrimSpace(strings.TrimPrefix(line, strings.SplitN(line, " ", 2)[0]))
+
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			if s.startTLS {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN LOGIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 ready")
+			tc := tls.Server(conn, s.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			conn = tc
+			tp = textproto.NewConn(conn)
+			s.mu.Lock()
+			s.secure = true
+			s.mu.Unlock()
+		case "AUTH":
+			fields := strings.Fields(arg)
+			var user, pass string
+			if strings.EqualFold(fields[0], "PLAIN") {
+				b, _ := base64.StdEncoding.DecodeString(fields[1])
+				parts := strings.Split(string(b), "\x00")
+				user, pass = parts[1], parts[2]
+			} else {
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				l, _ := tp.ReadLine()
+				b, _ := base64.StdEncoding.DecodeString(l)
+				user = string(b)
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				l, _ = tp.ReadLine()
+				b, _ = base64.StdEncoding.DecodeString(l)
+				pass = string(b)
+			}
+			if pass != "secret" {
+				tp.PrintfLine("535 authentication failed")
+				continue
+			}
+			s.mu.Lock()
+			s.auth = strings.ToLower(fields[0]) + ":" + user
+			s.mu.Unlock()
+			tp.PrintfLine("235 ok")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = arg
+			s.mu.Unlock()
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			if len(s.rejectTo) > 0 && strings.Contains(arg, s.rejectTo) {
+				tp.PrintfLine("550 mailbox unavailable")
+				continue
+			}
+			s.mu.Lock()
+			s.to = arg
+			s.mu.Unlock()
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			b, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = string(b)
+			s.mu.Unlock()
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func testMessage() Message {
+	return Message{
+		From:    "me@test.com",
+		To:      "you@test.com",
+		ToName:  "You",
+		Subject: "Hello",
+		HTML:    "<p>Hello</p>",
+		Text:    "Hello",
+	}
+}
+
+func TestSMTP_StartTLSAndAuth(t *testing.T) {
+	for _, auth := range []string{AuthPlain, AuthLogin} {
+		s, cfg := newSMTPServer(t, false)
+
+		c := s.config(SecurityStartTLS)
+		c.Username, c.Password, c.Auth = "me", "secret", auth
+		p := NewSMTP(c)
+		p.TLSConfig = cfg
+
+		if err := p.Send(testMessage()); err != nil {
+			t.Fatal(auth, err)
+		}
+
+		s.mu.Lock()
+		if !s.secure || s.auth != auth+":me" || s.from != "FROM:<me@test.com>" || s.to != "TO:<you@test.com>" {
+			t.Errorf("%s: unexpected session %+v", auth, s)
+		}
+		if !strings.Contains(s.data, "Subject: Hello") || !strings.Contains(s.data, "text/plain") || !strings.Contains(s.data, "text/html") {
+			t.Errorf("%s: unexpected data %s", auth, s.data)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestSMTP_ImplicitTLS(t *testing.T) {
+	s, cfg := newSMTPServer(t, true)
+
+	p := NewSMTP(s.config(SecurityTLS))
+	p.TLSConfig = cfg
+	if err := p.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.auth) > 0 || !strings.Contains(s.data, "Hello") {
+		t.Errorf("unexpected session %+v", s)
+	}
+}
+
+func TestSMTP_Errors(t *testing.T) {
+	s, cfg := newSMTPServer(t, false)
+	s.rejectTo = "you@"
+
+	c := s.config(SecurityStartTLS)
+	c.Username, c.Password = "me", "wrong"
+	p := NewSMTP(c)
+	p.TLSConfig = cfg
+	if err := p.Send(testMessage()); err == nil || !strings.Contains(err.Error(), "535") {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+
+	p.Password = "secret"
+	if err := p.Send(testMessage()); err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("expected a rejected recipient, got %v", err)
+	} else if !IsPermanent(err) {
+		t.Errorf("a rejected recipient should be permanent, got %v", err)
+	}
+
+	// speaking in clear to an implicit TLS server fails within the timeout
+	tlsServer, _ := newSMTPServer(t, true)
+	p = NewSMTP(tlsServer.config(SecurityNone))
+	p.Timeout = time.Second
+	if err := p.Send(testMessage()); err == nil {
+		t.Error("expected an error speaking in clear to a TLS server")
+	}
+
+	if err := NewSMTP(config.SMTPConfig{}).Send(testMessage()); err == nil {
+		t.Error("expected an error without host")
+	}
+
+	// port 1 is not listening, the error is returned instead of exiting
+	if err := NewSMTP(config.SMTPConfig{Host: "127.0.0.1", Port: 1}).Send(testMessage()); err == nil {
+		t.Error("expected a connection error")
+	}
+}
+
+func TestProvider_Registry(t *testing.T) {
+	if _, err := Get("unknown"); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+
+	for _, name := range []string{"amazonses", "google", "smtp", "postmark", "mailgun", "sendgrid"} {
+		if _, err := Get(name); err != nil {
+			t.Errorf("%s should be registered: %v", name, err)
+		}
+	}
+
+	var sent Message
+	Register("test", ProviderFunc(func(m Message) error {
+		sent = m
+		return nil
+	}))
+	defer Register("test", nil)
+
+	p, err := Get("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := Deliver(p, testMessage()); err != nil || len(id) > 0 {
+		t.Errorf("expected no message ID, got %q %v", id, err)
+	}
+	if sent.To != "you@test.com" {
+		t.Errorf("unexpected message %+v", sent)
+	}
+
+	// the providers built from the configuration report their message ID
+	p, _ = Get("postmark")
+	if _, ok := p.(MessageIDSender); !ok {
+		t.Error("postmark should return the message ID")
+	}
+}
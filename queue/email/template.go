@@ -0,0 +1,226 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// The built-in email templates.
+const (
+	TemplateWelcome         = "welcome"
+	TemplateVerify          = "verify"
+	TemplateReset           = "reset"
+	TemplateInvite          = "invite"
+	TemplateReceipt         = "receipt"
+	TemplatePaymentFailed   = "payment-failed"
+	TemplatePaymentReminder = "payment-reminder"
+	TemplateRefund          = "refund"
+	TemplateTrialEnding     = "trial-ending"
+	TemplateTrialEnded      = "trial-ended"
+	TemplateCanceled        = "canceled"
+)
+
+// TemplateDir is the directory holding the app's email templates, they take
+// precedence over the built-in ones with the same file name.
+//
+// An email is made of a name.html and a name.txt file rendered inside the
+// layout.html and layout.txt files. The text file defines the subject and both
+// define the content:
+//
+//	{{define "subject"}}{{translate .Language "email-welcome-subject"}}{{end}}
+//	{{define "content"}}Hello {{.Data.Name}}{{end}}
+//
+// A file for a specific language, i.e. welcome.fr.html, is used over welcome.html
+// for the recipients of that language. The layout links to Data.UnsubscribeURL
+// when it is set.
+var TemplateDir = "./emails"
+
+//go:embed templates
+var builtin embed.FS
+
+// Message is an email, Render sets its Subject, HTML and Text parts.
+type Message struct {
+	From     string
+	FromName string
+	To       string
+	ToName   string
+	ReplyTo  string
+	Subject  string
+	HTML     string
+	Text     string
+	// Tags and Metadata are sent to the providers supporting them, they are
+	// available in their dashboards and event webhooks.
+	Tags     []string
+	Metadata map[string]string
+	// Headers are added to the email, i.e. List-Unsubscribe.
+	Headers map[string]string
+}
+
+// View is the data available in the email templates.
+type View struct {
+	Language string
+	AppName  string
+	AppURL   string
+	Data     map[string]interface{}
+}
+
+// Translator returns the value of a language pack key in the language lng.
+type Translator func(lng, key string, a ...interface{}) string
+
+var (
+	translatorMu sync.RWMutex
+	translator   Translator = func(lng, key string, a ...interface{}) string { return key }
+)
+
+// SetTranslator sets the function translating the language pack keys used in
+// the templates, the gosaas package sets it to use its languagepacks.
+func SetTranslator(t Translator) {
+	translatorMu.Lock()
+	defer translatorMu.Unlock()
+	translator = t
+}
+
+func translate(lng, key string, a ...interface{}) string {
+	translatorMu.RLock()
+	defer translatorMu.RUnlock()
+	return translator(lng, key, a...)
+}
+
+// money formats an amount in cents, JSON decoded payloads hold them as float64.
+func money(amount interface{}) string {
+	var cents float64
+	switch v := amount.(type) {
+	case int:
+		cents = float64(v)
+	case int64:
+		cents = float64(v)
+	case float64:
+		cents = v
+	}
+	return fmt.Sprintf("%.2f $", cents/100.0)
+}
+
+// readTemplate returns the content of the file for the language, falling back to
+// the file without language, from TemplateDir or the built-in templates.
+func readTemplate(name, lng, ext string) (string, error) {
+	files := []string{name + "." + ext}
+	if len(lng) > 0 {
+		files = append([]string{name + "." + lng + "." + ext}, files...)
+	}
+
+	sources := []fs.FS{os.DirFS(TemplateDir), builtin}
+	for _, f := range files {
+		for i, src := range sources {
+			p := f
+			if i == 1 {
+				p = "templates/" + f
+			}
+
+			b, err := fs.ReadFile(src, p)
+			if err == nil {
+				return string(b), nil
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+	}
+	return "", fmt.Errorf("email template %s.%s not found", name, ext)
+}
+
+// Render renders the template name in the language lng with its data.
+func Render(name, lng string, data map[string]interface{}) (*Message, error) {
+	v := View{
+		Language: lng,
+		AppName:  config.Current.AppName,
+		AppURL:   strings.TrimSuffix(config.Current.AppURL, "/"),
+		Data:     data,
+	}
+
+	text, subject, err := renderText(name, lng, v)
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := renderHTML(name, lng, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{Subject: subject, HTML: html, Text: text}, nil
+}
+
+func renderText(name, lng string, v View) (string, string, error) {
+	layout, err := readTemplate("layout", lng, "txt")
+	if err != nil {
+		return "", "", err
+	}
+	content, err := readTemplate(name, lng, "txt")
+	if err != nil {
+		return "", "", err
+	}
+
+	t, err := template.New("layout").Funcs(template.FuncMap{
+		"translate":  translate,
+		"translatef": translate,
+		"money":      money,
+	}).Parse(layout)
+	if err == nil {
+		_, err = t.Parse(content)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("unable to parse the email template %s: %v", name, err)
+	}
+
+	subject := new(bytes.Buffer)
+	if err := t.ExecuteTemplate(subject, "subject", v); err != nil {
+		return "", "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, v); err != nil {
+		return "", "", err
+	}
+	return buf.String(), strings.TrimSpace(subject.String()), nil
+}
+
+func renderHTML(name, lng string, v View) (string, error) {
+	layout, err := readTemplate("layout", lng, "html")
+	if err != nil {
+		return "", err
+	}
+	content, err := readTemplate(name, lng, "html")
+	if err != nil {
+		return "", err
+	}
+
+	// the language packs are trusted to hold HTML, like in the page templates
+	t, err := htmltemplate.New("layout").Funcs(htmltemplate.FuncMap{
+		"translate": func(lng, key string) htmltemplate.HTML {
+			return htmltemplate.HTML(translate(lng, key))
+		},
+		"translatef": translate,
+		"money":      money,
+	}).Parse(layout)
+	if err == nil {
+		_, err = t.Parse(content)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to parse the email template %s: %v", name, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, v); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,136 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplate_RenderBuiltin(t *testing.T) {
+	SetTranslator(func(lng, key string, a ...interface{}) string {
+		if key == "email-reset-subject" {
+			return lng + " reset"
+		}
+		return key
+	})
+	defer SetTranslator(func(lng, key string, a ...interface{}) string { return key })
+
+	m, err := Render(TemplateReset, "fr", map[string]interface{}{"URL": "https://app.test/reset?token=a&b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Subject != "fr reset" {
+		t.Errorf("got subject %q", m.Subject)
+	}
+	if !strings.Contains(m.HTML, `href="https://app.test/reset?token=a&amp;b"`) || !strings.Contains(m.HTML, `<html lang="fr">`) {
+		t.Errorf("unexpected HTML part %s", m.HTML)
+	}
+	if !strings.Contains(m.Text, "https://app.test/reset?token=a&b") || strings.Contains(m.Text, "<") {
+		t.Errorf("unexpected text part %s", m.Text)
+	}
+}
+
+func TestTemplate_ReceiptAmount(t *testing.T) {
+	// payloads decoded from the queue hold numbers as float64
+	m, err := Render(TemplateReceipt, "en", map[string]interface{}{"Plan": "pro", "Amount": float64(1999)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.Text, "19.99 $") || !strings.Contains(m.HTML, "19.99 $") {
+		t.Errorf("amount not formatted\n%s\n%s", m.Text, m.HTML)
+	}
+}
+
+func TestTemplate_BillingTemplates(t *testing.T) {
+	data := map[string]interface{}{
+		"Amount":      float64(2500),
+		"Date":        "January 2, 2020",
+		"NextAttempt": "January 5, 2020",
+		"Since":       "January 2, 2020",
+		"DowngradeOn": "January 16, 2020",
+		"Plan":        "pro",
+		"URL":         "https://app.test/billing",
+	}
+	for _, name := range []string{TemplatePaymentFailed, TemplatePaymentReminder, TemplateRefund, TemplateTrialEnding, TemplateTrialEnded, TemplateCanceled} {
+		m, err := Render(name, "en", data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(m.Subject) == 0 || len(m.HTML) == 0 || len(m.Text) == 0 {
+			t.Errorf("%s: unexpected message %+v", name, m)
+		}
+	}
+
+	m, _ := Render(TemplatePaymentFailed, "en", data)
+	if !strings.Contains(m.Text, "email-payment-failed-retry") || !strings.Contains(m.HTML, "https://app.test/billing") {
+		t.Errorf("unexpected payment failed email\n%s\n%s", m.Text, m.HTML)
+	}
+
+	delete(data, "DowngradeOn")
+	m, _ = Render(TemplatePaymentReminder, "en", data)
+	if strings.Contains(m.Text, "email-payment-reminder-downgrade") || !strings.Contains(m.Text, "email-payment-reminder-action") {
+		t.Errorf("unexpected payment reminder email without downgrade\n%s", m.Text)
+	}
+}
+
+func TestTemplate_AppTemplatesAndLanguage(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"welcome.txt":    `{{define "subject"}}Welcome{{end}}{{define "content"}}Hello {{.Data.Name}}{{end}}`,
+		"welcome.fr.txt": `{{define "subject"}}Bienvenue{{end}}{{define "content"}}Bonjour {{.Data.Name}}{{end}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prev := TemplateDir
+	TemplateDir = dir
+	defer func() { TemplateDir = prev }()
+
+	data := map[string]interface{}{"Name": "Dom"}
+	en, err := Render(TemplateWelcome, "en", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr, err := Render(TemplateWelcome, "fr", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if en.Subject != "Welcome" || !strings.HasPrefix(en.Text, "Hello Dom") {
+		t.Errorf("unexpected english email %+v", en)
+	}
+	if fr.Subject != "Bienvenue" || !strings.HasPrefix(fr.Text, "Bonjour Dom") {
+		t.Errorf("unexpected french email %+v", fr)
+	}
+
+	// the built-in HTML part is still used
+	if !strings.Contains(fr.HTML, "email-welcome-action") {
+		t.Errorf("expected the built-in HTML part, got %s", fr.HTML)
+	}
+
+	if _, err := Render("unknown", "en", nil); err == nil {
+		t.Error("expected an error for an unknown template")
+	}
+}
+
+func TestRender_UnsubscribeLink(t *testing.T) {
+	m, err := Render(TemplateWelcome, "en", nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(m.HTML, "email-unsubscribe") || strings.Contains(m.Text, "email-unsubscribe") {
+		t.Errorf("unexpected unsubscribe link without URL %s", m.Text)
+	}
+
+	m, err = Render(TemplateWelcome, "en", map[string]interface{}{"UnsubscribeURL": "https://app.test/email/unsubscribe?token=a.b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.HTML, `href="https://app.test/email/unsubscribe?token=a.b"`) || !strings.Contains(m.Text, "https://app.test/email/unsubscribe?token=a.b") {
+		t.Errorf("expected the unsubscribe link, got %s\n%s", m.HTML, m.Text)
+	}
+}
@@ -0,0 +1,78 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// ErrInvalidUnsubscribe is returned for unsubscribe tokens that were not signed
+// with the key.
+var ErrInvalidUnsubscribe = errors.New("invalid unsubscribe token")
+
+// UnsubscribeToken returns the token of the unsubscribe link of the address
+// from the category of emails, signed with key. It does not expire, the links
+// of old emails keep working.
+func UnsubscribeToken(key, address, category string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strings.ToLower(address) + "\n" + category))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(key, payload))
+}
+
+// ParseUnsubscribeToken verifies the token and returns its address and
+// category.
+func ParseUnsubscribeToken(key, token string) (address, category string, err error) {
+	i := strings.LastIndexByte(token, '.')
+	if len(key) == 0 || i < 0 {
+		return "", "", ErrInvalidUnsubscribe
+	}
+
+	payload := token[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(sig, sign(key, payload)) {
+		return "", "", ErrInvalidUnsubscribe
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", ErrInvalidUnsubscribe
+	}
+	parts := strings.SplitN(string(b), "\n", 2)
+	if len(parts) != 2 {
+		return "", "", ErrInvalidUnsubscribe
+	}
+	return parts[0], parts[1], nil
+}
+
+func sign(key, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("unsubscribe:" + payload))
+	return mac.Sum(nil)
+}
+
+// UnsubscribeURL returns the one-click unsubscribe link of the address from the
+// category, it is empty when the AppURL or the SecretKey are not configured.
+func UnsubscribeURL(address, category string) string {
+	c := config.Current
+	if len(c.AppURL) == 0 || len(c.SecretKey) == 0 {
+		return ""
+	}
+
+	token := UnsubscribeToken(c.SecretKey, address, category)
+	return strings.TrimSuffix(c.AppURL, "/") + "/email/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+// SetUnsubscribe adds the List-Unsubscribe headers of the link, the
+// List-Unsubscribe-Post header lets the mail clients unsubscribe in one click
+// as defined by RFC 8058.
+func (m *Message) SetUnsubscribe(link string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers["List-Unsubscribe"] = "<" + link + ">"
+	m.Headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+}
@@ -0,0 +1,64 @@
+package email
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+func TestUnsubscribeToken(t *testing.T) {
+	token := UnsubscribeToken("key", "You@Test.com", "marketing")
+
+	address, category, err := ParseUnsubscribeToken("key", token)
+	if err != nil {
+		t.Fatal(err)
+	} else if address != "you@test.com" || category != "marketing" {
+		t.Errorf("unexpected address %s and category %s", address, category)
+	}
+
+	other := UnsubscribeToken("key", "you@test.com", "product")
+	forged := strings.SplitN(other, ".", 2)[0] + "." + strings.SplitN(token, ".", 2)[1]
+	for _, tok := range []string{"", "abc", token + "x", forged} {
+		if _, _, err := ParseUnsubscribeToken("key", tok); err != ErrInvalidUnsubscribe {
+			t.Errorf("%q: expected ErrInvalidUnsubscribe, got %v", tok, err)
+		}
+	}
+	if _, _, err := ParseUnsubscribeToken("other", token); err != ErrInvalidUnsubscribe {
+		t.Errorf("expected ErrInvalidUnsubscribe with another key, got %v", err)
+	}
+}
+
+func TestUnsubscribeURL(t *testing.T) {
+	defer func(c config.Configuration) { config.Current = c }(config.Current)
+
+	config.Current.AppURL, config.Current.SecretKey = "https://app.test/", ""
+	if u := UnsubscribeURL("you@test.com", "marketing"); len(u) > 0 {
+		t.Errorf("expected no link without secret key, got %s", u)
+	}
+
+	config.Current.SecretKey = "key"
+	link := UnsubscribeURL("you@test.com", "marketing")
+	u, err := url.Parse(link)
+	if err != nil || !strings.HasPrefix(link, "https://app.test/email/unsubscribe?token=") {
+		t.Fatalf("unexpected link %s", link)
+	}
+	if a, _, err := ParseUnsubscribeToken("key", u.Query().Get("token")); err != nil || a != "you@test.com" {
+		t.Errorf("unexpected token %s %v", a, err)
+	}
+
+	m := testMessage()
+	m.SetUnsubscribe(link)
+	if m.Headers["List-Unsubscribe"] != "<"+link+">" || m.Headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Errorf("unexpected headers %v", m.Headers)
+	}
+
+	// the headers are sent by the providers
+	b, err := build(m)
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(b), "List-Unsubscribe-Post: List-Unsubscribe=One-Click") {
+		t.Errorf("the headers are missing from the message %s", b)
+	}
+}
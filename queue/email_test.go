@@ -0,0 +1,208 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/queue/email"
+)
+
+func TestEmail_RenderAndSend(t *testing.T) {
+	var sent email.Message
+	e := &Email{Send: func(m email.Message) (string, error) {
+		sent = m
+		return "", nil
+	}}
+
+	p := SendEmailParameter{
+		From:     "me@test.com",
+		To:       "you@test.com",
+		Template: email.TemplateWelcome,
+		Data:     map[string]interface{}{"Name": "Dom", "URL": "https://app.test"},
+	}
+	if err := e.Run(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if sent.To != p.To || sent.From != p.From || len(sent.Subject) == 0 || len(sent.HTML) == 0 || len(sent.Text) == 0 {
+		t.Errorf("unexpected message %+v", sent)
+	}
+
+	p.Subject = "custom"
+	e.Run(context.Background(), p)
+	if sent.Subject != "custom" {
+		t.Errorf("the subject was not overridden, got %q", sent.Subject)
+	}
+
+	p.Template = "unknown"
+	if err := e.Run(context.Background(), p); !isPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestEmail_ProviderErrors(t *testing.T) {
+	var sent email.Message
+	sendErr := &email.SendError{Provider: "test", StatusCode: 422, Permanent: true}
+	e := &Email{Send: func(m email.Message) (string, error) {
+		sent = m
+		return "", sendErr
+	}}
+
+	p := SendEmailParameter{
+		From:     "me@test.com",
+		To:       "you@test.com",
+		ReplyTo:  "support@test.com",
+		Template: email.TemplateWelcome,
+		Tags:     []string{"welcome"},
+		Metadata: map[string]string{"user": "42"},
+	}
+	if err := e.Run(context.Background(), p); !isPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if sent.ReplyTo != p.ReplyTo || len(sent.Tags) != 1 || sent.Metadata["user"] != "42" {
+		t.Errorf("unexpected message %+v", sent)
+	}
+
+	sendErr.Permanent = false
+	if err := e.Run(context.Background(), p); err == nil || isPermanent(err) {
+		t.Errorf("expected a retryable error, got %v", err)
+	}
+}
+
+// testOutbox records the emails in memory.
+type testOutbox struct {
+	emails     map[string]model.Email
+	suppressed map[string]bool
+}
+
+func (o *testOutbox) Record(e *model.Email) error {
+	o.emails[e.TaskID] = *e
+	return nil
+}
+
+func (o *testOutbox) IsSuppressed(email string) (bool, error) {
+	return o.suppressed[email], nil
+}
+
+func TestEmail_Outbox(t *testing.T) {
+	o := &testOutbox{emails: make(map[string]model.Email), suppressed: map[string]bool{"bounced@test.com": true}}
+	SetOutbox(o)
+	defer SetOutbox(nil)
+
+	sends := 0
+	e := &Email{Provider: "test", Send: func(m email.Message) (string, error) {
+		sends++
+		if m.To == "invalid@test.com" {
+			return "", &email.SendError{Provider: "test", StatusCode: 422, Message: "invalid", Permanent: true}
+		}
+		return "msg-" + m.To, nil
+	}}
+
+	run := func(task, to string) error {
+		ctx := context.WithValue(context.Background(), taskUUIDKey, task)
+		return e.Run(ctx, SendEmailParameter{From: "me@test.com", To: to, Template: email.TemplateWelcome})
+	}
+
+	if err := run("t1", "you@test.com"); err != nil {
+		t.Fatal(err)
+	}
+	if r := o.emails["t1"]; r.Status != model.EmailSent || r.MessageID != "msg-you@test.com" || r.Provider != "test" ||
+		r.Template != email.TemplateWelcome || len(r.Subject) == 0 {
+		t.Errorf("unexpected outbox email %+v", r)
+	}
+
+	// suppressed addresses are not sent to and the task succeeds
+	if err := run("t2", "bounced@test.com"); err != nil {
+		t.Fatal(err)
+	} else if sends != 1 || o.emails["t2"].Status != model.EmailSuppressed {
+		t.Errorf("the email should have been suppressed, %d sends, %+v", sends, o.emails["t2"])
+	}
+
+	if err := run("t3", "invalid@test.com"); !isPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	} else if r := o.emails["t3"]; r.Status != model.EmailFailed || len(r.Reason) == 0 {
+		t.Errorf("unexpected outbox email %+v", r)
+	}
+
+	// executed outside of the queue the email still gets an ID
+	if err := e.Run(context.Background(), SendEmailParameter{To: "other@test.com", Template: email.TemplateWelcome}); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.emails) != 4 {
+		t.Errorf("expected 4 emails in the outbox, got %d", len(o.emails))
+	}
+}
+
+func TestEmail_LegacyBody(t *testing.T) {
+	qt, err := decodeTask(`{"id":"gosaas.email","data":{"To":"you@test.com","Body":"hello"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(context.Background(), qt); !isPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestEmail_DevCatcher(t *testing.T) {
+	mb := email.NewMemoryMailbox()
+	email.SetMailbox(mb)
+	defer email.SetMailbox(nil)
+
+	e := &Email{}
+	e.Send = e.sendEmailDev
+	if err := e.Run(context.Background(), SendEmailParameter{From: "me@test.com", To: "you@test.com", Template: email.TemplateWelcome}); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, _ := mb.List("you@test.com"); len(list) != 1 || len(list[0].HTML) == 0 {
+		t.Errorf("expected the email to be caught, got %+v", list)
+	}
+}
+
+// testPreferences unsubscribes the addresses from the categories.
+type testPreferences map[string]model.EmailCategory
+
+func (p testPreferences) AcceptsEmail(email string, category model.EmailCategory) (bool, error) {
+	return p[email] != category, nil
+}
+
+func TestEmail_Preferences(t *testing.T) {
+	defer func(c config.Configuration) { config.Current = c }(config.Current)
+	config.Current.AppURL, config.Current.SecretKey = "https://app.test", "key"
+
+	SetPreferences(testPreferences{"you@test.com": model.EmailMarketing})
+	defer SetPreferences(nil)
+
+	var sent []email.Message
+	e := &Email{Send: func(m email.Message) (string, error) {
+		sent = append(sent, m)
+		return "", nil
+	}}
+
+	p := SendEmailParameter{From: "me@test.com", To: "you@test.com", Template: email.TemplateWelcome, Category: model.EmailMarketing}
+	if err := e.Run(context.Background(), p); err != nil {
+		t.Fatal(err)
+	} else if len(sent) != 0 {
+		t.Fatalf("the unsubscribed user should not receive the email, got %+v", sent)
+	}
+
+	p.Category = model.EmailProduct
+	if err := e.Run(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || !strings.HasPrefix(sent[0].Headers["List-Unsubscribe"], "<https://app.test/email/unsubscribe?token=") ||
+		sent[0].Headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" || !strings.Contains(sent[0].HTML, "/email/unsubscribe?token=") {
+		t.Fatalf("expected the email with the unsubscribe link, got %+v", sent)
+	}
+
+	// the transactional emails are always sent without unsubscribe link
+	p.Category = ""
+	SetPreferences(testPreferences{"you@test.com": model.EmailTransactional})
+	if err := e.Run(context.Background(), p); err != nil {
+		t.Fatal(err)
+	} else if len(sent) != 2 || len(sent[1].Headers) != 0 {
+		t.Errorf("expected the transactional email without headers, got %+v", sent)
+	}
+}
@@ -0,0 +1,420 @@
+package queue
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryPoll is how often Reserve looks for due tasks while waiting.
+const memoryPoll = 100 * time.Millisecond
+
+// MemoryBackend keeps the tasks in memory, they are lost when the process
+// stops. It lets apps without Redis execute tasks and tests execute them
+// deterministically:
+//
+//	m := queue.NewMemoryBackend()
+//	queue.SetBackend(m)
+//	queue.Enqueue(queue.TaskEmail, p)
+//	m.RunPending()
+type MemoryBackend struct {
+	// Now returns the current time, tests can replace it to execute the delayed
+	// tasks and the retries without waiting.
+	Now func() time.Time
+
+	mu     sync.Mutex
+	seq    int64
+	tasks  map[string]*memoryTask
+	rates  map[TaskID]memoryRate
+	notify chan struct{}
+}
+
+type memoryTask struct {
+	seq      int64
+	info     TaskInfo
+	runAt    time.Time
+	reserved bool
+}
+
+type memoryRate struct {
+	window string
+	count  int64
+}
+
+// NewMemoryBackend returns an empty in-memory Backend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		Now:    time.Now,
+		tasks:  make(map[string]*memoryTask),
+		rates:  make(map[TaskID]memoryRate),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (m *MemoryBackend) now() time.Time {
+	if m.Now == nil {
+		return time.Now()
+	}
+	return m.Now()
+}
+
+// wake signals a waiting Reserve that a task may be due.
+func (m *MemoryBackend) wake() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Push queues the task, tasks without UUID are tracked by an internal ID.
+func (m *MemoryBackend) Push(qt QueueTask, payload string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if at.IsZero() || at.Before(now) {
+		at = now
+	}
+
+	m.seq++
+	id := qt.UUID
+	if len(id) == 0 {
+		id = fmt.Sprintf("#%d", m.seq)
+	}
+
+	m.tasks[id] = &memoryTask{
+		seq: m.seq,
+		info: TaskInfo{
+			UUID:      qt.UUID,
+			ID:        qt.ID,
+			Status:    StatusQueued,
+			Attempts:  qt.Attempts,
+			LastError: qt.LastError,
+			Created:   qt.Created,
+			Updated:   now,
+			payload:   payload,
+		},
+		runAt: at,
+	}
+	m.wake()
+	return nil
+}
+
+// Reserve waits for the oldest due task, a zero timeout does not wait.
+func (m *MemoryBackend) Reserve(timeout time.Duration) (*Delivery, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if d := m.reserve(); d != nil {
+			return d, nil
+		}
+
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, ErrNoTask
+		} else if left > memoryPoll {
+			left = memoryPoll
+		}
+
+		select {
+		case <-m.notify:
+		case <-time.After(left):
+		}
+	}
+}
+
+func (m *MemoryBackend) reserve() *Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var (
+		id   string
+		next *memoryTask
+	)
+	for k, t := range m.tasks {
+		if t.reserved || t.runAt.After(now) {
+			continue
+		} else if t.info.Status != StatusQueued && t.info.Status != StatusFailed {
+			continue
+		}
+
+		if next == nil || t.runAt.Before(next.runAt) || (t.runAt.Equal(next.runAt) && t.seq < next.seq) {
+			id, next = k, t
+		}
+	}
+
+	if next == nil {
+		return nil
+	}
+	next.reserved = true
+	return &Delivery{ID: id, Payload: next.info.payload}
+}
+
+// update applies fn to a reserved task.
+func (m *MemoryBackend) update(d *Delivery, fn func(t *memoryTask, now time.Time)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tasks[d.ID]
+	if !ok {
+		return ErrTaskNotFound
+	}
+
+	now := m.now()
+	fn(t, now)
+	t.info.Updated = now
+	return nil
+}
+
+// Start marks a reserved task as running.
+func (m *MemoryBackend) Start(d *Delivery) error {
+	return m.update(d, func(t *memoryTask, now time.Time) {
+		t.info.Status = StatusRunning
+		t.info.Started = &now
+	})
+}
+
+// Ack marks a reserved task as succeeded.
+func (m *MemoryBackend) Ack(d *Delivery) error {
+	return m.update(d, func(t *memoryTask, now time.Time) {
+		t.reserved = false
+		t.info.Status = StatusSucceeded
+		t.info.Finished = &now
+	})
+}
+
+// Retry replaces a reserved task by its next version due at.
+func (m *MemoryBackend) Retry(d *Delivery, next QueueTask, payload string, at time.Time) error {
+	defer m.wake()
+	return m.update(d, func(t *memoryTask, now time.Time) {
+		t.reserved = false
+		t.runAt = at
+		t.info.Status = StatusFailed
+		t.info.Attempts = next.Attempts
+		t.info.LastError = next.LastError
+		t.info.payload = payload
+	})
+}
+
+// Postpone puts a reserved task aside until at, it stays queued.
+func (m *MemoryBackend) Postpone(d *Delivery, at time.Time) error {
+	defer m.wake()
+	return m.update(d, func(t *memoryTask, now time.Time) {
+		t.reserved = false
+		t.runAt = at
+		t.info.Status = StatusQueued
+	})
+}
+
+// Bury marks a reserved task as dead.
+func (m *MemoryBackend) Bury(d *Delivery, next QueueTask, payload string) error {
+	return m.update(d, func(t *memoryTask, now time.Time) {
+		t.reserved = false
+		t.info.Status = StatusDead
+		t.info.Attempts = next.Attempts
+		t.info.LastError = next.LastError
+		t.info.payload = payload
+	})
+}
+
+// Allow counts an execution of the task in the current rate window.
+func (m *MemoryBackend) Allow(id TaskID, rate int64, per time.Duration) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	window, left := rateWindow(id, per, m.now())
+	r := m.rates[id]
+	if r.window != window {
+		r = memoryRate{window: window}
+	}
+	r.count++
+	m.rates[id] = r
+
+	if r.count <= rate {
+		return 0, nil
+	}
+	return left, nil
+}
+
+// RunPending executes the due tasks one at a time until none is left and
+// returns how many were processed. Tasks failing are retried once Now passes
+// their backoff, so RunPending does not loop on them.
+func (m *MemoryBackend) RunPending() int {
+	n := 0
+	for {
+		d, err := m.Reserve(0)
+		if err != nil {
+			return n
+		}
+		process(m, d)
+		n++
+	}
+}
+
+// Inspect returns the status of a task.
+func (m *MemoryBackend) Inspect(uuid string) (*TaskInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tasks[uuid]
+	if !ok || len(t.info.UUID) == 0 {
+		return nil, ErrTaskNotFound
+	}
+	return m.info(t)
+}
+
+func (m *MemoryBackend) info(t *memoryTask) (*TaskInfo, error) {
+	ti := t.info
+	runAt := t.runAt
+	ti.RunAt = &runAt
+	return ti.fill()
+}
+
+// Tasks returns the tasks with the status, or all of them if status is empty,
+// the most recent first.
+func (m *MemoryBackend) Tasks(status Status, offset, limit int) ([]TaskInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matches []*memoryTask
+	for _, t := range m.tasks {
+		if len(t.info.UUID) == 0 || (len(status) > 0 && t.info.Status != status) {
+			continue
+		}
+		matches = append(matches, t)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.info.Created.Equal(b.info.Created) {
+			return a.seq > b.seq
+		}
+		return a.info.Created.After(b.info.Created)
+	})
+
+	tasks := make([]TaskInfo, 0)
+	for _, t := range matches {
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		ti, err := m.info(t)
+		if err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, *ti)
+		if limit > 0 && len(tasks) >= limit {
+			break
+		}
+	}
+	return tasks, nil
+}
+
+// Requeue queues a dead or failed task for immediate execution.
+func (m *MemoryBackend) Requeue(uuid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tasks[uuid]
+	if !ok {
+		return ErrTaskNotFound
+	} else if t.info.Status != StatusDead && t.info.Status != StatusFailed {
+		return errNotRetryable(&t.info)
+	}
+
+	qt, payload, err := resetAttempts(t.info.payload)
+	if err != nil {
+		return err
+	}
+
+	now := m.now()
+	t.runAt = now
+	t.info.Status = StatusQueued
+	t.info.Attempts = qt.Attempts
+	t.info.Updated = now
+	t.info.payload = payload
+	m.wake()
+	return nil
+}
+
+// Purge removes a task that is not running.
+func (m *MemoryBackend) Purge(uuid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tasks[uuid]
+	if !ok {
+		return ErrTaskNotFound
+	} else if t.info.Status == StatusRunning {
+		return fmt.Errorf("task %s is running", uuid)
+	}
+	delete(m.tasks, uuid)
+	return nil
+}
+
+// PurgeStatus purges all the tasks with the status, including the dead tasks
+// that could not be decoded.
+func (m *MemoryBackend) PurgeStatus(status Status) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, t := range m.tasks {
+		if t.info.Status != status || t.reserved {
+			continue
+		}
+		delete(m.tasks, id)
+		if len(t.info.UUID) > 0 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Subscribe does nothing, the process is the only consumer.
+func (m *MemoryBackend) Subscribe() error {
+	return nil
+}
+
+// Unsubscribe makes the tasks still reserved available again.
+func (m *MemoryBackend) Unsubscribe() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tasks {
+		if t.reserved {
+			t.reserved = false
+			t.info.Status = StatusQueued
+		}
+	}
+	return nil
+}
+
+// Maintain removes the succeeded tasks older than SucceededRetention until done is closed.
+func (m *MemoryBackend) Maintain(done <-chan struct{}) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			m.expire()
+		}
+	}
+}
+
+func (m *MemoryBackend) expire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	limit := m.now().Add(-SucceededRetention)
+	for id, t := range m.tasks {
+		if t.info.Status == StatusSucceeded && t.info.Updated.Before(limit) {
+			delete(m.tasks, id)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackend_Memory(t *testing.T) {
+	testBackend(t, NewMemoryBackend())
+}
+
+func TestMemory_RunPendingWithClock(t *testing.T) {
+	setTestExecutors()
+
+	now := time.Date(2019, 3, 4, 10, 0, 0, 0, time.UTC)
+	m := NewMemoryBackend()
+	m.Now = func() time.Time { return now }
+	SetBackend(m)
+	defer SetBackend(nil)
+
+	SetRetryPolicy(taskTestFail, RetryPolicy{MaxRetries: 2, Backoff: time.Minute})
+	defer SetRetryPolicy(taskTestFail, DefaultRetryPolicy)
+
+	fail, _ := Enqueue(taskTestFail, "fail")
+	later, _ := EnqueueAt(taskTestOK, "later", now.Add(time.Hour))
+
+	if n := m.RunPending(); n != 1 {
+		t.Fatalf("executed %d tasks, expected 1", n)
+	}
+	if ti, _ := Inspect(fail); ti.Status != StatusFailed || !ti.RunAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("unexpected failed task %+v", ti)
+	}
+
+	// nothing is due until the clock moves
+	if n := m.RunPending(); n != 0 {
+		t.Errorf("executed %d tasks, expected 0", n)
+	}
+
+	// the retry and the delayed task, the next retry backs off 2 minutes
+	now = now.Add(2 * time.Hour)
+	if n := m.RunPending(); n != 2 {
+		t.Errorf("executed %d tasks, expected 2", n)
+	}
+
+	if ti, _ := Inspect(later); ti.Status != StatusSucceeded {
+		t.Errorf("unexpected delayed task %+v", ti)
+	}
+	if ti, _ := Inspect(fail); ti.Status != StatusFailed || ti.Attempts != 2 {
+		t.Errorf("unexpected retried task %+v", ti)
+	}
+
+	now = now.Add(SucceededRetention + time.Hour)
+	m.expire()
+	if _, err := Inspect(later); err != ErrTaskNotFound {
+		t.Errorf("expected the succeeded task to expire, got %v", err)
+	}
+}
+
+func TestMemory_TaskUUID(t *testing.T) {
+	m := NewMemoryBackend()
+	SetBackend(m)
+	defer SetBackend(nil)
+
+	var got string
+	register(t, "test.uuid", func(ctx context.Context, p string) error {
+		got = TaskUUID(ctx)
+		return nil
+	})
+
+	id, err := Enqueue(TaskID("test.uuid"), "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RunPending()
+	if got != id {
+		t.Errorf("expected the task UUID %s, got %q", id, got)
+	}
+}
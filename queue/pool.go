@@ -0,0 +1,194 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultConcurrency is the number of tasks a consumer executes at once
+// when SetConcurrency has not been called.
+const DefaultConcurrency = 10
+
+// deferDelay is how long a task is put aside when its TaskLimits are reached.
+const deferDelay = 1 * time.Second
+
+// TaskLimits restricts the execution of a task.
+//
+// Concurrency is the maximum number of tasks with this ID executed at once by a
+// consumer. Rate is the maximum number of executions per Per duration across all
+// consumers, i.e. Rate 10 and Per time.Second for an email provider accepting 10
+// emails per second. Zero values are unlimited.
+//
+// Tasks exceeding their limits are not failures, they are put back in the queue
+// and executed later.
+type TaskLimits struct {
+	Concurrency int
+	Rate        int64
+	Per         time.Duration
+}
+
+var (
+	poolMu      sync.Mutex
+	concurrency = DefaultConcurrency
+	current     *pool
+
+	limitsMu   sync.Mutex
+	taskLimits = make(map[TaskID]TaskLimits)
+	running    = make(map[TaskID]int)
+)
+
+// SetConcurrency sets the number of tasks a consumer executes at once, it must be
+// called before SetAsSubscriber. Once all workers are busy the consumer stops
+// reserving tasks, they wait in Redis for this or another consumer.
+func SetConcurrency(n int) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if n > 0 {
+		concurrency = n
+	}
+}
+
+// SetTaskLimits sets the concurrency and rate limits of a task.
+func SetTaskLimits(id TaskID, l TaskLimits) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	taskLimits[id] = l
+}
+
+// Shutdown stops the consumer from reserving tasks and waits for the running
+// tasks to complete. If ctx expires first, the tasks still running will be
+// executed again by another consumer since they were not acknowledged.
+func Shutdown(ctx context.Context) error {
+	poolMu.Lock()
+	p := current
+	current = nil
+	poolMu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+
+	close(p.stop)
+	select {
+	case <-p.finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pool executes the reserved tasks with a bounded number of workers.
+type pool struct {
+	b        Backend
+	slots    chan struct{}
+	wg       sync.WaitGroup
+	stop     chan struct{}
+	finished chan struct{}
+}
+
+func newPool(b Backend) *pool {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	p := &pool{
+		b:        b,
+		slots:    make(chan struct{}, concurrency),
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	current = p
+	return p
+}
+
+// run reserves tasks while a worker is available until the pool is stopped,
+// then waits for the running tasks.
+func (p *pool) run() {
+	defer p.wg.Wait()
+
+	for {
+		// checked first since select picks randomly when both are ready
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case p.slots <- struct{}{}:
+		}
+
+		d, err := p.b.Reserve(reserveTimeout)
+		if err != nil {
+			<-p.slots
+			if err != ErrNoTask {
+				log.Println("unable to reserve a task from the queue", err)
+				if !p.sleep(reserveTimeout) {
+					return
+				}
+			}
+			continue
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer func() {
+				<-p.slots
+				p.wg.Done()
+			}()
+			process(p.b, d)
+		}()
+	}
+}
+
+// sleep waits for d and returns false if the pool was stopped meanwhile.
+func (p *pool) sleep(d time.Duration) bool {
+	select {
+	case <-p.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// acquire reserves an execution of the task according to its TaskLimits. When a
+// limit is reached, it returns false and how long to wait before trying again.
+func acquire(b Backend, id TaskID) (time.Duration, bool) {
+	limitsMu.Lock()
+	l, ok := taskLimits[id]
+	if !ok {
+		running[id]++
+		limitsMu.Unlock()
+		return 0, true
+	}
+
+	if l.Concurrency > 0 && running[id] >= l.Concurrency {
+		limitsMu.Unlock()
+		return deferDelay, false
+	}
+	running[id]++
+	limitsMu.Unlock()
+
+	if l.Rate > 0 && l.Per > 0 {
+		wait, err := b.Allow(id, l.Rate, l.Per)
+		if err != nil {
+			// the limit cannot be checked, the task is executed anyway
+			log.Println("unable to check the task rate limit", id, err)
+		} else if wait > 0 {
+			release(id)
+			return wait, false
+		}
+	}
+	return 0, true
+}
+
+func release(id TaskID) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	if running[id] > 0 {
+		running[id]--
+	}
+}
@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	taskTestSlow    TaskID = "test.slow"
+	taskTestLimited TaskID = "test.limited"
+)
+
+func TestPool_BoundedWorkersAndDrain(t *testing.T) {
+	b := newTestBackend(t)
+
+	var (
+		mu        sync.Mutex
+		active    int
+		maxActive int
+		done      int
+	)
+	release := make(chan struct{})
+	executors[taskTestSlow] = funcExecutor(func(qt QueueTask) error {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		active--
+		done++
+		mu.Unlock()
+		return nil
+	})
+
+	SetConcurrency(2)
+	defer SetConcurrency(DefaultConcurrency)
+
+	for i := 0; i < 5; i++ {
+		if _, err := Enqueue(taskTestSlow, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		SetAsSubscriber()
+		close(stopped)
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+
+	// backpressure: the other tasks are still waiting in Redis
+	if n := b.client.LLen(keyPending).Val(); n != 3 {
+		t.Errorf("pending list has %d tasks, expected 3", n)
+	}
+
+	close(release)
+
+	time.Sleep(500 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-stopped
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxActive != 2 {
+		t.Errorf("%d tasks executed at once, expected 2", maxActive)
+	}
+	if done != 5 {
+		t.Errorf("%d tasks executed, expected 5", done)
+	}
+
+	keys := b.client.Keys(keyProcessing + "*").Val()
+	for _, k := range keys {
+		if n := b.client.LLen(k).Val(); n > 0 {
+			t.Errorf("%s holds %d tasks after the drain", k, n)
+		}
+	}
+}
+
+func TestPool_TaskLimits(t *testing.T) {
+	b := newTestBackend(t)
+
+	SetTaskLimits(taskTestLimited, TaskLimits{Concurrency: 1})
+	if _, ok := acquire(b, taskTestLimited); !ok {
+		t.Fatal("first execution should be allowed")
+	}
+	if wait, ok := acquire(b, taskTestLimited); ok || wait != deferDelay {
+		t.Errorf("second execution allowed %v, wait %v", ok, wait)
+	}
+	release(taskTestLimited)
+
+	SetTaskLimits(taskTestLimited, TaskLimits{Rate: 2, Per: time.Hour})
+	defer SetTaskLimits(taskTestLimited, TaskLimits{})
+	b.client.Del(b.client.Keys(keyRate + "*").Val()...)
+
+	for i := 0; i < 2; i++ {
+		if _, ok := acquire(b, taskTestLimited); !ok {
+			t.Fatalf("execution %d should be allowed", i+1)
+		}
+		release(taskTestLimited)
+	}
+
+	wait, ok := acquire(b, taskTestLimited)
+	if ok {
+		t.Fatal("third execution should be rate limited")
+	} else if wait <= 0 || wait > time.Hour {
+		t.Errorf("got wait %v", wait)
+	}
+}
+
+func TestPool_DeferLimitedTask(t *testing.T) {
+	b := newTestBackend(t)
+
+	SetTaskLimits(taskTestOK, TaskLimits{Concurrency: 1})
+	defer SetTaskLimits(taskTestOK, TaskLimits{})
+
+	acquire(b, taskTestOK)
+	defer release(taskTestOK)
+
+	process(b, reserveTask(t, b, taskTestOK, 0))
+
+	items := b.client.ZRange(keyDelayed, 0, -1).Val()
+	if len(items) != 1 {
+		t.Fatalf("delayed set has %d tasks, expected 1", len(items))
+	}
+	if n := b.client.LLen(b.processingKey()).Val(); n != 0 {
+		t.Errorf("processing list has %d tasks, expected 0", n)
+	}
+}
@@ -0,0 +1,382 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// postgresPoll is how often Reserve looks for due tasks while waiting.
+const postgresPoll = 500 * time.Millisecond
+
+// PostgresBackend stores the tasks in the gosaas_queue_tasks table, see
+// migrations/002_queue.sql.
+//
+// Consumers reserve the oldest due task with FOR UPDATE SKIP LOCKED and hold a
+// lease on it, renewed while they are alive. Tasks whose lease expired were
+// held by a consumer that stopped and are reserved again.
+type PostgresBackend struct {
+	db       *sql.DB
+	consumer string
+}
+
+// NewPostgresBackend returns a Backend using the database connection, i.e.
+// the Connection of data.DB.
+func NewPostgresBackend(db *sql.DB) *PostgresBackend {
+	host, _ := os.Hostname()
+	return &PostgresBackend{
+		db:       db,
+		consumer: fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.NewV4().String()[:8]),
+	}
+}
+
+// nullString returns nil for an empty string so it is stored as NULL.
+func nullString(s string) interface{} {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// Push inserts the task, it is due at at or now if at is zero.
+func (b *PostgresBackend) Push(qt QueueTask, payload string, at time.Time) error {
+	now := time.Now()
+	if at.IsZero() {
+		at = now
+	}
+	created := qt.Created
+	if created.IsZero() {
+		created = now
+	}
+
+	_, err := b.db.Exec(`
+		INSERT INTO gosaas_queue_tasks(uuid, task_id, status, payload, attempts, last_error, run_at, created, updated)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, nullString(qt.UUID), string(qt.ID), string(StatusQueued), payload, qt.Attempts, qt.LastError, at, created, now)
+	return err
+}
+
+// Reserve waits for the oldest due task and leases it to the consumer.
+func (b *PostgresBackend) Reserve(timeout time.Duration) (*Delivery, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		d, err := b.reserve()
+		if err != nil {
+			return nil, err
+		} else if d != nil {
+			return d, nil
+		}
+
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, ErrNoTask
+		} else if left > postgresPoll {
+			left = postgresPoll
+		}
+		time.Sleep(left)
+	}
+}
+
+func (b *PostgresBackend) reserve() (*Delivery, error) {
+	now := time.Now()
+
+	var (
+		id      int64
+		payload string
+	)
+	err := b.db.QueryRow(`
+		UPDATE gosaas_queue_tasks
+		SET reserved_by = $1, reserved_until = $2
+		WHERE id = (
+			SELECT id FROM gosaas_queue_tasks
+			WHERE (status IN ('queued', 'failed') AND reserved_by IS NULL AND run_at <= $3)
+			OR (reserved_by IS NOT NULL AND reserved_until < $3)
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, payload
+	`, b.consumer, now.Add(heartbeatExpire), now).Scan(&id, &payload)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &Delivery{ID: strconv.FormatInt(id, 10), Payload: payload}, nil
+}
+
+// Start marks a reserved task as running.
+func (b *PostgresBackend) Start(d *Delivery) error {
+	now := time.Now()
+	_, err := b.db.Exec(`
+		UPDATE gosaas_queue_tasks
+		SET status = $1, started = $2, updated = $2
+		WHERE id = $3
+	`, string(StatusRunning), now, d.ID)
+	return err
+}
+
+// Ack marks a reserved task as succeeded and releases it.
+func (b *PostgresBackend) Ack(d *Delivery) error {
+	now := time.Now()
+	_, err := b.db.Exec(`
+		UPDATE gosaas_queue_tasks
+		SET status = $1, finished = $2, updated = $2, reserved_by = NULL, reserved_until = NULL
+		WHERE id = $3
+	`, string(StatusSucceeded), now, d.ID)
+	return err
+}
+
+// Retry replaces a reserved task by its next version due at.
+func (b *PostgresBackend) Retry(d *Delivery, next QueueTask, payload string, at time.Time) error {
+	_, err := b.db.Exec(`
+		UPDATE gosaas_queue_tasks
+		SET status = $1, payload = $2, attempts = $3, last_error = $4, run_at = $5, updated = $6,
+			reserved_by = NULL, reserved_until = NULL
+		WHERE id = $7
+	`, string(StatusFailed), payload, next.Attempts, next.LastError, at, time.Now(), d.ID)
+	return err
+}
+
+// Postpone puts a reserved task aside until at, it stays queued.
+func (b *PostgresBackend) Postpone(d *Delivery, at time.Time) error {
+	_, err := b.db.Exec(`
+		UPDATE gosaas_queue_tasks
+		SET status = $1, run_at = $2, updated = $3, reserved_by = NULL, reserved_until = NULL
+		WHERE id = $4
+	`, string(StatusQueued), at, time.Now(), d.ID)
+	return err
+}
+
+// Bury marks a reserved task as dead and releases it.
+func (b *PostgresBackend) Bury(d *Delivery, next QueueTask, payload string) error {
+	_, err := b.db.Exec(`
+		UPDATE gosaas_queue_tasks
+		SET status = $1, payload = $2, attempts = $3, last_error = $4, updated = $5,
+			reserved_by = NULL, reserved_until = NULL
+		WHERE id = $6
+	`, string(StatusDead), payload, next.Attempts, next.LastError, time.Now(), d.ID)
+	return err
+}
+
+// Allow counts an execution of the task in the current rate window across all consumers.
+func (b *PostgresBackend) Allow(id TaskID, rate int64, per time.Duration) (time.Duration, error) {
+	now := time.Now()
+	window, left := rateWindow(id, per, now)
+
+	var count int64
+	err := b.db.QueryRow(`
+		INSERT INTO gosaas_queue_rates(key, count, expires)
+		VALUES($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET count = gosaas_queue_rates.count + 1
+		RETURNING count
+	`, window, now.Add(left+time.Second)).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	if count <= rate {
+		return 0, nil
+	}
+	return left, nil
+}
+
+const taskColumns = "uuid, task_id, status, attempts, last_error, payload, run_at, created, updated, started, finished"
+
+func scanTask(s interface{ Scan(...interface{}) error }) (*TaskInfo, error) {
+	var (
+		ti                TaskInfo
+		id, status        string
+		runAt             time.Time
+		started, finished sql.NullTime
+	)
+	if err := s.Scan(&ti.UUID, &id, &status, &ti.Attempts, &ti.LastError, &ti.payload,
+		&runAt, &ti.Created, &ti.Updated, &started, &finished); err != nil {
+		return nil, err
+	}
+
+	ti.ID = TaskID(id)
+	ti.Status = Status(status)
+	ti.RunAt = &runAt
+	if started.Valid {
+		ti.Started = &started.Time
+	}
+	if finished.Valid {
+		ti.Finished = &finished.Time
+	}
+	return ti.fill()
+}
+
+// Inspect returns the status of a task.
+func (b *PostgresBackend) Inspect(uuid string) (*TaskInfo, error) {
+	row := b.db.QueryRow("SELECT "+taskColumns+" FROM gosaas_queue_tasks WHERE uuid = $1", uuid)
+	ti, err := scanTask(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	return ti, err
+}
+
+// Tasks returns the tasks with the status, or all of them if status is empty,
+// the most recent first.
+func (b *PostgresBackend) Tasks(status Status, offset, limit int) ([]TaskInfo, error) {
+	var max interface{}
+	if limit > 0 {
+		max = limit
+	}
+
+	rows, err := b.db.Query(`
+		SELECT `+taskColumns+` FROM gosaas_queue_tasks
+		WHERE uuid IS NOT NULL AND ($1 = '' OR status = $1)
+		ORDER BY created DESC, id DESC
+		OFFSET $2 LIMIT $3
+	`, string(status), offset, max)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]TaskInfo, 0)
+	for rows.Next() {
+		ti, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *ti)
+	}
+	return tasks, rows.Err()
+}
+
+// Requeue queues a dead or failed task for immediate execution.
+func (b *PostgresBackend) Requeue(uuid string) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status, payload string
+	err = tx.QueryRow(`
+		SELECT status, payload FROM gosaas_queue_tasks
+		WHERE uuid = $1
+		FOR UPDATE
+	`, uuid).Scan(&status, &payload)
+	if err == sql.ErrNoRows {
+		return ErrTaskNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if s := Status(status); s != StatusDead && s != StatusFailed {
+		return errNotRetryable(&TaskInfo{UUID: uuid, Status: s})
+	}
+
+	qt, payload, err := resetAttempts(payload)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(`
+		UPDATE gosaas_queue_tasks
+		SET status = $1, payload = $2, attempts = $3, run_at = $4, updated = $4
+		WHERE uuid = $5
+	`, string(StatusQueued), payload, qt.Attempts, now, uuid)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Purge removes a task that is not running.
+func (b *PostgresBackend) Purge(uuid string) error {
+	ti, err := b.Inspect(uuid)
+	if err != nil {
+		return err
+	}
+
+	if ti.Status == StatusRunning {
+		return fmt.Errorf("task %s is running", uuid)
+	}
+
+	_, err = b.db.Exec("DELETE FROM gosaas_queue_tasks WHERE uuid = $1 AND status <> $2", uuid, string(StatusRunning))
+	return err
+}
+
+// PurgeStatus purges all the tasks with the status. Purging the dead tasks
+// includes the tasks that could not be decoded.
+func (b *PostgresBackend) PurgeStatus(status Status) (int, error) {
+	var n int
+	err := b.db.QueryRow(`
+		WITH deleted AS (
+			DELETE FROM gosaas_queue_tasks
+			WHERE status = $1 AND reserved_by IS NULL
+			RETURNING uuid
+		)
+		SELECT COUNT(uuid) FROM deleted
+	`, string(status)).Scan(&n)
+	return n, err
+}
+
+// Subscribe checks the database is reachable, the tasks of the consumers that
+// stopped are reserved again once their lease expires.
+func (b *PostgresBackend) Subscribe() error {
+	return b.db.Ping()
+}
+
+// Unsubscribe releases the tasks still leased by the consumer.
+func (b *PostgresBackend) Unsubscribe() error {
+	_, err := b.db.Exec(`
+		UPDATE gosaas_queue_tasks
+		SET status = $1, reserved_by = NULL, reserved_until = NULL
+		WHERE reserved_by = $2
+	`, string(StatusQueued), b.consumer)
+	return err
+}
+
+// Maintain renews the leases of the reserved tasks and removes the expired rate
+// windows and succeeded tasks until done is closed.
+func (b *PostgresBackend) Maintain(done <-chan struct{}) {
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-heartbeat.C:
+			if err := b.renew(now); err != nil {
+				log.Println("unable to renew the leases of the reserved tasks", err)
+			}
+			if err := b.expire(now); err != nil {
+				log.Println("unable to remove the expired queue rows", err)
+			}
+		}
+	}
+}
+
+func (b *PostgresBackend) renew(now time.Time) error {
+	_, err := b.db.Exec(`
+		UPDATE gosaas_queue_tasks
+		SET reserved_until = $1
+		WHERE reserved_by = $2
+	`, now.Add(heartbeatExpire), b.consumer)
+	return err
+}
+
+func (b *PostgresBackend) expire(now time.Time) error {
+	if _, err := b.db.Exec("DELETE FROM gosaas_queue_rates WHERE expires < $1", now); err != nil {
+		return err
+	}
+
+	_, err := b.db.Exec(`
+		DELETE FROM gosaas_queue_tasks
+		WHERE status = $1 AND finished < $2
+	`, string(StatusSucceeded), now.Add(-SucceededRetention))
+	return err
+}
@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+func TestBackend_Postgres(t *testing.T) {
+	ds := os.Getenv("TEST_DATABASE_URL")
+	if len(ds) == 0 {
+		ds = "user=postgres password=postgres dbname=test sslmode=disable"
+	}
+
+	db, err := sql.Open("postgres", ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Skip("postgres is not available", err)
+	}
+
+	clean := func() {
+		if _, err := db.Exec("DELETE FROM gosaas_queue_tasks; DELETE FROM gosaas_queue_rates;"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clean()
+	defer clean()
+
+	testBackend(t, NewPostgresBackend(db))
+}
@@ -1,25 +1,19 @@
 package queue
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"os"
-	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
-	"github.com/robfig/cron"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/queue/email"
+	uuid "github.com/satori/go.uuid"
 )
 
 var (
-	client    *redis.Client
-	pubsub    *redis.PubSub
-	scheduler *cron.Cron
-	isDev     bool
+	isDev bool
 
 	emailer *Email
 	biller  *Billing
@@ -28,8 +22,15 @@ var (
 )
 
 // New initializes the queue tasks; intially called from cache package
+//
+// The tasks are stored in Redis via rc unless another Backend was set
+// via SetBackend.
 func New(rc *redis.Client, isDev bool, ex map[TaskID]TaskExecutor) {
-	client = rc
+	backendMu.Lock()
+	if backend == nil && rc != nil {
+		backend = NewRedisBackend(rc)
+	}
+	backendMu.Unlock()
 
 	// built-in executor
 	emailer = &Email{}
@@ -39,149 +40,197 @@ func New(rc *redis.Client, isDev bool, ex map[TaskID]TaskExecutor) {
 	// we receive from the cache package we assign the correct implementation to our
 	// Send value.
 	if isDev {
+		// the emails are caught to be read on /tools/mail, Redis shares
+		// them between the web servers and the consumers
+		if email.CurrentMailbox() == nil {
+			if rc != nil {
+				email.SetMailbox(email.NewRedisMailbox(rc))
+			} else {
+				email.SetMailbox(email.NewMemoryMailbox())
+			}
+		}
+		emailer.Provider = string(config.EmailProviderCatcher)
 		emailer.Send = emailer.sendEmailDev
 	} else {
+		emailer.Provider = string(config.Current.EmailProvider)
 		emailer.Send = emailer.sendEmailProd
 	}
 
 	executors = ex
 }
 
-// SetAsSubscriber makes this instance a Pub/Sub subscriber. Each message queued
-// will be processed by this instance. Creates a subscriber to channel "q"
+// SetAsSubscriber makes this instance a queue consumer. Each queued task will be
+// reserved by one of the consumers, multiple instances can be consumers.
+//
+// Tasks are delivered at least once, a task is acknowledged only once its executor
+// returned. Failed tasks are retried with an exponential backoff according to their
+// RetryPolicy and moved to the dead-letter list once their retries are exhausted.
+//
+// At most SetConcurrency tasks are executed at once. SetAsSubscriber returns once
+// Shutdown has been called and the running tasks completed.
 func SetAsSubscriber() {
-	scheduler = cron.New()
-
-	pubsub = client.Subscribe("q")
-	if err := pubsub.Ping("test"); err != nil {
-		log.Fatal("unable to ping pubsub", err)
+	b, err := currentBackend()
+	if err != nil {
+		log.Println("unable to start the queue consumer", err)
+		return
 	}
-	defer func() {
-		pubsub.Close()
-		scheduler.Stop()
-	}()
 
-	if _, err := pubsub.Receive(); err != nil {
-		log.Fatal("unable to receive from pubsub channel", err)
+	p := newPool(b)
+	defer close(p.finished)
+
+	// tasks of consumers that stopped without acknowledging them are made available
+	for {
+		if err := b.Subscribe(); err != nil {
+			log.Println("unable to register the queue consumer, retrying", err)
+			if !p.sleep(reserveTimeout) {
+				return
+			}
+			continue
+		}
+		break
 	}
 
-	// we initialize our scheduler (cron)
-	go setupCron()
+	done := make(chan struct{})
+	go b.Maintain(done)
 
-	ch := pubsub.Channel()
+	p.run()
+	close(done)
 
-	for {
-		msg, ok := <-ch
-		if !ok {
-			log.Fatal("redis pub/sub is down")
-			break
-		}
-		// process function in its own go routine to improve the speed our queue subscriber can dequeue the task
-		go process(msg)
+	if err := b.Unsubscribe(); err != nil {
+		log.Println("unable to unregister the queue consumer", err)
 	}
 }
 
-// start the Cron executor
-// first try to load the file and parse its content. For each line in the file, we create
-//  the function that will be executed when the correct time is reached.
-func setupCron() {
-	if _, err := os.Stat("tasks.cron"); os.IsNotExist(err) {
-		log.Println("no tasks.cron file found, skipping scheduler setup")
-		return
-	}
+// Enqueue adds a task to the queue and returns its UUID. The payload is encoded as
+// JSON, if the task was registered via Register it must be of the registered type.
+func Enqueue[T any](id TaskID, payload T) (string, error) {
+	return EnqueueAt(id, payload, time.Time{})
+}
 
-	b, err := ioutil.ReadFile("tasks.cron")
+// EnqueueAt adds a task to the queue that will not execute before at.
+//
+// Delayed tasks are stored by the Backend, they survive restarts of the consumers
+// (except in memory) and are executed once by whichever consumer is running when
+// they are due.
+func EnqueueAt[T any](id TaskID, payload T, at time.Time) (string, error) {
+	b, err := currentBackend()
 	if err != nil {
-		log.Println("error while reading tasks.cron", err)
-		return
+		return "", err
 	}
 
-	lines := strings.Split(string(b), "\n")
-	if len(lines) == 0 {
-		log.Println("no tasks found in tasks.cron, skipping scheduler setup")
-		return
+	qt := newTask(id, payload)
+	p, err := encodeTask(qt)
+	if err != nil {
+		return "", err
 	}
+	return qt.UUID, b.Push(qt, p, at)
+}
 
-	for _, line := range lines {
-		exp, url := parseTask(line)
+// EnqueueIn adds a task to the queue that will execute after the delay.
+func EnqueueIn[T any](id TaskID, payload T, delay time.Duration) (string, error) {
+	b, err := currentBackend()
+	if err != nil {
+		return "", err
+	}
+	return EnqueueAt(id, payload, timeOf(b).Add(delay))
+}
 
-		// line below has been problematic
-		err := scheduler.AddFunc(exp, func() {
-			req, err := http.NewRequest("POST", url, bytes.NewReader(b))
-			if err != nil {
-				log.Println("error while creating an HTTP request to", url)
-				return
-			}
+type contextKey int
 
-			req.SetBasicAuth("todo", "here")
+const taskUUIDKey contextKey = iota
 
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				log.Println("error while executing an HTTP request to", url)
-				return
-			}
-			defer resp.Body.Close()
+// TaskUUID returns the UUID of the task being executed, it is empty when ctx
+// was not passed by the queue.
+func TaskUUID(ctx context.Context) string {
+	id, _ := ctx.Value(taskUUIDKey).(string)
+	return id
+}
 
-			if resp.StatusCode >= 400 {
-				log.Println("scheduler HTTP request to ", url, "failed with HTTP status", resp.StatusCode)
-			}
-		})
+func newTask(id TaskID, payload interface{}) QueueTask {
+	return QueueTask{
+		UUID:    uuid.NewV4().String(),
+		ID:      id,
+		Version: payloadVersion(payload),
+		Data:    payload,
+		Created: time.Now(),
+	}
+}
 
-		if err != nil {
-			log.Fatal("unable to create cron tasks", err)
+// process function which is called everytime a task is reserved.
+func process(b Backend, d *Delivery) {
+	// deserialize the payload into a QueueTask and we select the right executor based on the ID.
+	qt, err := decodeTask(d.Payload)
+	if err != nil {
+		// it will never decode, retrying is pointless
+		log.Println("unable to decode this queued task, moving it to the dead-letter list", err)
+		if err := b.Bury(d, QueueTask{}, d.Payload); err != nil {
+			log.Println("unable to move the task to the dead-letter list", err)
 		}
+		return
 	}
+	d.Task = qt
 
-	scheduler.Start()
-}
+	if wait, ok := acquire(b, qt.ID); !ok {
+		// limits reached, the task is put aside without counting an attempt
+		if err := b.Postpone(d, timeOf(b).Add(wait)); err != nil {
+			log.Println("unable to defer the task", qt.ID, err)
+		}
+		return
+	}
+	defer release(qt.ID)
 
-func parseTask(s string) (exp string, url string) {
-	tokens := strings.Split(s, " ")
-	url = strings.Join(tokens[len(tokens)-1:], " ")
-	exp = strings.Join(tokens[0:len(tokens)-1], " ")
-	return
-}
+	if err := b.Start(d); err != nil {
+		log.Println("unable to mark this task as running", qt.ID, err)
+	}
 
-// Enqueue adds a task to the queue.
-func Enqueue(id TaskID, data interface{}) error {
-	qt := QueueTask{
-		ID:      id,
-		Data:    data,
-		Created: time.Now(),
+	err = run(context.WithValue(context.Background(), taskUUIDKey, qt.UUID), qt)
+	if err == nil {
+		if err := b.Ack(d); err != nil {
+			log.Println("unable to acknowledge this task", qt.ID, err)
+		}
+		return
 	}
-	fmt.Println("Enqueing:", data)
-	b, err := json.Marshal(qt)
-	if err != nil {
-		return err
+
+	qt.Attempts++
+	qt.LastError = err.Error()
+
+	next, merr := encodeTask(qt)
+	if merr != nil {
+		log.Println("unable to encode this task", qt.ID, merr)
+		return
 	}
-	return client.Publish("q", string(b)).Err()
-}
 
-// process function which is called everytime a new task is queued.
-func process(msg *redis.Message) {
-	var qt QueueTask
-	// deserialize the message payload into a QueueTask and we select the right executor based on the ID.
-	if err := json.Unmarshal([]byte(msg.Payload), &qt); err != nil {
-		log.Fatal("unable to decode this Redis message", err)
+	policy := retryPolicy(qt.ID)
+	if qt.Attempts > policy.MaxRetries || isPermanent(err) {
+		log.Println("task failed, moving it to the dead-letter list", qt.ID, qt.Attempts, err)
+		if err := b.Bury(d, qt, next); err != nil {
+			log.Println("unable to move the task to the dead-letter list", qt.ID, err)
+		}
+		return
 	}
 
-	var exec TaskExecutor
+	delay := policy.delay(qt.Attempts)
+	log.Println("task failed, retrying in", delay, qt.ID, err)
+	if err := b.Retry(d, qt, next, timeOf(b).Add(delay)); err != nil {
+		log.Println("unable to schedule the task retry", qt.ID, err)
+	}
+}
 
-	switch qt.ID {
-	case TaskEmail:
-		exec = emailer
-	case TaskCreateInvoice:
-		exec = biller
-	default:
-		if ex, ok := executors[qt.ID]; ok {
-			exec = ex
+// run calls the registered function or the executor of the task, panics are
+// returned as errors.
+func run(ctx context.Context, qt QueueTask) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("task panicked: %v", r)
 		}
+	}()
+
+	if r := registered(qt.ID); r != nil {
+		return r.run(ctx, qt.Version, qt.raw)
 	}
 
-	// call the Run function and log the error if one occurs.
-	if err := exec.Run(qt); err != nil {
-		//TODO: better to log those critical errors
-		log.Println("error while executing this task", qt.ID, err)
+	if ex, ok := executors[qt.ID]; ok && ex != nil {
+		return ex.Run(qt)
 	}
-	fmt.Println("Call successfully made")
+	return fmt.Errorf("no executor registered for task %s", qt.ID)
 }
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/go-redis/redis"
-	"github.com/robfig/cron"
 )
 
 func TestQueue_Setup_Queue(t *testing.T) {
@@ -32,11 +31,11 @@ func TestQueue_Setup_Queue(t *testing.T) {
 	time.AfterFunc(time.Second, func() {
 		fmt.Println("enqueing something")
 
-		err := Enqueue(TaskEmail, SendEmailParameter{
-			From:    "me@testing.com",
-			To:      "unit@test.com",
-			Subject: "unit test",
-			Body:    "<h1>unit test</h1>",
+		_, err := Enqueue(TaskEmail, SendEmailParameter{
+			From:     "me@testing.com",
+			To:       "unit@test.com",
+			Template: "welcome",
+			Data:     map[string]interface{}{"Name": "unit test"},
 		})
 
 		if err != nil {
@@ -46,29 +45,3 @@ func TestQueue_Setup_Queue(t *testing.T) {
 
 	time.Sleep(10 * time.Second)
 }
-
-func TestQueue_ParseTask(t *testing.T) {
-	cexp := "0 0 */15 0 0 0 https://google.com"
-	exp, url := parseTask(cexp)
-	if url != "https://google.com" {
-		t.Errorf("url is %s was expecting https://google.com", url)
-	} else if exp != "0 0 */15 0 0 0" {
-		t.Errorf("exp is %s was expecting 0 0 */15 0 0 0", exp)
-	}
-}
-
-func TestQueue_SetupCron(t *testing.T) {
-	t.Skip()
-
-	scheduler = cron.New()
-	defer scheduler.Stop()
-
-	setupCron()
-
-	ch := make(chan bool)
-	time.AfterFunc(17*time.Second, func() {
-		ch <- true
-	})
-
-	<-ch
-}
@@ -0,0 +1,304 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+type funcExecutor func(qt QueueTask) error
+
+func (f funcExecutor) Run(qt QueueTask) error { return f(qt) }
+
+const (
+	taskTestOK    TaskID = "test.ok"
+	taskTestFail  TaskID = "test.fail"
+	taskTestPanic TaskID = "test.panic"
+)
+
+func setTestExecutors() {
+	executors = map[TaskID]TaskExecutor{
+		taskTestOK:   funcExecutor(func(qt QueueTask) error { return nil }),
+		taskTestFail: funcExecutor(func(qt QueueTask) error { return fmt.Errorf("boom") }),
+		taskTestPanic: funcExecutor(func(qt QueueTask) error {
+			panic("oops")
+		}),
+	}
+}
+
+func newTestBackend(t *testing.T) *RedisBackend {
+	host := os.Getenv("REDIS_ADDR")
+	if len(host) == 0 {
+		host = "127.0.0.1:6379"
+	}
+
+	c := redis.NewClient(&redis.Options{Addr: host, Password: os.Getenv("REDIS_KEY")})
+	if err := c.Ping().Err(); err != nil {
+		t.Skip("redis is not available", err)
+	}
+
+	c.Del(keyPending, keyDelayed, keyDead, keyConsumers, keyTasks)
+	if keys := c.Keys(keyTask + "*").Val(); len(keys) > 0 {
+		c.Del(keys...)
+	}
+
+	setTestExecutors()
+
+	b := NewRedisBackend(c)
+	if err := b.register(); err != nil {
+		t.Fatal(err)
+	}
+	SetBackend(b)
+	t.Cleanup(func() {
+		SetBackend(nil)
+		c.Del(keyPending, keyDelayed, keyDead, keyConsumers, keyTasks, b.processingKey(), keyHeartbeat+b.consumer)
+		if keys := c.Keys(keyTask + "*").Val(); len(keys) > 0 {
+			c.Del(keys...)
+		}
+		c.Close()
+	})
+	return b
+}
+
+func reserveTask(t *testing.T, b *RedisBackend, id TaskID, attempts int) *Delivery {
+	if _, err := Enqueue(id, "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := b.Reserve(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts > 0 {
+		var qt QueueTask
+		json.Unmarshal([]byte(d.Payload), &qt)
+		qt.Attempts = attempts
+
+		b.client.LRem(b.processingKey(), 1, d.Payload)
+		buf, _ := json.Marshal(qt)
+		d.Payload = string(buf)
+		b.client.LPush(b.processingKey(), d.Payload)
+	}
+	return d
+}
+
+func TestQueue_AckOnSuccess(t *testing.T) {
+	b := newTestBackend(t)
+
+	process(b, reserveTask(t, b, taskTestOK, 0))
+
+	if n := b.client.LLen(b.processingKey()).Val(); n != 0 {
+		t.Errorf("processing list has %d tasks, expected 0", n)
+	}
+	if n := b.client.ZCard(keyDelayed).Val(); n != 0 {
+		t.Errorf("delayed set has %d tasks, expected 0", n)
+	}
+}
+
+func TestQueue_RetryWithBackoff(t *testing.T) {
+	b := newTestBackend(t)
+
+	SetRetryPolicy(taskTestFail, RetryPolicy{MaxRetries: 3, Backoff: time.Minute})
+	defer SetRetryPolicy(taskTestFail, DefaultRetryPolicy)
+
+	start := time.Now()
+	process(b, reserveTask(t, b, taskTestFail, 1))
+
+	if n := b.client.LLen(b.processingKey()).Val(); n != 0 {
+		t.Errorf("processing list has %d tasks, expected 0", n)
+	}
+
+	items, err := b.client.ZRangeWithScores(keyDelayed, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(items) != 1 {
+		t.Fatalf("delayed set has %d tasks, expected 1", len(items))
+	}
+
+	var qt QueueTask
+	if err := json.Unmarshal([]byte(items[0].Member.(string)), &qt); err != nil {
+		t.Fatal(err)
+	}
+	if qt.Attempts != 2 || qt.LastError != "boom" {
+		t.Errorf("got attempts %d and error %q", qt.Attempts, qt.LastError)
+	}
+
+	// second retry waits twice the backoff
+	at := time.Unix(int64(items[0].Score), 0)
+	if d := at.Sub(start); d < 119*time.Second || d > 121*time.Second {
+		t.Errorf("retry scheduled in %v, expected 2m", d)
+	}
+}
+
+func TestQueue_DeadLetterAfterMaxRetries(t *testing.T) {
+	b := newTestBackend(t)
+
+	SetRetryPolicy(taskTestFail, RetryPolicy{MaxRetries: 2, Backoff: time.Second})
+	defer SetRetryPolicy(taskTestFail, DefaultRetryPolicy)
+
+	process(b, reserveTask(t, b, taskTestFail, 2))
+
+	dead, err := b.client.LRange(keyDead, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(dead) != 1 {
+		t.Fatalf("dead-letter list has %d tasks, expected 1", len(dead))
+	}
+	if n := b.client.ZCard(keyDelayed).Val(); n != 0 {
+		t.Errorf("delayed set has %d tasks, expected 0", n)
+	}
+}
+
+func TestQueue_UndecodableAndUnknownTasks(t *testing.T) {
+	b := newTestBackend(t)
+
+	b.Push(QueueTask{}, "not json", time.Time{})
+	d, err := b.Reserve(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process(b, d)
+
+	if dead := b.client.LRange(keyDead, 0, -1).Val(); len(dead) != 1 || dead[0] != "not json" {
+		t.Errorf("expected the payload in the dead-letter list, got %v", dead)
+	}
+
+	// no executor and panicking executors are failures, not crashes
+	process(b, reserveTask(t, b, TaskID("test.unknown"), 0))
+	process(b, reserveTask(t, b, taskTestPanic, 0))
+
+	if n := b.client.ZCard(keyDelayed).Val(); n != 2 {
+		t.Errorf("delayed set has %d tasks, expected 2", n)
+	}
+}
+
+func TestQueue_PromoteDueTasks(t *testing.T) {
+	b := newTestBackend(t)
+
+	now := time.Now()
+	b.client.ZAdd(keyDelayed, redis.Z{Score: float64(now.Add(-time.Second).Unix()), Member: "due"})
+	b.Push(QueueTask{}, "later", now.Add(time.Hour))
+
+	n, err := b.promote(now)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("promoted %d tasks, expected 1", n)
+	}
+
+	if v := b.client.LRange(keyPending, 0, -1).Val(); len(v) != 1 || v[0] != "due" {
+		t.Errorf("pending list is %v, expected [due]", v)
+	}
+}
+
+func TestQueue_RecoverDeadConsumer(t *testing.T) {
+	b := newTestBackend(t)
+
+	crashed := NewRedisBackend(b.client)
+	crashed.register()
+	crashed.Push(QueueTask{}, "unacked", time.Time{})
+	if _, err := crashed.Reserve(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// consumer still alive, nothing is recovered
+	if err := b.recover(); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.client.LLen(keyPending).Val(); n != 0 {
+		t.Fatalf("pending list has %d tasks, expected 0", n)
+	}
+
+	b.client.Del(keyHeartbeat + crashed.consumer)
+	if err := b.recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := b.client.LRange(keyPending, 0, -1).Val(); len(v) != 1 || v[0] != "unacked" {
+		t.Errorf("pending list is %v, expected [unacked]", v)
+	}
+	if b.client.SIsMember(keyConsumers, crashed.consumer).Val() {
+		t.Error("crashed consumer is still registered")
+	}
+}
+
+func TestQueue_RecoverRunningTask(t *testing.T) {
+	b := newTestBackend(t)
+
+	qt := QueueTask{UUID: fmt.Sprintf("recover-%d", time.Now().UnixNano()), ID: taskTestOK, Created: time.Now()}
+	payload, err := json.Marshal(qt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crashed := NewRedisBackend(b.client)
+	crashed.register()
+	if err := crashed.Push(qt, string(payload), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := crashed.Reserve(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Task = qt
+	if err := crashed.Start(d); err != nil {
+		t.Fatal(err)
+	}
+
+	b.client.Del(keyHeartbeat + crashed.consumer)
+	if err := b.recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ti, err := b.Inspect(qt.UUID); err != nil {
+		t.Fatal(err)
+	} else if ti.Status != StatusQueued {
+		t.Errorf("expected the recovered task to be queued, got %s", ti.Status)
+	}
+	if err := b.Purge(qt.UUID); err != nil {
+		t.Errorf("expected the recovered task to be purged, got %v", err)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, d := range expected {
+		if v := p.delay(i + 1); v != d {
+			t.Errorf("attempt %d: got %v expected %v", i+1, v, d)
+		}
+	}
+}
+
+func TestQueue_EnqueueAt(t *testing.T) {
+	b := newTestBackend(t)
+
+	at := time.Now().Add(time.Hour)
+	if _, err := EnqueueAt(taskTestOK, "later", at); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnqueueIn(taskTestOK, "now", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := b.client.LLen(keyPending).Val(); n != 1 {
+		t.Errorf("pending list has %d tasks, expected 1", n)
+	}
+
+	items := b.client.ZRangeWithScores(keyDelayed, 0, -1).Val()
+	if len(items) != 1 {
+		t.Fatalf("delayed set has %d tasks, expected 1", len(items))
+	} else if int64(items[0].Score) != at.Unix() {
+		t.Errorf("task scheduled at %v, expected %d", items[0].Score, at.Unix())
+	}
+
+	if n, _ := b.promote(at.Add(time.Second)); n != 1 {
+		t.Errorf("promoted %d tasks, expected 1", n)
+	}
+}
@@ -0,0 +1,488 @@
+package queue
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Redis keys used by the queue.
+//
+// Tasks are pushed on the pending list and atomically moved to the processing
+// list of the consumer reserving them via BRPOPLPUSH. They are removed from it
+// only once executed (ack), so a task held by a crashed consumer is put back on
+// the pending list by the other consumers once its heartbeat expires.
+const (
+	keyPending    = "q:pending"
+	keyDelayed    = "q:delayed"
+	keyDead       = "q:dead"
+	keyConsumers  = "q:consumers"
+	keyProcessing = "q:processing:"
+	keyHeartbeat  = "q:heartbeat:"
+	keyRate       = "q:rate:"
+	keyTask       = "q:task:"
+	keyTasks      = "q:tasks"
+)
+
+const (
+	heartbeatInterval = 10 * time.Second
+	heartbeatExpire   = 30 * time.Second
+	reserveTimeout    = 2 * time.Second
+	promoteInterval   = 1 * time.Second
+	promoteBatch      = 100
+)
+
+// promoteScript moves the delayed tasks that are due to the pending list.
+var promoteScript = redis.NewScript(`
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for i, v in ipairs(items) do
+	redis.call('ZREM', KEYS[1], v)
+	redis.call('LPUSH', KEYS[2], v)
+end
+return #items
+`)
+
+// rateScript counts an execution in the rate window, the window key expires
+// with it. A key left without expiry is given one too.
+var rateScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// requeueScript moves the tasks of a consumer back to the pending list, the
+// running ones are queued again.
+var requeueScript = redis.NewScript(`
+local n = 0
+while true do
+	local v = redis.call('RPOPLPUSH', KEYS[1], KEYS[2])
+	if not v then
+		break
+	end
+	n = n + 1
+
+	local ok, t = pcall(cjson.decode, v)
+	if ok and type(t) == 'table' and type(t.uuid) == 'string' and t.uuid ~= '' then
+		local key = ARGV[1] .. t.uuid
+		if redis.call('HGET', key, 'status') == ARGV[2] then
+			redis.call('HMSET', key, 'status', ARGV[3], 'updated', ARGV[4])
+			redis.call('HDEL', key, 'started')
+		end
+	end
+end
+return n
+`)
+
+// RedisBackend stores the tasks in Redis lists, delayed tasks in a sorted set
+// and the task status in hashes.
+type RedisBackend struct {
+	client   *redis.Client
+	consumer string
+}
+
+// NewRedisBackend returns a Backend using the Redis client.
+func NewRedisBackend(rc *redis.Client) *RedisBackend {
+	host, _ := os.Hostname()
+	return &RedisBackend{
+		client:   rc,
+		consumer: fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.NewV4().String()[:8]),
+	}
+}
+
+func (b *RedisBackend) processingKey() string {
+	return keyProcessing + b.consumer
+}
+
+// Push adds a task to the pending list, or the delayed set when at is in the future.
+func (b *RedisBackend) Push(qt QueueTask, payload string, at time.Time) error {
+	now := time.Now()
+	_, err := b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		if at.After(now) {
+			pipe.ZAdd(keyDelayed, redis.Z{Score: float64(at.Unix()), Member: payload})
+		} else {
+			at = now
+			pipe.LPush(keyPending, payload)
+		}
+		track(pipe, qt, StatusQueued, payload, at)
+		return nil
+	})
+	return err
+}
+
+// Reserve waits for a pending task and moves it to the consumer's processing list.
+// With a zero timeout, the due delayed tasks are promoted first and it does not wait.
+func (b *RedisBackend) Reserve(timeout time.Duration) (*Delivery, error) {
+	var (
+		payload string
+		err     error
+	)
+	if timeout <= 0 {
+		if _, err := b.promote(time.Now()); err != nil {
+			return nil, err
+		}
+		payload, err = b.client.RPopLPush(keyPending, b.processingKey()).Result()
+	} else {
+		payload, err = b.client.BRPopLPush(keyPending, b.processingKey(), timeout).Result()
+	}
+	if err == redis.Nil {
+		return nil, ErrNoTask
+	} else if err != nil {
+		return nil, err
+	}
+	return &Delivery{Payload: payload}, nil
+}
+
+// Start marks a reserved task as running.
+func (b *RedisBackend) Start(d *Delivery) error {
+	if len(d.Task.UUID) == 0 {
+		return nil
+	}
+	now := time.Now()
+	return b.client.HMSet(keyTask+d.Task.UUID, map[string]interface{}{
+		"status":  string(StatusRunning),
+		"started": formatTime(now),
+		"updated": formatTime(now),
+	}).Err()
+}
+
+// Ack removes an executed task from the processing list.
+func (b *RedisBackend) Ack(d *Delivery) error {
+	_, err := b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LRem(b.processingKey(), 1, d.Payload)
+		if id := d.Task.UUID; len(id) > 0 {
+			now := time.Now()
+			pipe.HMSet(keyTask+id, map[string]interface{}{
+				"status":   string(StatusSucceeded),
+				"finished": formatTime(now),
+				"updated":  formatTime(now),
+			})
+			pipe.Expire(keyTask+id, SucceededRetention)
+		}
+		return nil
+	})
+	return err
+}
+
+// Retry replaces a reserved task by its next version scheduled at.
+func (b *RedisBackend) Retry(d *Delivery, next QueueTask, payload string, at time.Time) error {
+	_, err := b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LRem(b.processingKey(), 1, d.Payload)
+		pipe.ZAdd(keyDelayed, redis.Z{Score: float64(at.Unix()), Member: payload})
+		track(pipe, next, StatusFailed, payload, at)
+		return nil
+	})
+	return err
+}
+
+// Postpone puts a reserved task aside until at, it stays queued.
+func (b *RedisBackend) Postpone(d *Delivery, at time.Time) error {
+	_, err := b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LRem(b.processingKey(), 1, d.Payload)
+		pipe.ZAdd(keyDelayed, redis.Z{Score: float64(at.Unix()), Member: d.Payload})
+		track(pipe, d.Task, StatusQueued, d.Payload, at)
+		return nil
+	})
+	return err
+}
+
+// Bury moves a reserved task to the dead-letter list.
+func (b *RedisBackend) Bury(d *Delivery, next QueueTask, payload string) error {
+	_, err := b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LRem(b.processingKey(), 1, d.Payload)
+		pipe.LPush(keyDead, payload)
+		track(pipe, next, StatusDead, payload, time.Time{})
+		return nil
+	})
+	return err
+}
+
+// Allow counts an execution of the task in the current rate window across all consumers.
+func (b *RedisBackend) Allow(id TaskID, rate int64, per time.Duration) (time.Duration, error) {
+	window, left := rateWindow(id, per, time.Now())
+	key := keyRate + window
+
+	expire := int64((per + time.Second) / time.Millisecond)
+	count, err := rateScript.Run(b.client, []string{key}, expire).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	if count <= rate {
+		return 0, nil
+	}
+	return left, nil
+}
+
+// promote moves the delayed tasks that are due to the pending list.
+func (b *RedisBackend) promote(now time.Time) (int64, error) {
+	return promoteScript.Run(b.client, []string{keyDelayed, keyPending}, now.Unix(), promoteBatch).Int64()
+}
+
+// Subscribe announces the consumer and puts back the tasks of the consumers
+// that stopped without acknowledging them.
+func (b *RedisBackend) Subscribe() error {
+	if err := b.register(); err != nil {
+		return err
+	}
+	return b.recover()
+}
+
+// register refreshes the consumer heartbeat.
+func (b *RedisBackend) register() error {
+	_, err := b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.SAdd(keyConsumers, b.consumer)
+		pipe.Set(keyHeartbeat+b.consumer, time.Now().Unix(), heartbeatExpire)
+		return nil
+	})
+	return err
+}
+
+// Unsubscribe puts the tasks still held by the consumer back on the pending list.
+func (b *RedisBackend) Unsubscribe() error {
+	if err := b.requeueConsumer(b.consumer); err != nil {
+		return err
+	}
+	return b.client.Del(keyHeartbeat + b.consumer).Err()
+}
+
+// recover puts the tasks held by consumers whose heartbeat expired back on the
+// pending list, those tasks were reserved but never acknowledged.
+func (b *RedisBackend) recover() error {
+	consumers, err := b.client.SMembers(keyConsumers).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range consumers {
+		if c == b.consumer {
+			continue
+		}
+
+		n, err := b.client.Exists(keyHeartbeat + c).Result()
+		if err != nil {
+			return err
+		} else if n > 0 {
+			continue
+		}
+
+		if err := b.requeueConsumer(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *RedisBackend) requeueConsumer(consumer string) error {
+	keys := []string{keyProcessing + consumer, keyPending}
+	args := []interface{}{keyTask, string(StatusRunning), string(StatusQueued), formatTime(time.Now())}
+	if err := requeueScript.Run(b.client, keys, args...).Err(); err != nil {
+		return err
+	}
+	return b.client.SRem(keyConsumers, consumer).Err()
+}
+
+// Maintain keeps the heartbeat alive, promotes the delayed tasks and recovers
+// the tasks of dead consumers until done is closed.
+func (b *RedisBackend) Maintain(done <-chan struct{}) {
+	heartbeat := time.NewTicker(heartbeatInterval)
+	promote := time.NewTicker(promoteInterval)
+	defer heartbeat.Stop()
+	defer promote.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-heartbeat.C:
+			if err := b.register(); err != nil {
+				log.Println("unable to refresh the queue consumer heartbeat", err)
+			}
+			if err := b.recover(); err != nil {
+				log.Println("unable to recover tasks from dead queue consumers", err)
+			}
+		case now := <-promote.C:
+			if _, err := b.promote(now); err != nil {
+				log.Println("unable to promote the delayed tasks", err)
+			}
+		}
+	}
+}
+
+// track records the status of a task in the pipeline, the payload is the task as
+// currently held by the queue.
+func track(pipe redis.Pipeliner, qt QueueTask, status Status, payload string, runAt time.Time) {
+	if len(qt.UUID) == 0 {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"id":        string(qt.ID),
+		"status":    string(status),
+		"attempts":  qt.Attempts,
+		"lastError": qt.LastError,
+		"payload":   payload,
+		"created":   formatTime(qt.Created),
+		"updated":   formatTime(time.Now()),
+		"runAt":     formatTime(runAt),
+	}
+
+	key := keyTask + qt.UUID
+	pipe.HMSet(key, fields)
+	pipe.Persist(key)
+	pipe.ZAdd(keyTasks, redis.Z{Score: float64(qt.Created.UnixNano()), Member: qt.UUID})
+}
+
+// Inspect returns the status of a task.
+func (b *RedisBackend) Inspect(uuid string) (*TaskInfo, error) {
+	m, err := b.client.HGetAll(keyTask + uuid).Result()
+	if err != nil {
+		return nil, err
+	} else if len(m) == 0 {
+		return nil, ErrTaskNotFound
+	}
+
+	ti := &TaskInfo{
+		UUID:      uuid,
+		ID:        TaskID(m["id"]),
+		Status:    Status(m["status"]),
+		LastError: m["lastError"],
+		payload:   m["payload"],
+	}
+	ti.Attempts, _ = strconv.Atoi(m["attempts"])
+	if t := parseTime(m["created"]); t != nil {
+		ti.Created = *t
+	}
+	if t := parseTime(m["updated"]); t != nil {
+		ti.Updated = *t
+	}
+	ti.RunAt = parseTime(m["runAt"])
+	ti.Started = parseTime(m["started"])
+	ti.Finished = parseTime(m["finished"])
+	return ti.fill()
+}
+
+// Tasks returns the tasks with the status, or all of them if status is empty,
+// the most recent first.
+func (b *RedisBackend) Tasks(status Status, offset, limit int) ([]TaskInfo, error) {
+	uuids, err := b.client.ZRevRange(keyTasks, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]TaskInfo, 0)
+	for _, uuid := range uuids {
+		ti, err := b.Inspect(uuid)
+		if err == ErrTaskNotFound {
+			// expired, removed from the index lazily
+			b.client.ZRem(keyTasks, uuid)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(status) > 0 && ti.Status != status {
+			continue
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		tasks = append(tasks, *ti)
+		if limit > 0 && len(tasks) >= limit {
+			break
+		}
+	}
+	return tasks, nil
+}
+
+// Requeue queues a dead or failed task for immediate execution.
+func (b *RedisBackend) Requeue(uuid string) error {
+	ti, err := b.Inspect(uuid)
+	if err != nil {
+		return err
+	}
+
+	switch ti.Status {
+	case StatusDead:
+		n, err := b.client.LRem(keyDead, 1, ti.payload).Result()
+		if err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("task %s is not in the dead-letter list", uuid)
+		}
+	case StatusFailed:
+		n, err := b.client.ZRem(keyDelayed, ti.payload).Result()
+		if err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("task %s is no longer waiting for a retry", uuid)
+		}
+	default:
+		return errNotRetryable(ti)
+	}
+
+	qt, payload, err := resetAttempts(ti.payload)
+	if err != nil {
+		return err
+	}
+	return b.Push(qt, payload, time.Time{})
+}
+
+// Purge removes a task from the queue and its status.
+func (b *RedisBackend) Purge(uuid string) error {
+	ti, err := b.Inspect(uuid)
+	if err != nil {
+		return err
+	}
+
+	if ti.Status == StatusRunning {
+		return fmt.Errorf("task %s is running", uuid)
+	}
+
+	_, err = b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		switch ti.Status {
+		case StatusDead:
+			pipe.LRem(keyDead, 1, ti.payload)
+		case StatusQueued, StatusFailed:
+			pipe.LRem(keyPending, 1, ti.payload)
+			pipe.ZRem(keyDelayed, ti.payload)
+		}
+		pipe.Del(keyTask + uuid)
+		pipe.ZRem(keyTasks, uuid)
+		return nil
+	})
+	return err
+}
+
+// PurgeStatus purges all the tasks with the status. Purging the dead tasks empties
+// the dead-letter list, including the tasks that could not be decoded.
+func (b *RedisBackend) PurgeStatus(status Status) (int, error) {
+	tasks, err := b.Tasks(status, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, ti := range tasks {
+		if err := b.Purge(ti.UUID); err != nil && err != ErrTaskNotFound {
+			return n, err
+		}
+		n++
+	}
+
+	if status == StatusDead {
+		if err := b.client.Del(keyDead).Err(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,176 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Versioned is implemented by payloads whose format changed over time. Tasks are
+// enqueued with the payload's TaskVersion, older tasks still in the queue are
+// converted via the Upgrade functions given to Register before being decoded.
+//
+// Payloads not implementing it are version 1.
+type Versioned interface {
+	TaskVersion() int
+}
+
+// UpgradeFunc converts a payload to the next version.
+type UpgradeFunc func(data json.RawMessage) (json.RawMessage, error)
+
+// Option configures a registered task.
+type Option func(r *registration)
+
+// Upgrade converts the payloads of version from to version from+1.
+func Upgrade(from int, fn UpgradeFunc) Option {
+	return func(r *registration) {
+		r.upgrades[from] = fn
+	}
+}
+
+// WithRetryPolicy sets the retry policy of the task, see SetRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(r *registration) {
+		SetRetryPolicy(r.id, p)
+	}
+}
+
+// WithLimits sets the concurrency and rate limits of the task, see SetTaskLimits.
+func WithLimits(l TaskLimits) Option {
+	return func(r *registration) {
+		SetTaskLimits(r.id, l)
+	}
+}
+
+type registration struct {
+	id       TaskID
+	version  int
+	upgrades map[int]UpgradeFunc
+	run      func(ctx context.Context, version int, data json.RawMessage) error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[TaskID]*registration)
+)
+
+// Register sets the function executing the task id. The payload is decoded from
+// JSON into a T, the same type must be used to Enqueue the task:
+//
+//	type WelcomeEmail struct {
+//		UserID int64
+//	}
+//
+//	queue.Register("app.welcome-email", func(ctx context.Context, p WelcomeEmail) error {
+//		...
+//	})
+//
+//	queue.Enqueue("app.welcome-email", WelcomeEmail{UserID: 1})
+//
+// Tasks should be registered on every instance before enqueuing or consuming them.
+// Register panics if id is already registered. Task names starting with "gosaas."
+// are reserved for the built-in tasks.
+func Register[T any](id TaskID, fn func(ctx context.Context, payload T) error, opts ...Option) {
+	if fn == nil {
+		panic("queue: nil task function for " + string(id))
+	}
+
+	var zero T
+	r := &registration{
+		id:       id,
+		version:  payloadVersion(zero),
+		upgrades: make(map[int]UpgradeFunc),
+	}
+
+	r.run = func(ctx context.Context, version int, data json.RawMessage) error {
+		data, err := r.upgrade(version, data)
+		if err != nil {
+			return Permanent(err)
+		}
+
+		var payload T
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &payload); err != nil {
+				return Permanent(fmt.Errorf("unable to decode the %s task payload: %v", id, err))
+			}
+		}
+		return fn(ctx, payload)
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[id]; ok {
+		panic("queue: task " + string(id) + " is already registered")
+	}
+	registry[id] = r
+}
+
+func registered(id TaskID) *registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry[id]
+}
+
+// upgrade converts data from version to the registered version.
+func (r *registration) upgrade(version int, data json.RawMessage) (json.RawMessage, error) {
+	if version == 0 {
+		version = 1
+	}
+
+	if version > r.version {
+		return nil, fmt.Errorf("task %s payload version %d is newer than version %d", r.id, version, r.version)
+	}
+
+	for v := version; v < r.version; v++ {
+		fn, ok := r.upgrades[v]
+		if !ok {
+			return nil, fmt.Errorf("no upgrade from version %d for task %s", v, r.id)
+		}
+
+		var err error
+		if data, err = fn(data); err != nil {
+			return nil, fmt.Errorf("unable to upgrade task %s payload from version %d: %v", r.id, v, err)
+		}
+	}
+	return data, nil
+}
+
+func payloadVersion(payload interface{}) int {
+	if v, ok := payload.(Versioned); ok && v.TaskVersion() > 0 {
+		return v.TaskVersion()
+	}
+	return 1
+}
+
+// PermanentError is a task failure that will not be retried.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks an error as permanent, the task is moved to the dead-letter
+// list instead of being retried, i.e. for invalid payloads.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+func isPermanent(err error) bool {
+	var pe *PermanentError
+	return errors.As(err, &pe)
+}
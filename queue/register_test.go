@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type invoiceV1 struct {
+	Customer string
+}
+
+type invoiceV2 struct {
+	Customer string
+	Amount   int64
+	Due      time.Time
+	Items    []string
+}
+
+func (invoiceV2) TaskVersion() int { return 2 }
+
+// register registers a task for the duration of the test.
+func register[T any](t *testing.T, id TaskID, fn func(ctx context.Context, payload T) error, opts ...Option) {
+	Register(id, fn, opts...)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, id)
+		registryMu.Unlock()
+	})
+}
+
+func TestRegister_TypedPayload(t *testing.T) {
+	b := newTestBackend(t)
+
+	var got invoiceV2
+	register(t, "test.typed", func(ctx context.Context, p invoiceV2) error {
+		got = p
+		return nil
+	})
+
+	due := time.Date(2019, 3, 4, 0, 0, 0, 0, time.UTC)
+	want := invoiceV2{Customer: "cus_1", Amount: 9007199254740993, Due: due, Items: []string{"a", "b"}}
+	if _, err := Enqueue("test.typed", want); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := b.Reserve(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process(b, d)
+
+	if got.Customer != want.Customer || got.Amount != want.Amount || !got.Due.Equal(due) || len(got.Items) != 2 {
+		t.Errorf("got %+v expected %+v", got, want)
+	}
+	if n := b.client.LLen(b.processingKey()).Val(); n != 0 {
+		t.Errorf("processing list has %d tasks, expected 0", n)
+	}
+}
+
+func TestRegister_UpgradePayload(t *testing.T) {
+	var got invoiceV2
+	register(t, "test.upgrade", func(ctx context.Context, p invoiceV2) error {
+		got = p
+		return nil
+	}, Upgrade(1, func(data json.RawMessage) (json.RawMessage, error) {
+		var v1 invoiceV1
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return nil, err
+		}
+		return json.Marshal(invoiceV2{Customer: v1.Customer, Amount: 100})
+	}))
+
+	// a task enqueued before the payload changed
+	qt, err := decodeTask(`{"id":"test.upgrade","data":{"Customer":"cus_1"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(context.Background(), qt); err != nil {
+		t.Fatal(err)
+	}
+	if got.Customer != "cus_1" || got.Amount != 100 {
+		t.Errorf("got %+v", got)
+	}
+
+	// newer than the registered version
+	qt.Version = 3
+	if err := run(context.Background(), qt); !isPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestRegister_PermanentErrors(t *testing.T) {
+	b := newTestBackend(t)
+
+	register(t, "test.permanent", func(ctx context.Context, p invoiceV1) error {
+		return Permanent(fmt.Errorf("invalid customer"))
+	})
+
+	// invalid payload type and permanent failures are not retried
+	if _, err := Enqueue("test.permanent", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Enqueue("test.permanent", invoiceV1{Customer: "cus_1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		d, err := b.Reserve(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		process(b, d)
+	}
+
+	if n := b.client.LLen(keyDead).Val(); n != 2 {
+		t.Errorf("dead-letter list has %d tasks, expected 2", n)
+	}
+	if n := b.client.ZCard(keyDelayed).Val(); n != 0 {
+		t.Errorf("delayed set has %d tasks, expected 0", n)
+	}
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic registering a task twice")
+		}
+	}()
+
+	Register(TaskEmail, func(ctx context.Context, p SendEmailParameter) error { return nil })
+}
@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// RetryPolicy defines how a failed task is retried. The delay before a retry
+// starts at Backoff and doubles on each attempt, up to MaxBackoff. Once
+// MaxRetries is reached the task is moved to the dead-letter list.
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used for tasks without a policy set via SetRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 5,
+	Backoff:    30 * time.Second,
+	MaxBackoff: 1 * time.Hour,
+}
+
+var (
+	retryMu       sync.RWMutex
+	retryPolicies = make(map[TaskID]RetryPolicy)
+)
+
+// SetRetryPolicy sets the retry policy of a task.
+func SetRetryPolicy(id TaskID, p RetryPolicy) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	retryPolicies[id] = p
+}
+
+func retryPolicy(id TaskID) RetryPolicy {
+	retryMu.RLock()
+	defer retryMu.RUnlock()
+	if p, ok := retryPolicies[id]; ok {
+		return p
+	}
+	return DefaultRetryPolicy
+}
+
+// delay returns the backoff before the given attempt, attempts start at 1.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Status is the state of a queued task.
+type Status string
+
+const (
+	// StatusQueued tasks are waiting to be executed, possibly at a later time.
+	StatusQueued Status = "queued"
+	// StatusRunning tasks are being executed.
+	StatusRunning Status = "running"
+	// StatusSucceeded tasks were executed successfully.
+	StatusSucceeded Status = "succeeded"
+	// StatusFailed tasks failed and are waiting for a retry.
+	StatusFailed Status = "failed"
+	// StatusDead tasks failed and will not be retried.
+	StatusDead Status = "dead"
+)
+
+// SucceededRetention is how long succeeded tasks are kept, the others are
+// kept until they succeed or are purged.
+var SucceededRetention = 7 * 24 * time.Hour
+
+// ErrTaskNotFound is returned when a task does not exist or has expired.
+var ErrTaskNotFound = fmt.Errorf("task not found")
+
+// TaskInfo holds the status of a queued task.
+type TaskInfo struct {
+	UUID      string          `json:"uuid"`
+	ID        TaskID          `json:"id"`
+	Status    Status          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"lastError,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Created   time.Time       `json:"created"`
+	Updated   time.Time       `json:"updated"`
+	// RunAt is the next execution time of queued and failed tasks.
+	RunAt    *time.Time `json:"runAt,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	payload string
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// fill sets the fields derived from the status and the payload.
+func (ti *TaskInfo) fill() (*TaskInfo, error) {
+	if ti.Status != StatusQueued && ti.Status != StatusFailed {
+		ti.RunAt = nil
+	}
+
+	if len(ti.payload) > 0 {
+		qt, err := decodeTask(ti.payload)
+		if err != nil {
+			return nil, err
+		}
+		ti.Data = qt.raw
+	}
+	return ti, nil
+}
+
+// resetAttempts returns the task of the payload with its attempts reset.
+func resetAttempts(payload string) (QueueTask, string, error) {
+	qt, err := decodeTask(payload)
+	if err != nil {
+		return qt, "", err
+	}
+	qt.Attempts = 0
+
+	p, err := encodeTask(qt)
+	return qt, p, err
+}
+
+func errNotRetryable(ti *TaskInfo) error {
+	return fmt.Errorf("only dead and failed tasks can be retried, task %s is %s", ti.UUID, ti.Status)
+}
+
+// Inspect returns the status of a task.
+func Inspect(uuid string) (*TaskInfo, error) {
+	b, err := currentBackend()
+	if err != nil {
+		return nil, err
+	}
+	return b.Inspect(uuid)
+}
+
+// Tasks returns the tasks with the status, or all of them if status is empty,
+// the most recent first.
+func Tasks(status Status, offset, limit int) ([]TaskInfo, error) {
+	b, err := currentBackend()
+	if err != nil {
+		return nil, err
+	}
+	return b.Tasks(status, offset, limit)
+}
+
+// Retry queues a dead or failed task for immediate execution, its attempts
+// are reset.
+func Retry(uuid string) error {
+	b, err := currentBackend()
+	if err != nil {
+		return err
+	}
+	return b.Requeue(uuid)
+}
+
+// Purge removes a task from the queue and its status. Running tasks cannot be purged.
+func Purge(uuid string) error {
+	b, err := currentBackend()
+	if err != nil {
+		return err
+	}
+	return b.Purge(uuid)
+}
+
+// PurgeStatus purges all the tasks with the status, it returns the number of tasks
+// purged.
+func PurgeStatus(status Status) (int, error) {
+	if status == StatusRunning {
+		return 0, fmt.Errorf("running tasks cannot be purged")
+	}
+
+	b, err := currentBackend()
+	if err != nil {
+		return 0, err
+	}
+	return b.PurgeStatus(status)
+}
@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatus_Lifecycle(t *testing.T) {
+	b := newTestBackend(t)
+
+	uuid, err := Enqueue(taskTestOK, "data")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(uuid) == 0 {
+		t.Fatal("expected a task UUID")
+	}
+
+	ti, err := Inspect(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ti.Status != StatusQueued || ti.ID != taskTestOK || string(ti.Data) != `"data"` || ti.RunAt == nil {
+		t.Errorf("unexpected queued task %+v", ti)
+	}
+
+	d, err := b.Reserve(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process(b, d)
+
+	ti, err = Inspect(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ti.Status != StatusSucceeded || ti.Started == nil || ti.Finished == nil || ti.RunAt != nil {
+		t.Errorf("unexpected succeeded task %+v", ti)
+	}
+	if ttl := b.client.TTL(keyTask + uuid).Val(); ttl <= 0 || ttl > SucceededRetention {
+		t.Errorf("succeeded task expires in %v", ttl)
+	}
+}
+
+func TestStatus_FailedDeadAndRetry(t *testing.T) {
+	b := newTestBackend(t)
+
+	SetRetryPolicy(taskTestFail, RetryPolicy{MaxRetries: 1, Backoff: time.Minute})
+	defer SetRetryPolicy(taskTestFail, DefaultRetryPolicy)
+
+	uuid, err := Enqueue(taskTestFail, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, _ := b.Reserve(time.Second)
+	process(b, d)
+
+	ti, err := Inspect(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ti.Status != StatusFailed || ti.Attempts != 1 || ti.LastError != "boom" || ti.RunAt == nil {
+		t.Errorf("unexpected failed task %+v", ti)
+	}
+
+	// retry now instead of waiting for the backoff
+	if err := Retry(uuid); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.client.ZCard(keyDelayed).Val(); n != 0 {
+		t.Errorf("delayed set has %d tasks, expected 0", n)
+	}
+
+	SetRetryPolicy(taskTestFail, RetryPolicy{})
+	d, _ = b.Reserve(time.Second)
+	process(b, d)
+
+	dead, err := Tasks(StatusDead, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(dead) != 1 || dead[0].UUID != uuid || dead[0].Attempts != 1 {
+		t.Fatalf("unexpected dead tasks %+v", dead)
+	}
+
+	if err := Retry(uuid); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.client.LLen(keyDead).Val(); n != 0 {
+		t.Errorf("dead-letter list has %d tasks, expected 0", n)
+	}
+
+	ti, _ = Inspect(uuid)
+	if ti.Status != StatusQueued || ti.Attempts != 0 {
+		t.Errorf("unexpected retried task %+v", ti)
+	}
+
+	if err := Retry(uuid); err == nil {
+		t.Error("queued tasks cannot be retried")
+	}
+}
+
+func TestStatus_ListAndPurge(t *testing.T) {
+	b := newTestBackend(t)
+
+	var uuids []string
+	for i := 0; i < 3; i++ {
+		uuid, err := Enqueue(taskTestOK, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uuids = append(uuids, uuid)
+	}
+	later, _ := EnqueueIn(taskTestOK, "later", time.Hour)
+
+	tasks, err := Tasks(StatusQueued, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(tasks) != 2 || tasks[0].UUID != uuids[2] || tasks[1].UUID != uuids[1] {
+		t.Errorf("unexpected tasks %+v", tasks)
+	}
+
+	if err := Purge(later); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.client.ZCard(keyDelayed).Val(); n != 0 {
+		t.Errorf("delayed set has %d tasks, expected 0", n)
+	}
+	if _, err := Inspect(later); err != ErrTaskNotFound {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+
+	n, err := PurgeStatus(StatusQueued)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Errorf("purged %d tasks, expected 3", n)
+	}
+	if n := b.client.LLen(keyPending).Val(); n != 0 {
+		t.Errorf("pending list has %d tasks, expected 0", n)
+	}
+}
@@ -1,71 +1,80 @@
 package queue
 
 import (
-	"errors"
-	"fmt"
-	"reflect"
+	"encoding/json"
 	"time"
 )
 
-// TaskID are IDs representing a specific queued task. Each application task has an associated ID
-type TaskID int
+// TaskID are names representing a specific queued task. Each application task has an
+// associated name, applications should prefix theirs to avoid collisions, i.e. "app.report".
+type TaskID string
 
 const (
 	// TaskEmail is for sending email.
-	TaskEmail TaskID = iota
+	TaskEmail TaskID = "gosaas.email"
 	// TaskCreateInvoice is for creating new Stripe invoice.
-	TaskCreateInvoice
+	TaskCreateInvoice TaskID = "gosaas.create-invoice"
+	// TaskDunning is for sending the reminders to the past due accounts and
+	// downgrading them after the grace period.
+	TaskDunning TaskID = "gosaas.dunning"
 )
 
 // QueueTask represents a queued task.
 //
-// The Data field contains the necessary data for the task to execute properly.
+// UUID uniquely identifies the task, it is returned by Enqueue and used to
+// inspect the task status. The Data field contains the necessary data for the task to execute properly, for
+// TaskExecutor it is the payload decoded as generic JSON values. Version is the
+// version of the payload format, see Versioned. Attempts and LastError are updated
+// each time the task fails.
 type QueueTask struct {
-	ID      TaskID      `json:"id"`
-	Data    interface{} `json:"data"`
-	Created time.Time   `json:"created"`
+	UUID      string      `json:"uuid,omitempty"`
+	ID        TaskID      `json:"id"`
+	Version   int         `json:"version,omitempty"`
+	Data      interface{} `json:"data"`
+	Created   time.Time   `json:"created"`
+	Attempts  int         `json:"attempts,omitempty"`
+	LastError string      `json:"lastError,omitempty"`
+
+	raw json.RawMessage
 }
 
 // TaskExecutor is an interface used to execute tasks based on their ID.
+//
+// Prefer Register for new tasks, it decodes the payload into its original type.
 type TaskExecutor interface {
 	Run(t QueueTask) error
 }
 
-// Since we’re wrapping a struct inside a QueueTask as an interface, they’re serialized
-// as a map[string]interface{}. We need a way to convert that map back
-// to the original struct. setField and fillStructs do that
-func setField(obj interface{}, name string, value interface{}) error {
-	structValue := reflect.ValueOf(obj).Elem()
-	structFieldValue := structValue.FieldByName(name)
-
-	if !structFieldValue.IsValid() {
-		return fmt.Errorf("no such field: %s in obj", name)
+// decodeTask decodes a queued task keeping its raw payload.
+func decodeTask(payload string) (QueueTask, error) {
+	var env struct {
+		QueueTask
+		Data json.RawMessage `json:"data"`
 	}
-
-	if !structFieldValue.CanSet() {
-		return fmt.Errorf("cannot set %s field value", name)
+	if err := json.Unmarshal([]byte(payload), &env); err != nil {
+		return QueueTask{}, err
 	}
 
-	structFieldType := structFieldValue.Type()
-	val := reflect.ValueOf(value)
-	if structFieldType != val.Type() {
-		invalidTypeError := errors.New("provided value type didn't match obj field type")
-		return invalidTypeError
+	qt := env.QueueTask
+	qt.raw = env.Data
+	if len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, &qt.Data); err != nil {
+			return QueueTask{}, err
+		}
 	}
-
-	structFieldValue.Set(val)
-	return nil
+	return qt, nil
 }
 
-// fillStruct receives a pointer to a structure to be filled from the map.
-// Each entry in the map calls setField() to set the value of that field in
-// the structure
-func fillStruct(s interface{}, m map[string]interface{}) error {
-	for k, v := range m {
-		err := setField(s, k, v)
-		if err != nil {
-			return err
-		}
+// encodeTask encodes a task, the raw payload is kept as is when the task was decoded
+// so values do not lose precision going through interface{}.
+func encodeTask(qt QueueTask) (string, error) {
+	if qt.raw != nil {
+		qt.Data = qt.raw
+	}
+
+	b, err := json.Marshal(qt)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return string(b), nil
 }
@@ -9,49 +9,51 @@ import (
 )
 
 // RateLimiter is a middleware used to prevent too many call in short time span.
-// If the maximum allowed requests per-user is reached it will return a StatusTooManyRequests error.
+// If the maximum allowed requests per-account is reached it will return a StatusTooManyRequests error.
+//
+// The per minute limit is resolved per account from its billing plan "perMinute" param,
+// see RateLimits. Anonymous requests are counted per client IP.
 //
 // For clarity if maximum is reached a "Retry-After" HTTP header with the time in second
-// the user will need to wait before sending another request.
+// the user will need to wait before sending another request. The rate limit headers
+// are set the same way as the Throttler ones.
+//
+// RateLimiter uses the cache.SlidingWindow algorithm, use NewRateLimiter to choose another one.
 func RateLimiter(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var keys Auth
-
-		ctx := r.Context()
-		v := ctx.Value(ContextAuth)
-		if v == nil {
-			keys = Auth{}
-		} else {
-			a, ok := v.(Auth)
-			if ok {
-				keys = a
-			}
-		}
+	return NewRateLimiter(cache.SlidingWindow)(next)
+}
 
-		key := fmt.Sprintf("%v", keys.AccountID)
+// NewRateLimiter returns a RateLimiter middleware using the alg rate limiting algorithm.
+//
+//	mux := gosaas.NewServer(routes)
+//	mux.RateLimiter = gosaas.NewRateLimiter(cache.TokenBucket)
+func NewRateLimiter(alg cache.Algorithm) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
 
-		// TODO: Make this configurable
-		count, err := cache.RateLimit(key, 1*time.Minute)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+			key := rateLimitKey(r) + "_rl"
 
-		// TODO: Make this configurable
-		if count >= 60 {
-			// we get the expiration duration of this key so we can notify the user
-			d, err := cache.GetThrottleExpiration(key)
+			limits := resolveRateLimits(ctx)
+			res, err := cache.Allow(key, alg, limits.PerMinute, 1*time.Minute)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				Respond(w, r, http.StatusInternalServerError, err)
 				return
 			}
-			if d.Seconds() > 0 {
-				w.Header().Set("Retry-After", fmt.Sprintf("%d", int(d.Seconds())))
+
+			setRateLimitHeaders(w, res)
+
+			if !res.Allowed {
+				// we notify the user of how long before retrying
+				secs := ceilSeconds(res.RetryAfter)
+				if secs > 0 {
+					w.Header().Set("Retry-After", fmt.Sprintf("%d", secs))
+				}
+				Respond(w, r, http.StatusTooManyRequests, fmt.Errorf("you've reached your rate limit, retry in %ds", secs))
+				return
 			}
-			http.Error(w, fmt.Sprintf("you've reached your rate limit, retry in %v", d), http.StatusTooManyRequests)
-			return
-		}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
@@ -14,6 +14,14 @@ type Route struct {
 	EnforceRateLimit bool
 	AllowCrossOrigin bool
 	GzipCompression  bool
+	Idempotent       bool
+	CSRFProtection   bool
+
+	// RateLimits overrides the account's plan quotas when EnforceRateLimit is set.
+	RateLimits *RateLimits
+
+	// CorsPolicy overrides the configured CORS policy when AllowCrossOrigin is set.
+	CorsPolicy *CorsPolicy
 
 	// authorization
 	MinimumRole model.Roles
@@ -0,0 +1,165 @@
+package gosaas
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+// SecurityPolicy defines the security headers set by the Security middleware.
+// It is read from the "security" key of the gosaas.json config file.
+type SecurityPolicy = config.SecurityPolicy
+
+const (
+	// CSRFCookieName is the cookie holding the CSRF token.
+	CSRFCookieName = "X-CSRF-TOKEN"
+	// CSRFFieldName is the form field HTML forms must post the CSRF token into.
+	CSRFFieldName = "csrf_token"
+	// CSRFHeaderName is the HTTP header that may carry the CSRF token instead of the form field.
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// Security is a middleware that adds the security headers (HSTS, Content-Security-Policy,
+// X-Frame-Options, Referrer-Policy and X-Content-Type-Options) to every response.
+//
+// It also makes sure the browser holds a CSRF token cookie. The token is available
+// to HTML templates via the CSRFToken field of ViewData:
+//
+//	<input type="hidden" name="csrf_token" value="{{ .CSRFToken }}">
+func Security(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setSecurityHeaders(w, r, config.Current.Security)
+
+		token := ""
+		if ck, err := r.Cookie(CSRFCookieName); err == nil && len(ck.Value) > 0 {
+			token = ck.Value
+		} else {
+			t, err := newCSRFToken()
+			if err != nil {
+				log.Println("unable to generate a CSRF token", err)
+			} else {
+				token = t
+				http.SetCookie(w, &http.Cookie{
+					Name:     CSRFCookieName,
+					Path:     "/",
+					Value:    token,
+					HttpOnly: true,
+					Secure:   isHTTPS(r),
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), ContextCSRFToken, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CSRF is a middleware verifying the CSRF token of non-JSON requests changing state.
+//
+// The token posted in the csrf_token form field (or the X-CSRF-Token header) must
+// match the CSRF cookie, otherwise a StatusForbidden error is returned. Requests
+// authenticated via the X-API-KEY header or basic authentication are not affected
+// since browsers never send those automatically.
+func CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requiresCSRFCheck(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ck, err := r.Cookie(CSRFCookieName)
+		if err != nil || len(ck.Value) == 0 {
+			Respond(w, r, http.StatusForbidden, fmt.Errorf("missing CSRF cookie"))
+			return
+		}
+
+		token := r.Header.Get(CSRFHeaderName)
+		if len(token) == 0 {
+			token = r.PostFormValue(CSRFFieldName)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(ck.Value)) != 1 {
+			Respond(w, r, http.StatusForbidden, fmt.Errorf("invalid CSRF token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requiresCSRFCheck(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+
+	// JSON cannot be posted cross-site without a CORS preflight
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mt == "application/json" {
+		return false
+	}
+
+	if len(r.Header.Get("X-API-KEY")) > 0 || len(r.Header.Get("Authorization")) > 0 {
+		return false
+	}
+
+	return true
+}
+
+func setSecurityHeaders(w http.ResponseWriter, r *http.Request, p SecurityPolicy) {
+	headers := w.Header()
+
+	headers.Set("X-Content-Type-Options", "nosniff")
+
+	fo := p.FrameOptions
+	if len(fo) == 0 {
+		fo = "DENY"
+	}
+	headers.Set("X-Frame-Options", fo)
+
+	rp := p.ReferrerPolicy
+	if len(rp) == 0 {
+		rp = "strict-origin-when-cross-origin"
+	}
+	headers.Set("Referrer-Policy", rp)
+
+	if len(p.ContentSecurityPolicy) > 0 {
+		headers.Set("Content-Security-Policy", p.ContentSecurityPolicy)
+	}
+
+	if p.HSTSMaxAge > 0 && isHTTPS(r) {
+		hsts := fmt.Sprintf("max-age=%d", p.HSTSMaxAge)
+		if p.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if p.HSTSPreload {
+			hsts += "; preload"
+		}
+		headers.Set("Strict-Transport-Security", hsts)
+	}
+}
+
+// isHTTPS returns if the request was made over TLS, the X-Forwarded-Proto
+// header is only used when the request comes from a trusted proxy.
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return isFromTrustedProxy(r) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,103 @@
+package gosaas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jlb922/gosaas/internal/config"
+)
+
+func Test_Security_HeadersAndToken(t *testing.T) {
+	var token string
+	h := Security(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CreateViewData(r.Context(), nil, nil).CSRFToken
+	}))
+
+	req := httptest.NewRequest("GET", "https://domain.com/users/login", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Header().Get("X-Frame-Options") == "" {
+		t.Error("expected X-Frame-Options header to be set")
+	} else if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("expected X-Content-Type-Options to be nosniff")
+	}
+
+	if len(token) == 0 {
+		t.Fatal("expected a CSRF token in the ViewData")
+	}
+
+	var ck *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == CSRFCookieName {
+			ck = c
+		}
+	}
+	if ck == nil {
+		t.Fatal("expected the CSRF cookie to be set")
+	} else if ck.Value != token {
+		t.Errorf("expected cookie value %s got %s", token, ck.Value)
+	}
+}
+
+func Test_Security_CSRFVerification(t *testing.T) {
+	h := CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	post := func(cookie, field, contentType string) int {
+		form := url.Values{}
+		form.Set(CSRFFieldName, field)
+
+		req := httptest.NewRequest("POST", "/users/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", contentType)
+		if len(cookie) > 0 {
+			req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: cookie})
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	form := "application/x-www-form-urlencoded"
+	if code := post("abc", "abc", form); code != http.StatusOK {
+		t.Errorf("valid token: expected status %d got %d", http.StatusOK, code)
+	}
+	if code := post("abc", "xyz", form); code != http.StatusForbidden {
+		t.Errorf("invalid token: expected status %d got %d", http.StatusForbidden, code)
+	}
+	if code := post("", "abc", form); code != http.StatusForbidden {
+		t.Errorf("missing cookie: expected status %d got %d", http.StatusForbidden, code)
+	}
+	if code := post("", "", "application/json; charset=utf-8"); code != http.StatusOK {
+		t.Errorf("JSON request: expected status %d got %d", http.StatusOK, code)
+	}
+}
+
+func Test_Security_ForwardedProto(t *testing.T) {
+	config.Current.TrustedProxies = []string{"10.0.0.0/8"}
+	defer func() { config.Current.TrustedProxies = nil }()
+
+	tests := []struct {
+		remote string
+		https  bool
+	}{
+		// a client cannot pretend the request was made over https
+		{"203.0.113.5:1234", false},
+		{"10.0.0.2:1234", true},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set("X-Forwarded-Proto", "https")
+
+		if https := isHTTPS(req); https != tt.https {
+			t.Errorf("remote %s: expected https %v got %v", tt.remote, tt.https, https)
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 
 	"github.com/jlb922/gosaas/data"
 	"github.com/jlb922/gosaas/internal/config"
@@ -34,15 +36,31 @@ func init() {
 //
 // Responsible for routing requests to handlers.
 type Server struct {
-	DB              *data.DB
-	Logger          func(http.Handler) http.Handler
-	Authenticator   func(http.Handler) http.Handler
-	Throttler       func(http.Handler) http.Handler
-	RateLimiter     func(http.Handler) http.Handler
-	Cors            func(http.Handler) http.Handler
-	Gzip            func(http.Handler) http.Handler
+	DB            *data.DB
+	Logger        func(http.Handler) http.Handler
+	Authenticator func(http.Handler) http.Handler
+	Throttler     func(http.Handler) http.Handler
+	RateLimiter   func(http.Handler) http.Handler
+	Cors          func(http.Handler) http.Handler
+	Gzip          func(http.Handler) http.Handler
+	Idempotency   func(http.Handler) http.Handler
+	Security      func(http.Handler) http.Handler
+	CSRF          func(http.Handler) http.Handler
+	Routes        map[string]*Route
+
+	// Static serves the static assets, files under "./public" on the
+	// "/public/" URL path by default.
+	Static *Static
+
+	// StaticDirectory is the URL path of the static files, they are served
+	// from the directory of the same name, i.e. "/public/".
+	//
+	// Deprecated: set Static instead, StaticDirectory is used when it differs
+	// from the Static's prefix.
 	StaticDirectory string
-	Routes          map[string]*Route
+
+	staticMu  sync.Mutex
+	directory *Static
 }
 
 // NewServer returns a production server with all available middlewares.
@@ -56,6 +74,10 @@ type Server struct {
 //
 // 3. webhooks: for allowing users to subscribe to events (you may trigger webhook via gosaas.SendWebhook).
 //
+// 4. email: for the delivery events of the email providers updating the email outbox.
+//
+// 5. stripe: for the Stripe webhook events updating the accounts' billing state.
+//
 // To override default inplementation you simply have to supply your own like so:
 //
 // 	routes := make(map[string]*gosaas.Route)
@@ -81,15 +103,26 @@ func NewServer(routes map[string]*Route) *Server {
 		routes["tools"] = newTool()
 	}
 
+	if _, ok := routes["email"]; !ok {
+		routes["email"] = newEmail()
+	}
+
+	if _, ok := routes["stripe"]; !ok {
+		routes["stripe"] = newStripeWebhook()
+	}
+
 	return &Server{
-		Logger:          Logger,
-		Authenticator:   Authenticator,
-		Throttler:       Throttler,
-		RateLimiter:     RateLimiter,
-		Cors:            Cors,
-		Gzip:            Gzip,
-		StaticDirectory: "/public/",
-		Routes:          routes,
+		Logger:        Logger,
+		Authenticator: Authenticator,
+		Throttler:     Throttler,
+		RateLimiter:   RateLimiter,
+		Cors:          Cors,
+		Gzip:          Gzip,
+		Idempotency:   Idempotency,
+		Security:      Security,
+		CSRF:          CSRF,
+		Routes:        routes,
+		Static:        NewStatic(os.DirFS("public"), "/public/"),
 	}
 }
 
@@ -99,21 +132,28 @@ func NewServer(routes map[string]*Route) *Server {
 // If no route can be found an error is returned.
 //
 // Static files are served from the "/public/" directory by default. To change this
-// you may set the StaticDirectory after creating the server like this:
+// you may set the Static after creating the server like this:
 //
 // 	mux := gosaas.NewServer(routes)
-// 	mux.StaticDirectory = "/files/"
+// 	mux.Static = gosaas.NewStatic(os.DirFS("files"), "/files/")
+//
+// The asset template function of the pages served by the server uses its Static.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	if strings.HasPrefix(r.URL.Path, s.StaticDirectory) {
-		http.ServeFile(w, r, r.URL.Path[1:])
+	static := s.static()
+	if static != nil && strings.HasPrefix(r.URL.Path, static.Prefix) {
+		static.ServeHTTP(w, r)
 		return
 	}
 
 	ctx := r.Context()
 	ctx = context.WithValue(ctx, ContextOriginalPath, r.URL.Path)
 
+	if static != nil {
+		ctx = context.WithValue(ctx, ContextStatic, static)
+	}
+
 	isJSON := strings.ToLower(r.Header.Get("Content-Type")) == "application/json"
 	ctx = context.WithValue(ctx, ContextContentIsJSON, isJSON)
 
@@ -134,27 +174,82 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	ctx = context.WithValue(ctx, ContextMinimumRole, next.MinimumRole)
 
-	// make sure we are authenticating all calls
-	next.Handler = s.Authenticator(next.Handler)
+	if next.RateLimits != nil {
+		ctx = context.WithValue(ctx, ContextRateLimits, next.RateLimits)
+	}
 
-	if next.Logger {
-		next.Handler = s.Logger(next.Handler)
+	if next.CorsPolicy != nil {
+		ctx = context.WithValue(ctx, ContextCorsPolicy, next.CorsPolicy)
 	}
 
+	// the middlewares are applied on a copy of the route handler
+	// so they do not stack up on every request.
+	h := next.Handler
+
+	// idempotency keys are scoped per account, it needs to run
+	// after the authentication
+	if next.Idempotent && s.Idempotency != nil {
+		h = s.Idempotency(h)
+	}
+
+	// verify the CSRF token of HTML forms
+	if next.CSRFProtection && s.CSRF != nil {
+		h = s.CSRF(h)
+	}
+
+	// quotas are resolved from the authenticated account's plan
 	if next.EnforceRateLimit {
-		next.Handler = s.RateLimiter(next.Handler)
-		next.Handler = s.Throttler(next.Handler)
+		h = s.RateLimiter(h)
+		h = s.Throttler(h)
+	}
+
+	// make sure we are authenticating all calls
+	h = s.Authenticator(h)
+
+	if next.Logger {
+		h = s.Logger(h)
 	}
 
 	// are we allowing cross-origin requests for this route
 	if next.AllowCrossOrigin {
-		next.Handler = s.Cors(next.Handler)
+		h = s.Cors(h)
 	}
 
 	// are we using gzip compression on this route
 	if next.GzipCompression {
-		next.Handler = s.Gzip(next.Handler)
+		h = s.Gzip(h)
+	}
+
+	// security headers and CSRF token are added to all responses
+	if s.Security != nil {
+		h = s.Security(h)
 	}
 
-	next.Handler.ServeHTTP(w, r.WithContext(ctx))
+	h.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// static returns the Static serving the assets, the deprecated StaticDirectory
+// is served from its directory when it differs from the Static's prefix.
+func (s *Server) static() *Static {
+	prefix := s.StaticDirectory
+	if len(prefix) == 0 {
+		return s.Static
+	} else if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if s.Static != nil && s.Static.Prefix == prefix {
+		return s.Static
+	}
+
+	s.staticMu.Lock()
+	defer s.staticMu.Unlock()
+
+	if s.directory == nil || s.directory.Prefix != prefix {
+		dir := strings.Trim(prefix, "/")
+		if len(dir) == 0 {
+			dir = "."
+		}
+		s.directory = NewStatic(os.DirFS(dir), prefix)
+	}
+	return s.directory
 }
@@ -0,0 +1,264 @@
+package gosaas
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var staticAssets *Static
+
+// Static serves static assets from an fs.FS, which can be a directory via
+// os.DirFS or files embedded in the binary via embed.FS.
+//
+// Every response carries an ETag based on the file content. Files requested via
+// their fingerprinted URL (see URL) are cached for a year by browsers, the
+// others for MaxAge. When the client accepts it, a precompressed ".br" or ".gz"
+// sibling of the requested file is served instead.
+type Static struct {
+	// FS holds the files, paths are relative to its root.
+	FS fs.FS
+	// Prefix is the URL path the files are served from, i.e. "/public/".
+	Prefix string
+	// MaxAge is the Cache-Control max-age for non-fingerprinted URLs.
+	MaxAge time.Duration
+
+	mu     sync.RWMutex
+	hashes map[string]fileHash
+
+	// the page templates using this Static for their asset function
+	tmplMu      sync.RWMutex
+	tmpl        *template.Template
+	tmplVersion int
+}
+
+// fileHash is the content hash of a file, it is computed again when the size
+// or the modification time of the file change.
+type fileHash struct {
+	hash    string
+	size    int64
+	modTime time.Time
+}
+
+// NewStatic returns a Static serving the files of fsys under the URL prefix.
+//
+// Example serving embedded files:
+//
+//	//go:embed public
+//	var public embed.FS
+//
+//	sub, _ := fs.Sub(public, "public")
+//	mux.Static = gosaas.NewStatic(sub, "/public/")
+func NewStatic(fsys fs.FS, prefix string) *Static {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &Static{
+		FS:     fsys,
+		Prefix: prefix,
+		MaxAge: 1 * time.Hour,
+		hashes: make(map[string]fileHash),
+	}
+}
+
+// SetStaticAssets sets the Static used by AssetURL, the "asset" template
+// function of the pages served by a Server uses the Server's Static instead.
+func SetStaticAssets(s *Static) {
+	staticAssets = s
+}
+
+// AssetURL returns the content-hashed URL of a static file using the Static set
+// via SetStaticAssets. The asset function of the HTML templates returns it with
+// the Static of the Server:
+//
+//	<link rel="stylesheet" href="{{ asset "css/app.css" }}">
+func AssetURL(name string) string {
+	if staticAssets == nil {
+		return "/" + strings.TrimPrefix(name, "/")
+	}
+	return staticAssets.URL(name)
+}
+
+// URL returns the fingerprinted URL of a file, i.e. "css/app.css" becomes
+// "/public/css/app.5d41402abc4b.css". If the file cannot be read the
+// non-fingerprinted URL is returned.
+func (s *Static) URL(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	h, err := s.hash(name)
+	if err != nil {
+		return s.Prefix + name
+	}
+
+	ext := path.Ext(name)
+	return s.Prefix + strings.TrimSuffix(name, ext) + "." + h + ext
+}
+
+func (s *Static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, s.Prefix)), "/")
+	if !fs.ValidPath(name) || name == "." {
+		notFound(w)
+		return
+	}
+
+	immutable := false
+	if orig, fp, ok := splitFingerprint(name); ok {
+		if h, err := s.hash(orig); err == nil && h == fp {
+			name = orig
+			immutable = true
+		}
+	}
+
+	h, err := s.hash(name)
+	if err != nil {
+		notFound(w)
+		return
+	}
+
+	headers := w.Header()
+	headers.Add("Vary", "Accept-Encoding")
+	if immutable {
+		headers.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		headers.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.MaxAge.Seconds())))
+	}
+
+	file, etag := name, h
+	accept := r.Header.Get("Accept-Encoding")
+	for _, enc := range []struct{ name, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+		if !acceptsEncoding(accept, enc.name) {
+			continue
+		}
+		if fi, err := fs.Stat(s.FS, name+enc.ext); err == nil && !fi.IsDir() {
+			file, etag = name+enc.ext, h+"-"+enc.name
+			headers.Set("Content-Encoding", enc.name)
+			break
+		}
+	}
+	headers.Set("ETag", `"`+etag+`"`)
+
+	f, err := s.FS.Open(file)
+	if err != nil {
+		notFound(w)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		notFound(w)
+		return
+	}
+
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rs = bytes.NewReader(b)
+	}
+
+	// the original name is used so the Content-Type matches the uncompressed file
+	http.ServeContent(w, r, name, fi.ModTime(), rs)
+}
+
+// hash returns the content hash of a file. Hashes are cached until the size or
+// the modification time of the file change, files edited on disk get a new
+// ETag and fingerprint.
+func (s *Static) hash(name string) (string, error) {
+	fi, err := fs.Stat(s.FS, name)
+	if err != nil {
+		return "", err
+	} else if fi.IsDir() {
+		return "", fs.ErrNotExist
+	}
+
+	s.mu.RLock()
+	fh, ok := s.hashes[name]
+	s.mu.RUnlock()
+	if ok && fh.size == fi.Size() && fh.modTime.Equal(fi.ModTime()) {
+		return fh.hash, nil
+	}
+
+	b, err := fs.ReadFile(s.FS, name)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	fh = fileHash{hash: hex.EncodeToString(sum[:6]), size: fi.Size(), modTime: fi.ModTime()}
+
+	s.mu.Lock()
+	if s.hashes == nil {
+		s.hashes = make(map[string]fileHash)
+	}
+	s.hashes[name] = fh
+	s.mu.Unlock()
+
+	return fh.hash, nil
+}
+
+// splitFingerprint extracts the hash from a name like "css/app.5d41402abc4b.css".
+func splitFingerprint(name string) (orig, fp string, ok bool) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	i := strings.LastIndex(base, ".")
+	if i < 0 || len(base)-i-1 != 12 {
+		return "", "", false
+	}
+
+	fp = base[i+1:]
+	if _, err := hex.DecodeString(fp); err != nil {
+		return "", "", false
+	}
+	return base[:i] + ext, fp, true
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header accepts enc, a
+// q-value of 0, i.e. "gzip;q=0.0", refuses it.
+func acceptsEncoding(accept, enc string) bool {
+	for _, v := range strings.Split(accept, ",") {
+		params := strings.Split(v, ";")
+		if refused(params[1:]) {
+			continue
+		}
+		v = strings.TrimSpace(params[0])
+		if strings.EqualFold(v, enc) {
+			return true
+		}
+	}
+	return false
+}
+
+// refused reports whether the q-value of the coding parameters is 0.
+func refused(params []string) bool {
+	for _, p := range params {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return err == nil && q == 0
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package gosaas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestStatic() *Static {
+	fsys := fstest.MapFS{
+		"css/app.css":    {Data: []byte("body { color: red; }")},
+		"css/app.css.gz": {Data: []byte("gzipped")},
+	}
+	return NewStatic(fsys, "/public/")
+}
+
+func Test_Static_FingerprintedURL(t *testing.T) {
+	s := newTestStatic()
+
+	u := s.URL("css/app.css")
+	if !strings.HasPrefix(u, "/public/css/app.") || !strings.HasSuffix(u, ".css") || u == "/public/css/app.css" {
+		t.Fatalf("unexpected fingerprinted url %s", u)
+	}
+
+	req := httptest.NewRequest("GET", u, nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d got %d", http.StatusOK, rec.Code)
+	} else if !strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("expected an immutable Cache-Control got %s", rec.Header().Get("Cache-Control"))
+	} else if rec.Body.String() != "body { color: red; }" {
+		t.Errorf("unexpected body %s", rec.Body.String())
+	}
+}
+
+func Test_Static_ETagAndPrecompressed(t *testing.T) {
+	s := newTestStatic()
+
+	req := httptest.NewRequest("GET", "/public/css/app.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected gzip Content-Encoding got %s", rec.Header().Get("Content-Encoding"))
+	} else if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("expected text/css Content-Type got %s", rec.Header().Get("Content-Type"))
+	}
+
+	etag := rec.Header().Get("ETag")
+	if len(etag) == 0 {
+		t.Fatal("expected an ETag header")
+	}
+
+	req = httptest.NewRequest("GET", "/public/css/app.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected status %d got %d", http.StatusNotModified, rec.Code)
+	}
+}
+
+func Test_Static_NotFound(t *testing.T) {
+	s := newTestStatic()
+
+	for _, p := range []string{"/public/", "/public/css", "/public/../server.go", "/public/missing.js"} {
+		req := httptest.NewRequest("GET", p, nil)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %d got %d", p, http.StatusNotFound, rec.Code)
+		}
+	}
+}
+
+func Test_Static_AcceptsEncoding(t *testing.T) {
+	cases := []struct {
+		accept string
+		ok     bool
+	}{
+		{"gzip", true},
+		{"deflate, GZIP", true},
+		{"gzip;q=0.5", true},
+		{"gzip; q=1.0", true},
+		{"br", false},
+		{"gzip;q=0", false},
+		{"gzip;q=0.0", false},
+		{"gzip; q=0.000", false},
+		{"gzip;Q=0.00, br", false},
+	}
+	for _, c := range cases {
+		if ok := acceptsEncoding(c.accept, "gzip"); ok != c.ok {
+			t.Errorf("%s: expected %v got %v", c.accept, c.ok, ok)
+		}
+	}
+}
+
+func Test_Static_PerServer(t *testing.T) {
+	var static *Static
+	routes := map[string]*Route{
+		"page": {Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			static, _ = r.Context().Value(ContextStatic).(*Static)
+		})},
+	}
+	pass := func(h http.Handler) http.Handler { return h }
+
+	first := &Server{Authenticator: pass, Logger: pass, Routes: routes, Static: newTestStatic()}
+	second := &Server{Authenticator: pass, Logger: pass, Routes: routes, Static: NewStatic(fstest.MapFS{}, "/assets/")}
+
+	for _, s := range []*Server{first, second} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/page", nil))
+		if static != s.Static {
+			t.Errorf("expected the request to use the static of its server %s got %v", s.Static.Prefix, static)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	second.ServeHTTP(rec, httptest.NewRequest("GET", "/public/css/app.css", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func Test_Static_StaticDirectory(t *testing.T) {
+	s := &Server{Static: newTestStatic(), StaticDirectory: "/public/"}
+	if s.static() != s.Static {
+		t.Error("expected the Static of the same prefix to be used")
+	}
+
+	s.StaticDirectory = "/files"
+	static := s.static()
+	if static == s.Static || static.Prefix != "/files/" {
+		t.Fatalf("expected the static directory on /files/ got %s", static.Prefix)
+	} else if s.static() != static {
+		t.Error("expected the static directory to be reused")
+	}
+
+	s.Static = nil
+	if s.static() != static {
+		t.Error("expected the static directory without Static")
+	}
+}
+
+func Test_Static_FileChanged(t *testing.T) {
+	fsys := fstest.MapFS{
+		"js/app.js": {Data: []byte("console.log(1)"), ModTime: time.Unix(1600000000, 0)},
+	}
+	s := NewStatic(fsys, "/public/")
+
+	before := s.URL("js/app.js")
+	if again := s.URL("js/app.js"); again != before {
+		t.Fatalf("expected the same fingerprint got %s and %s", before, again)
+	}
+
+	// same size, the modification time changes
+	fsys["js/app.js"] = &fstest.MapFile{Data: []byte("console.log(2)"), ModTime: time.Unix(1600000060, 0)}
+	after := s.URL("js/app.js")
+	if after == before {
+		t.Errorf("expected a new fingerprint after the file changed, got %s", after)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", before, nil))
+	if strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Error("expected the old fingerprinted URL not to be immutable anymore")
+	}
+}
+
+func Test_Static_TemplatesParsedOnce(t *testing.T) {
+	s := newTestStatic()
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ContextStatic, s))
+
+	first := requestTemplates(req)
+	if first == nil || first == pageTemplates {
+		t.Fatal("expected the templates of the static")
+	} else if requestTemplates(req) != first {
+		t.Error("expected the templates to be parsed once")
+	}
+
+	LoadTemplates()
+	if reloaded := requestTemplates(req); reloaded == first || reloaded == pageTemplates {
+		t.Error("expected the templates to be parsed again after a reload")
+	}
+}
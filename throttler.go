@@ -10,47 +10,48 @@ import (
 
 // Throttler is a middleware used to throttle and apply rate limit to requests.
 //
-// This is currently set to 9999 calls limit per day. If the limit is reached
-// the middleware returns an error with the code StatusTooManyRequests.
+// The daily limit is resolved per account from its billing plan "dailyCalls" param,
+// see RateLimits. Anonymous requests are counted per client IP, see ClientIP, and
+// use the "publicRateLimits" quotas. If the limit is reached the middleware returns an error with
+// the code StatusTooManyRequests.
+//
+// Throttler counts the calls with the cache.FixedWindow algorithm, use NewThrottler
+// to choose another one.
 func Throttler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var keys Auth
-
-		ctx := r.Context()
-		v := ctx.Value(ContextAuth)
-		if v == nil {
-			keys = Auth{}
-		} else {
-			a, ok := v.(Auth)
-			if ok {
-				keys = a
-			}
-		}
+	return NewThrottler(cache.FixedWindow)(next)
+}
 
-		key := fmt.Sprintf("%v", keys.AccountID)
+// NewThrottler returns a Throttler middleware using the alg rate limiting algorithm.
+//
+//	mux := gosaas.NewServer(routes)
+//	mux.Throttler = gosaas.NewThrottler(cache.TokenBucket)
+func NewThrottler(alg cache.Algorithm) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
 
-		// TODO: Make this configurable
-		count, err := cache.Throttle(key, 24*time.Hour)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+			key := rateLimitKey(r) + "_t"
 
-		// TODO: Make this configurable
-		if count >= 9999 {
-			// we get the expiration duration of this key so we can notify the user
-			d, err := cache.GetThrottleExpiration(key)
+			limits := resolveRateLimits(ctx)
+			res, err := cache.Allow(key, alg, limits.DailyCalls, 24*time.Hour)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				Respond(w, r, http.StatusInternalServerError, err)
 				return
 			}
-			if d.Seconds() > 0 {
-				w.Header().Set("Retry-After", fmt.Sprintf("%d", int(d.Seconds())))
+
+			setRateLimitHeaders(w, res)
+
+			if !res.Allowed {
+				// we notify the user of how long before retrying
+				secs := ceilSeconds(res.RetryAfter)
+				if secs > 0 {
+					w.Header().Set("Retry-After", fmt.Sprintf("%d", secs))
+				}
+				Respond(w, r, http.StatusTooManyRequests, fmt.Errorf("you've reached your daily limit, retry in %ds", secs))
+				return
 			}
-			http.Error(w, fmt.Sprintf("you've reached your daily limit, retry in %v", d), http.StatusTooManyRequests)
-			return
-		}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/jlb922/gosaas/cron"
 	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
 	"github.com/jlb922/gosaas/model"
 )
 
@@ -32,6 +34,22 @@ func (t Tool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		t.reload(w, r)
 	} else if head == "profile" && r.Method == http.MethodGet {
 		t.profile(w, r)
+	} else if head == "queue" {
+		if isOperator(w, r) {
+			t.queue(w, r)
+		}
+	} else if head == "mail" {
+		if isOperator(w, r) {
+			t.mail(w, r)
+		}
+	} else if head == "trials" {
+		if isOperator(w, r) {
+			t.trials(w, r)
+		}
+	} else if head == "cron" && r.Method == http.MethodGet {
+		if isOperator(w, r) {
+			t.cron(w, r)
+		}
 	} else {
 		// route not Found
 		ServePage(w, r, "index.html", nil)
@@ -52,6 +70,17 @@ func (t Tool) reload(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// cron returns the cron jobs with their next and last runs.
+func (t Tool) cron(w http.ResponseWriter, r *http.Request) {
+	jobs, err := cron.Jobs()
+	if err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, jobs)
+}
+
 func (t Tool) profile(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
@@ -74,3 +103,20 @@ func (t Tool) profile(w http.ResponseWriter, r *http.Request) {
 
 	Respond(w, r, http.StatusOK, acct)
 }
+
+// isOperator returns if the request was made by an admin of one of the
+// operator accounts of the config, otherwise it responds with a
+// StatusForbidden error. The tools exposing every account's data require it,
+// the admins of the customer accounts are not operators.
+func isOperator(w http.ResponseWriter, r *http.Request) bool {
+	keys, ok := r.Context().Value(ContextAuth).(Auth)
+	if ok && keys.Role >= model.RoleAdmin {
+		for _, id := range config.Current.Operators {
+			if id == keys.AccountID {
+				return true
+			}
+		}
+	}
+	Respond(w, r, http.StatusForbidden, fmt.Errorf("this requires an operator account"))
+	return false
+}
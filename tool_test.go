@@ -0,0 +1,27 @@
+package gosaas
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jlb922/gosaas/cron"
+	"github.com/jlb922/gosaas/model"
+)
+
+func Test_ToolCron_RequiresOperator(t *testing.T) {
+	if rec := toolRequest(model.RoleAdmin, "GET", "/cron"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for an account admin got %d", rec.Code)
+	}
+
+	asOperator(t)
+	rec := toolRequest(model.RoleAdmin, "GET", "/cron")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var jobs []cron.JobInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &jobs); err != nil {
+		t.Fatal(err)
+	}
+}
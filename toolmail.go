@@ -0,0 +1,193 @@
+package gosaas
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/jlb922/gosaas/queue/email"
+)
+
+// mail handles the admin /tools/mail requests, the emails caught in dev mode
+// or with the catcher email provider:
+//
+//	GET    /tools/mail             lists the emails
+//	GET    /tools/mail/{id}        shows an email, its HTML and text parts
+//	GET    /tools/mail/{id}/html   returns the HTML part, sandboxed
+//	GET    /tools/mail/api?to=     returns the emails as JSON, optionally to an address
+//	GET    /tools/mail/api/{id}    returns an email as JSON
+//	DELETE /tools/mail/api         removes all emails
+func (t Tool) mail(w http.ResponseWriter, r *http.Request) {
+	mb := email.CurrentMailbox()
+	if mb == nil {
+		Respond(w, r, http.StatusNotFound, errors.New("the emails are only caught in dev mode or with the catcher email provider"))
+		return
+	}
+
+	var id, action string
+	id, r.URL.Path = ShiftPath(r.URL.Path)
+	action, r.URL.Path = ShiftPath(r.URL.Path)
+
+	if id == "api" {
+		t.mailAPI(w, r, mb, action)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		Respond(w, r, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	if len(id) == 0 {
+		list, err := mb.List(r.URL.Query().Get("to"))
+		if err != nil {
+			Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		renderMailPage(w, mailPageData{List: list, To: r.URL.Query().Get("to")})
+		return
+	}
+
+	c, err := mb.Get(id)
+	if err == email.ErrNotCaught {
+		Respond(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch action {
+	case "":
+		renderMailPage(w, mailPageData{Email: c})
+	case "html":
+		serveMailHTML(w, c.HTML)
+	default:
+		notFound(w)
+	}
+}
+
+func (t Tool) mailAPI(w http.ResponseWriter, r *http.Request, mb email.Mailbox, id string) {
+	switch {
+	case len(id) == 0 && r.Method == http.MethodGet:
+		list, err := mb.List(r.URL.Query().Get("to"))
+		if err != nil {
+			Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		Respond(w, r, http.StatusOK, list)
+	case len(id) == 0 && r.Method == http.MethodDelete:
+		if err := mb.Clear(); err != nil {
+			Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		Respond(w, r, http.StatusOK, true)
+	case r.Method == http.MethodGet:
+		c, err := mb.Get(id)
+		if err == email.ErrNotCaught {
+			Respond(w, r, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		Respond(w, r, http.StatusOK, c)
+	default:
+		Respond(w, r, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// serveMailHTML writes the HTML part of an email, it is displayed in a frame
+// of the mail page. Scripts are disabled and the links open in the top window.
+func serveMailHTML(w http.ResponseWriter, body string) {
+	base := `<base target="_top">`
+	if i := strings.Index(strings.ToLower(body), "<head>"); i >= 0 {
+		body = body[:i+len("<head>")] + base + body[i+len("<head>"):]
+	} else {
+		body = base + body
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Security-Policy", "sandbox allow-popups allow-popups-to-escape-sandbox allow-top-navigation-by-user-activation; frame-ancestors 'self'")
+	h.Set("X-Frame-Options", "SAMEORIGIN")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(body))
+}
+
+type mailPageData struct {
+	List  []email.Caught
+	To    string
+	Email *email.Caught
+}
+
+func renderMailPage(w http.ResponseWriter, data mailPageData) {
+	// the page only uses its own inline styles
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'; img-src * data:")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := mailPage.Execute(w, data); err != nil {
+		log.Println("unable to render the mail page", err)
+	}
+}
+
+var textLink = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// linkify escapes the text part and turns its URLs into links.
+func linkify(text string) template.HTML {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range textLink.FindAllStringIndex(text, -1) {
+		sb.WriteString(template.HTMLEscapeString(text[last:loc[0]]))
+		u := template.HTMLEscapeString(text[loc[0]:loc[1]])
+		sb.WriteString(`<a href="` + u + `">` + u + `</a>`)
+		last = loc[1]
+	}
+	sb.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(sb.String())
+}
+
+var mailPage = template.Must(template.New("mail").Funcs(template.FuncMap{"linkify": linkify}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Mail</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4em .6em; border-bottom: 1px solid #ddd; }
+dt { font-weight: bold; float: left; width: 6em; }
+dd { margin-left: 7em; }
+iframe { width: 100%; height: 32em; border: 1px solid #ddd; }
+pre { white-space: pre-wrap; background: #f6f6f6; padding: 1em; }
+</style>
+</head>
+<body>
+{{ with .Email }}
+<p><a href="/tools/mail">&larr; All emails</a></p>
+<h1>{{ .Subject }}</h1>
+<dl>
+<dt>From</dt><dd>{{ .FromName }} &lt;{{ .From }}&gt;</dd>
+<dt>To</dt><dd>{{ .ToName }} &lt;{{ .To }}&gt;</dd>
+{{ if .ReplyTo }}<dt>Reply-To</dt><dd>{{ .ReplyTo }}</dd>{{ end }}
+<dt>Sent</dt><dd>{{ .Sent.Format "2006-01-02 15:04:05" }}</dd>
+{{ if .Tags }}<dt>Tags</dt><dd>{{ range .Tags }}{{ . }} {{ end }}</dd>{{ end }}
+</dl>
+{{ if .HTML }}<h2>HTML</h2>
+<iframe src="/tools/mail/{{ .ID }}/html" sandbox="allow-popups allow-popups-to-escape-sandbox allow-top-navigation-by-user-activation"></iframe>{{ end }}
+{{ if .Text }}<h2>Text</h2>
+<pre>{{ linkify .Text }}</pre>{{ end }}
+{{ else }}
+<h1>Mail</h1>
+<form method="get" action="/tools/mail"><input type="email" name="to" value="{{ .To }}" placeholder="Recipient"> <button>Filter</button></form>
+<table>
+<tr><th>Sent</th><th>To</th><th>Subject</th></tr>
+{{ range .List }}<tr><td>{{ .Sent.Format "2006-01-02 15:04:05" }}</td><td>{{ .To }}</td><td><a href="/tools/mail/{{ .ID }}">{{ .Subject }}</a></td></tr>
+{{ else }}<tr><td colspan="3">No emails caught yet.</td></tr>
+{{ end }}</table>
+{{ end }}
+</body>
+</html>
+`))
@@ -0,0 +1,96 @@
+package gosaas
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/queue/email"
+)
+
+func Test_ToolMail_RequiresOperator(t *testing.T) {
+	rec := toolRequest(model.RoleUser, "GET", "/mail")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 got %d", rec.Code)
+	}
+
+	// the admins of the customer accounts are not operators
+	rec = toolRequest(model.RoleAdmin, "GET", "/mail/api?to=you@test.com")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for an account admin got %d", rec.Code)
+	}
+}
+
+func Test_ToolMail_PagesAndAPI(t *testing.T) {
+	asOperator(t)
+	email.SetMailbox(nil)
+	if rec := toolRequest(model.RoleAdmin, "GET", "/mail"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without mailbox got %d", rec.Code)
+	}
+
+	mb := email.NewMemoryMailbox()
+	email.SetMailbox(mb)
+	defer email.SetMailbox(nil)
+
+	m, err := email.Render(email.TemplateReset, "en", map[string]interface{}{"URL": "http://localhost:8080/users/reset?id=1&token=abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.From, m.To = "me@test.com", "you@test.com"
+	id, err := (&email.Catcher{}).SendMessage(*m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := toolRequest(model.RoleAdmin, "GET", "/mail")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `href="/tools/mail/`+id+`"`) {
+		t.Fatalf("expected the email in the list, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = toolRequest(model.RoleAdmin, "GET", "/mail/"+id)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, `src="/tools/mail/`+id+`/html"`) ||
+		!strings.Contains(body, `<a href="http://localhost:8080/users/reset?id=1&amp;token=abc">`) {
+		t.Errorf("expected the HTML frame and the text link, got %d: %s", rec.Code, body)
+	}
+
+	rec = toolRequest(model.RoleAdmin, "GET", "/mail/"+id+"/html")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<base target="_top">`) ||
+		!strings.HasPrefix(rec.Header().Get("Content-Security-Policy"), "sandbox") {
+		t.Errorf("expected the sandboxed HTML part, got %d %v", rec.Code, rec.Header())
+	}
+
+	rec = toolRequest(model.RoleAdmin, "GET", "/mail/api?to=you@test.com")
+	var list []email.Caught
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 1 || list[0].ID != id || !strings.Contains(list[0].Text, "token=abc") {
+		t.Errorf("unexpected emails %+v", list)
+	}
+
+	rec = toolRequest(model.RoleAdmin, "GET", "/mail/api?to=other@test.com")
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list) != 0 {
+		t.Errorf("expected no emails to other@test.com, got %+v %v", list, err)
+	}
+
+	if rec := toolRequest(model.RoleAdmin, "GET", "/mail/api/"+id); rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 got %d", rec.Code)
+	}
+
+	if rec := toolRequest(model.RoleAdmin, "DELETE", "/mail/api"); rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 got %d", rec.Code)
+	}
+	if rec := toolRequest(model.RoleAdmin, "GET", "/mail/api/"+id); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 after clearing got %d", rec.Code)
+	}
+}
+
+func Test_ToolMail_Linkify(t *testing.T) {
+	got := string(linkify("Reset: https://app.test/reset?a=1&b=<2> done"))
+	want := `Reset: <a href="https://app.test/reset?a=1&amp;b=">https://app.test/reset?a=1&amp;b=</a>&lt;2&gt; done`
+	if got != want {
+		t.Errorf("expected %s got %s", want, got)
+	}
+}
@@ -0,0 +1,94 @@
+package gosaas
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jlb922/gosaas/queue"
+)
+
+// queue handles the admin /tools/queue requests:
+//
+//	GET    /tools/queue?status=dead&offset=0&limit=50 lists the tasks
+//	DELETE /tools/queue?status=dead                   purges the tasks with this status
+//	GET    /tools/queue/{uuid}                        returns a task status
+//	POST   /tools/queue/{uuid}/retry                  retries a dead or failed task
+//	DELETE /tools/queue/{uuid}                        purges a task
+func (t Tool) queue(w http.ResponseWriter, r *http.Request) {
+	var uuid, action string
+	uuid, r.URL.Path = ShiftPath(r.URL.Path)
+	action, r.URL.Path = ShiftPath(r.URL.Path)
+
+	if len(uuid) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			t.listTasks(w, r)
+		case http.MethodDelete:
+			t.purgeTasks(w, r)
+		default:
+			Respond(w, r, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		}
+		return
+	}
+
+	var err error
+	if action == "retry" && r.Method == http.MethodPost {
+		err = queue.Retry(uuid)
+	} else if len(action) == 0 && r.Method == http.MethodDelete {
+		err = queue.Purge(uuid)
+	} else if len(action) == 0 && r.Method == http.MethodGet {
+		var ti *queue.TaskInfo
+		if ti, err = queue.Inspect(uuid); err == nil {
+			Respond(w, r, http.StatusOK, ti)
+			return
+		}
+	} else {
+		Respond(w, r, http.StatusNotFound, fmt.Errorf("unknown queue action %s %s", r.Method, action))
+		return
+	}
+
+	if err == queue.ErrTaskNotFound {
+		Respond(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, true)
+}
+
+func (t Tool) listTasks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	tasks, err := queue.Tasks(queue.Status(q.Get("status")), offset, limit)
+	if err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, tasks)
+}
+
+func (t Tool) purgeTasks(w http.ResponseWriter, r *http.Request) {
+	status := queue.Status(r.URL.Query().Get("status"))
+	if len(status) == 0 {
+		Respond(w, r, http.StatusBadRequest, fmt.Errorf("the status of the tasks to purge is required"))
+		return
+	}
+
+	n, err := queue.PurgeStatus(status)
+	if err != nil {
+		Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, n)
+}
@@ -0,0 +1,92 @@
+package gosaas
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jlb922/gosaas/cache"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/queue"
+)
+
+func toolRequest(role model.Roles, method, path string) *httptest.ResponseRecorder {
+	ctx := context.WithValue(context.Background(), ContextAuth, Auth{AccountID: 1, UserID: 1, Role: role})
+	ctx = context.WithValue(ctx, ContextContentIsJSON, true)
+
+	req := httptest.NewRequest(method, path, nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	Tool{}.ServeHTTP(rec, req)
+	return rec
+}
+
+// asOperator makes the account of the tool requests an operator account.
+func asOperator(t *testing.T) {
+	ops := config.Current.Operators
+	config.Current.Operators = []int64{1}
+	t.Cleanup(func() { config.Current.Operators = ops })
+}
+
+func Test_ToolQueue_RequiresOperator(t *testing.T) {
+	rec := toolRequest(model.RoleUser, "GET", "/queue")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 got %d", rec.Code)
+	}
+
+	// the admins of the customer accounts are not operators
+	rec = toolRequest(model.RoleAdmin, "GET", "/queue")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for an account admin got %d", rec.Code)
+	}
+
+	asOperator(t)
+	rec = toolRequest(model.RoleUser, "GET", "/queue")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for an operator user got %d", rec.Code)
+	}
+}
+
+func Test_ToolQueue_InspectAndPurge(t *testing.T) {
+	cache.New(false, true, nil)
+	asOperator(t)
+
+	uuid, err := queue.Enqueue("test.tool", map[string]int{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := toolRequest(model.RoleAdmin, "GET", "/queue/"+uuid)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var ti queue.TaskInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &ti); err != nil {
+		t.Fatal(err)
+	} else if ti.UUID != uuid || ti.Status != queue.StatusQueued || ti.ID != "test.tool" {
+		t.Errorf("unexpected task %+v", ti)
+	}
+
+	rec = toolRequest(model.RoleAdmin, "POST", "/queue/"+uuid+"/retry")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 retrying a queued task got %d", rec.Code)
+	}
+
+	rec = toolRequest(model.RoleAdmin, "DELETE", "/queue/"+uuid)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = toolRequest(model.RoleAdmin, "GET", "/queue/"+uuid)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 got %d", rec.Code)
+	}
+
+	rec = toolRequest(model.RoleAdmin, "DELETE", "/queue")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 purging without a status got %d", rec.Code)
+	}
+}
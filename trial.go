@@ -0,0 +1,204 @@
+package gosaas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jlb922/gosaas/cron"
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/queue/email"
+)
+
+// defaultTrialEndingDays is how many days before its end the trial ending
+// soon email is sent when it is not configured.
+const defaultTrialEndingDays = 3
+
+func init() {
+	if err := cron.Register("@hourly", "gosaas.trials", runTrials); err != nil {
+		log.Println("unable to register the trials job", err)
+	}
+}
+
+// startTrial starts the trial of the configured plan for a new account.
+func startTrial(db *data.DB, account *model.Account) error {
+	name := config.Current.Trial.Plan
+	if len(name) == 0 {
+		return nil
+	}
+
+	plan, ok := data.FindPlan(name)
+	if !ok {
+		return fmt.Errorf("unable to find the trial plan %s", name)
+	} else if plan.TrialDays <= 0 {
+		return nil
+	}
+
+	start := time.Now().UTC().Truncate(time.Second)
+	trial := model.Trial{
+		IsTrial: true,
+		Plan:    plan.ID,
+		Start:   start,
+		End:     start.AddDate(0, 0, plan.TrialDays),
+	}
+	if err := db.Users.SetTrial(account.ID, trial); err != nil {
+		return fmt.Errorf("unable to start the trial of this account %v: %v", account.ID, err)
+	}
+	account.TrialInfo = trial
+
+	cacheAccountPlan(account.ID, activePlan(account))
+	return nil
+}
+
+// extendedTrial returns the trial of the account extended by days, an ended
+// trial is extended from now.
+func extendedTrial(account *model.Account, days int) (model.Trial, error) {
+	trial := account.TrialInfo
+	if days <= 0 {
+		return trial, fmt.Errorf("the trial must be extended by at least one day")
+	} else if len(trial.Plan) == 0 {
+		return trial, fmt.Errorf("this account %v never had a trial", account.ID)
+	} else if account.IsPaid() {
+		return trial, fmt.Errorf("this account %v is already paying", account.ID)
+	}
+
+	end := trial.End
+	if now := time.Now().UTC().Truncate(time.Second); end.Before(now) {
+		end = now
+	}
+
+	trial.IsTrial = true
+	trial.End = end.AddDate(0, 0, days)
+	trial.Extended += days
+	trial.Reminded = false
+	return trial, nil
+}
+
+// runTrials is the hourly job ending the expired trials and sending the trial
+// ending soon emails.
+func runTrials(ctx context.Context) error {
+	db := currentBillingDB()
+	if db == nil {
+		return errors.New("the billing database is not set, see SetBillingDB")
+	}
+
+	days := config.Current.Trial.EndingDays
+	if days <= 0 {
+		days = defaultTrialEndingDays
+	}
+
+	now := time.Now()
+	accounts, err := db.Users.Trials(now.AddDate(0, 0, days))
+	if err != nil {
+		return err
+	}
+
+	var last error
+	for i := range accounts {
+		account := &accounts[i]
+
+		if account.TrialInfo.IsActive(now) {
+			err = remindTrial(db, account)
+		} else {
+			err = endTrial(db, account)
+		}
+		if err != nil {
+			log.Println("unable to process the trial of this account", account.ID, err)
+			last = err
+		}
+	}
+	return last
+}
+
+// remindTrial sends the trial ending soon email once.
+func remindTrial(db *data.DB, account *model.Account) error {
+	if account.TrialInfo.Reminded {
+		return nil
+	}
+
+	trial := account.TrialInfo
+	trial.Reminded = true
+	if err := db.Users.SetTrial(account.ID, trial); err != nil {
+		return err
+	}
+
+	sendBillingEmail(account, email.TemplateTrialEnding, model.EmailBilling, map[string]interface{}{
+		"Date": formatBillingDate(trial.End.Unix()),
+		"URL":  config.Current.AppURL,
+	})
+	return nil
+}
+
+// endTrial ends the expired trial, the account is back on its own plan.
+func endTrial(db *data.DB, account *model.Account) error {
+	trial := account.TrialInfo
+	trial.IsTrial = false
+	if err := db.Users.SetTrial(account.ID, trial); err != nil {
+		return err
+	}
+	account.TrialInfo = trial
+	cacheAccountPlan(account.ID, activePlan(account))
+
+	plan := trial.Plan
+	if p, ok := data.GetPlan(trial.Plan); ok {
+		plan = p.Name
+	}
+	sendBillingEmail(account, email.TemplateTrialEnded, model.EmailTransactional, map[string]interface{}{
+		"Plan": plan,
+		"URL":  config.Current.AppURL,
+	})
+	return nil
+}
+
+// trials handles the admin /tools/trials requests:
+//
+//	POST /tools/trials/{accountID}/extend?days=7 extends the account's trial
+func (t Tool) trials(w http.ResponseWriter, r *http.Request) {
+	var head, action string
+	head, r.URL.Path = ShiftPath(r.URL.Path)
+	action, r.URL.Path = ShiftPath(r.URL.Path)
+
+	id, err := strconv.ParseInt(head, 10, 64)
+	if err != nil || action != "extend" || r.Method != http.MethodPost {
+		Respond(w, r, http.StatusNotFound, fmt.Errorf("unknown trials action %s %s", r.Method, action))
+		return
+	}
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil {
+		Respond(w, r, http.StatusBadRequest, fmt.Errorf("the days parameter should be a number of days: %v", err))
+		return
+	}
+
+	db := r.Context().Value(ContextDatabase).(*data.DB)
+	account, err := db.Users.GetDetail(id)
+	if err == sql.ErrNoRows {
+		Respond(w, r, http.StatusNotFound, fmt.Errorf("unable to find this account %d", id))
+		return
+	} else if err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	trial, err := extendedTrial(account, days)
+	if err != nil {
+		Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := db.Users.SetTrial(account.ID, trial); err != nil {
+		Respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	account.TrialInfo = trial
+	cacheAccountPlan(account.ID, activePlan(account))
+
+	Respond(w, r, http.StatusOK, trial)
+}
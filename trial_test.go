@@ -0,0 +1,186 @@
+package gosaas
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jlb922/gosaas/cache"
+	"github.com/jlb922/gosaas/data"
+	"github.com/jlb922/gosaas/internal/config"
+	"github.com/jlb922/gosaas/model"
+	"github.com/jlb922/gosaas/queue/email"
+)
+
+func trialRequest(db *data.DB, role model.Roles, path string) *httptest.ResponseRecorder {
+	ctx := context.WithValue(context.Background(), ContextAuth, Auth{AccountID: 1, UserID: 1, Role: role})
+	ctx = context.WithValue(ctx, ContextContentIsJSON, true)
+	ctx = context.WithValue(ctx, ContextDatabase, db)
+
+	req := httptest.NewRequest("POST", path, nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	Tool{}.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_Trial_StartAtSignUp(t *testing.T) {
+	cache.New(false, true, nil)
+
+	defer func(c config.TrialConfig) { config.Current.Trial = c }(config.Current.Trial)
+	data.AddPlan(data.BillingPlan{ID: "unit_trial", Name: "trial", Version: "current", TrialDays: 14})
+	data.AddPlan(data.BillingPlan{ID: "unit_no_trial", Name: "no_trial", Version: "current"})
+
+	id := time.Now().UnixNano()
+	ta := &testAccounts{accounts: map[int64]*model.Account{id: {ID: id, Email: "trial@test.com"}}}
+	db := &data.DB{Users: ta}
+
+	config.Current.Trial = config.TrialConfig{Plan: "no_trial"}
+	if err := startTrial(db, ta.accounts[id]); err != nil {
+		t.Fatal(err)
+	} else if ta.accounts[id].TrialInfo.IsTrial {
+		t.Error("expected no trial for a plan without trial days")
+	}
+
+	config.Current.Trial = config.TrialConfig{Plan: "trial"}
+	acct, _ := ta.GetDetail(id)
+	if err := startTrial(db, acct); err != nil {
+		t.Fatal(err)
+	}
+
+	trial := ta.accounts[id].TrialInfo
+	if !trial.IsActive(time.Now()) || trial.Plan != "unit_trial" || !trial.End.Equal(trial.Start.AddDate(0, 0, 14)) {
+		t.Errorf("expected a 14 days trial of unit_trial, got %+v", trial)
+	}
+	if plan, _ := cache.GetAccountPlan(id); plan != "unit_trial" {
+		t.Errorf("expected the account entitled to the trial plan, got %s", plan)
+	}
+
+	// the ended trial does not entitle to its plan anymore
+	acct.TrialInfo.End = time.Now().Add(-time.Minute)
+	if plan := activePlan(acct); plan != "" {
+		t.Errorf("expected no plan after the trial, got %s", plan)
+	}
+}
+
+func Test_Trial_Job(t *testing.T) {
+	cache.New(false, true, nil)
+
+	defer func(c config.TrialConfig) { config.Current.Trial = c }(config.Current.Trial)
+	config.Current.Trial = config.TrialConfig{EndingDays: 3}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	base := now.UnixNano()
+	ending, ended, later := base+1, base+2, base+3
+	ta := &testAccounts{accounts: map[int64]*model.Account{
+		ending: {ID: ending, Email: "trial-ending@test.com", TrialInfo: model.Trial{IsTrial: true, Plan: "unit_pro", End: now.AddDate(0, 0, 2)}},
+		ended:  {ID: ended, Email: "trial-ended@test.com", TrialInfo: model.Trial{IsTrial: true, Plan: "unit_pro", End: now.Add(-time.Hour)}},
+		later:  {ID: later, Email: "trial-later@test.com", TrialInfo: model.Trial{IsTrial: true, Plan: "unit_pro", End: now.AddDate(0, 0, 10)}},
+	}}
+	SetBillingDB(&data.DB{Users: ta})
+	defer SetBillingDB(nil)
+	cacheAccountPlan(ended, "unit_pro")
+	for _, a := range ta.accounts {
+		queuedEmails(t, a.Email)
+	}
+
+	if err := runTrials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if templates := queuedEmails(t, "trial-ending@test.com"); fmt.Sprint(templates) != fmt.Sprint([]string{email.TemplateTrialEnding}) {
+		t.Errorf("expected the trial ending email, got %v", templates)
+	} else if !ta.accounts[ending].TrialInfo.Reminded || !ta.accounts[ending].TrialInfo.IsTrial {
+		t.Errorf("expected the trial to be reminded, got %+v", ta.accounts[ending].TrialInfo)
+	}
+
+	if templates := queuedEmails(t, "trial-ended@test.com"); fmt.Sprint(templates) != fmt.Sprint([]string{email.TemplateTrialEnded}) {
+		t.Errorf("expected the trial ended email, got %v", templates)
+	} else if ta.accounts[ended].TrialInfo.IsTrial {
+		t.Errorf("expected the trial to end, got %+v", ta.accounts[ended].TrialInfo)
+	}
+	if plan, _ := cache.GetAccountPlan(ended); plan != "" {
+		t.Errorf("expected the account back on its plan, got %s", plan)
+	}
+
+	if templates := queuedEmails(t, "trial-later@test.com"); len(templates) > 0 {
+		t.Errorf("expected no email, got %v", templates)
+	}
+
+	// the emails are sent once
+	if err := runTrials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range ta.accounts {
+		if templates := queuedEmails(t, a.Email); len(templates) > 0 {
+			t.Errorf("%s: expected no email, got %v", a.Email, templates)
+		}
+	}
+}
+
+func Test_Trial_Extend(t *testing.T) {
+	cache.New(false, true, nil)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	base := now.UnixNano()
+	active, ended, paid := base+1, base+2, base+3
+	ta := &testAccounts{accounts: map[int64]*model.Account{
+		active: {ID: active, TrialInfo: model.Trial{IsTrial: true, Plan: "unit_pro", End: now.AddDate(0, 0, 2), Reminded: true}},
+		ended:  {ID: ended, TrialInfo: model.Trial{Plan: "unit_pro", End: now.AddDate(0, 0, -5)}},
+		paid:   {ID: paid, StripeID: "cus_paid", SubscriptionID: "sub_paid", TrialInfo: model.Trial{Plan: "unit_pro"}},
+	}}
+	db := &data.DB{Users: ta}
+
+	if rec := trialRequest(db, model.RoleUser, fmt.Sprintf("/trials/%d/extend?days=7", active)); rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 got %d", rec.Code)
+	}
+
+	// the admins of the customer accounts cannot extend a trial, their own included
+	own := &model.Account{ID: 1, TrialInfo: model.Trial{IsTrial: true, Plan: "unit_pro", End: now.AddDate(0, 0, 2)}}
+	ta.accounts[own.ID] = own
+	if rec := trialRequest(db, model.RoleAdmin, "/trials/1/extend?days=7"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for an account admin got %d", rec.Code)
+	} else if !own.TrialInfo.End.Equal(now.AddDate(0, 0, 2)) {
+		t.Errorf("expected the trial unchanged, got %+v", own.TrialInfo)
+	}
+
+	asOperator(t)
+	delete(ta.accounts, own.ID)
+
+	rec := trialRequest(db, model.RoleAdmin, fmt.Sprintf("/trials/%d/extend?days=7", active))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var trial model.Trial
+	if err := json.Unmarshal(rec.Body.Bytes(), &trial); err != nil {
+		t.Fatal(err)
+	}
+	if !trial.End.Equal(now.AddDate(0, 0, 9)) || trial.Extended != 7 || trial.Reminded {
+		t.Errorf("expected the trial extended by 7 days, got %+v", trial)
+	}
+
+	rec = trialRequest(db, model.RoleAdmin, fmt.Sprintf("/trials/%d/extend?days=3", ended))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	if tr := ta.accounts[ended].TrialInfo; !tr.IsActive(time.Now()) || tr.End.Before(now.AddDate(0, 0, 3)) {
+		t.Errorf("expected the ended trial extended from now, got %+v", tr)
+	}
+	if plan, _ := cache.GetAccountPlan(ended); plan != "unit_pro" {
+		t.Errorf("expected the account entitled to the trial plan, got %s", plan)
+	}
+
+	if rec := trialRequest(db, model.RoleAdmin, fmt.Sprintf("/trials/%d/extend?days=7", paid)); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a paying account got %d", rec.Code)
+	}
+	if rec := trialRequest(db, model.RoleAdmin, fmt.Sprintf("/trials/%d/extend?days=0", active)); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without days got %d", rec.Code)
+	}
+	if rec := trialRequest(db, model.RoleAdmin, "/trials/0/extend?days=7"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown account got %d", rec.Code)
+	}
+}
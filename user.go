@@ -4,17 +4,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jlb922/gosaas/cache"
 	"github.com/jlb922/gosaas/data"
 	"github.com/jlb922/gosaas/internal/config"
 	"github.com/jlb922/gosaas/model"
 	"github.com/jlb922/gosaas/queue"
+	"github.com/jlb922/gosaas/queue/email"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// forgotEmailsPerHour is the number of password reset emails an address can receive per hour.
+const forgotEmailsPerHour = 3
+
 type pageData struct {
 	Title  string
 	Header string
@@ -33,9 +42,11 @@ func newUser() *Route {
 	return &Route{
 		AllowCrossOrigin: true,
 		Logger:           true,
+		EnforceRateLimit: true,
 		MinimumRole:      model.RolePublic,
 		WithDB:           true,
 		GzipCompression:  true,
+		CSRFProtection:   true,
 		Handler:          u.(http.Handler),
 	}
 }
@@ -206,6 +217,26 @@ func (u User) sendReset(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		data.Email = r.Form.Get("email")
 	}
+
+	// we limit the number of reset emails per address to contain spam
+	key := "forgot_" + strings.ToLower(strings.TrimSpace(data.Email))
+	if res, err := cache.Allow(key, cache.FixedWindow, forgotEmailsPerHour, time.Hour); err != nil {
+		log.Println("unable to rate limit password reset", err)
+	} else if !res.Allowed {
+		err := fmt.Errorf("too many password reset requests, retry in %d minutes", ceilSeconds(res.RetryAfter)/60+1)
+		if isJSON {
+			Respond(w, r, http.StatusTooManyRequests, err)
+		} else {
+			alert := Notification{
+				Title:   "Notice",
+				Message: err.Error(),
+				IsError: true,
+			}
+			ServePage(w, r, config.Current.ForgotLoginTemplate, CreateViewData(ctx, &alert, nil))
+		}
+		return
+	}
+
 	// check if user exists and grab info for user ID
 	user, err := db.Users.GetUserByEmail(data.Email)
 	if err != nil {
@@ -248,7 +279,7 @@ func (u User) sendReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// send email with link to user
-	u.sendForgotEmail(user.ID, data.Email, string(b))
+	u.sendForgotEmail(getLanguage(ctx), user.ID, data.Email, string(b))
 	alert := Notification{
 		Title:     "Success",
 		Message:   "Password email sent",
@@ -338,8 +369,12 @@ func (u User) create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := startTrial(db, acct); err != nil {
+		log.Println(err)
+	}
+
 	if config.Current.SignUpSendEmailValidation {
-		u.sendEmail(acct.Email, data.Password)
+		u.sendEmail(getLanguage(ctx), acct.Email)
 	}
 
 	if isJSON {
@@ -359,32 +394,33 @@ func (u User) create(w http.ResponseWriter, r *http.Request) {
 }
 
 // Send password reset link to user
-func (u User) sendForgotEmail(id int64, email, pass string) {
-	fmt.Println("TODO update u.sendEmail to use config values")
-
-	//Body:    "<html><body>Use this <a href=localhost:8080/users/reset?id=" + strconv.FormatInt(id, 10) + "&token=" + pass + ">link</a> to reset your password.</html></body>",
+func (u User) sendForgotEmail(lng string, id int64, to, pass string) {
+	link := fmt.Sprintf("%s/users/reset?id=%d&token=%s", strings.TrimSuffix(config.Current.AppURL, "/"), id, url.QueryEscape(pass))
 	emailInfo := queue.SendEmailParameter{
-		From:    config.Current.EmailFrom,
-		To:      email,
-		Subject: "Password reset link",
-		Body:    "localhost:8080/users/reset?id=" + strconv.FormatInt(id, 10) + "&token=" + pass,
+		From:     config.Current.EmailFrom,
+		FromName: config.Current.EmailFromName,
+		To:       to,
+		Template: email.TemplateReset,
+		Language: lng,
+		Data:     map[string]interface{}{"URL": link},
+	}
+	if _, err := queue.Enqueue(queue.TaskEmail, emailInfo); err != nil {
+		log.Println("unable to queue the password reset email", err)
 	}
-	// fmt.Println(emailInfo.Body)
-	queue.Enqueue(queue.TaskEmail, emailInfo)
 }
 
-func (u User) sendEmail(email, pass string) {
-	//TODO: implement this
-	fmt.Println("TODO update u.sendEmail to use config values")
+func (u User) sendEmail(lng, to string) {
 	emailInfo := queue.SendEmailParameter{
-		From:    config.Current.EmailFrom,
-		To:      email,
-		Subject: "Welcome to the SAAS",
-		Body:    "This is the email body",
+		From:     config.Current.EmailFrom,
+		FromName: config.Current.EmailFromName,
+		To:       to,
+		Template: email.TemplateWelcome,
+		Language: lng,
+		Data:     map[string]interface{}{"URL": config.Current.AppURL},
+	}
+	if _, err := queue.Enqueue(queue.TaskEmail, emailInfo); err != nil {
+		log.Println("unable to queue the welcome email", err)
 	}
-
-	queue.Enqueue(queue.TaskEmail, emailInfo)
-	//queue.Enqueue(queue.TaskEmail, queue.SendEmailParameter{})
 }
 
 // login presents the login form and calls signin after POST
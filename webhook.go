@@ -77,6 +77,30 @@ func SendWebhook(wh data.WebhookServices, event string, data interface{}) {
 	}
 }
 
+// SendAccountWebhook posts data to the subscribers of an event belonging to
+// the account, i.e. its billing events.
+func SendAccountWebhook(wh data.WebhookServices, accountID int64, event string, data interface{}) {
+	headers := map[string]string{"X-Webhook-Event": event}
+
+	subscribers, err := wh.AllSubscriptions(event)
+	if err != nil {
+		log.Println("unable to get webhook subscribers for ", event)
+		return
+	}
+
+	for _, sub := range subscribers {
+		if sub.AccountID != accountID {
+			continue
+		}
+
+		go func(sub model.Webhook) {
+			if err := post(sub.TargetURL, data, nil, headers); err != nil {
+				log.Println("error calling URL", sub.TargetURL, err)
+			}
+		}(sub)
+	}
+}
+
 // Webhook handles everything related to the /webhooks requests
 //
 // POST /webhooks -> subscribe to events
@@ -88,6 +112,7 @@ func newWebhook() *Route {
 	var wh interface{} = Webhook{}
 	return &Route{
 		Logger:      true,
+		Idempotent:  true,
 		MinimumRole: model.RoleFree,
 		Handler:     wh.(http.Handler),
 	}